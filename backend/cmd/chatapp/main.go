@@ -6,12 +6,15 @@ import (
 	"net/http"
 	"time"
 
+	"chatapp/internal/admin"
 	"chatapp/internal/chatroom"
 	"chatapp/internal/config"
 	"chatapp/internal/db"
+	"chatapp/internal/dm"
 	"chatapp/internal/events"
 	server "chatapp/internal/http"
 	"chatapp/internal/message"
+	"chatapp/internal/presence"
 	"chatapp/internal/user"
 	"chatapp/internal/ws"
 )
@@ -38,6 +41,15 @@ func main() {
 	if err := db.BuildIndexes(startupCtx, database); err != nil {
 		log.Fatalf("failed ensuring indexes: %v", err)
 	}
+	if err := db.Migrate(startupCtx, database); err != nil {
+		log.Fatalf("failed migrating data: %v", err)
+	}
+
+	amq, err := events.NewAMQP(startupCtx, cfg.RabbitMQURI, "chat.events")
+	if err != nil {
+		log.Fatalf("failed to connect rabbitmq: %v", err)
+	}
+	defer amq.Close()
 
 	r := server.NewRouter()
 
@@ -46,39 +58,53 @@ func main() {
 	userController := user.NewHandler(userService)
 
 	roomRepo := chatroom.NewRepository(database)
-	roomService := chatroom.NewService(roomRepo)
+	memberRepo := chatroom.NewMemberRepository(database)
+	inviteRepo := chatroom.NewInviteRepository(database)
+	banRepo := chatroom.NewBanRepository(database)
+	msgRepo := message.NewRepository(database)
+	roomService := chatroom.NewService(roomRepo, memberRepo, inviteRepo, banRepo, msgRepo, &events.RoomNotifier{AMQP: amq})
 	roomHandler := chatroom.NewHandler(roomService)
 
-	msgRepo := message.NewRepository(database)
-	msgService := message.NewService(msgRepo)
+	dmService := dm.NewService(roomRepo, memberRepo, userRepo, msgRepo)
+	dmHandler := dm.NewHandler(dmService)
+
+	msgService := message.NewService(msgRepo, roomService, &events.MessageNotifier{AMQP: amq}, &events.MentionResolver{Users: userRepo, Rooms: roomService})
 	msgHandler := message.NewHandler(msgService)
 
+	presenceService := presence.NewService(presence.NewRepository(database), roomService, &events.PresenceNotifier{AMQP: amq})
+	presenceHandler := presence.NewHandler(presenceService)
+	go presenceService.Run(appCtx)
+
 	// Register routes
 	userController.RegisterRoutes(r)
 	roomHandler.RegisterRoutes(r, cfg.JWTSecret)
+	dmHandler.RegisterRoutes(r, cfg.JWTSecret)
 	msgHandler.RegisterRoutes(r, cfg.JWTSecret)
+	presenceHandler.RegisterRoutes(r, cfg.JWTSecret)
+	admin.NewHandler(amq, cfg.AdminUserIDs).RegisterRoutes(r, cfg.JWTSecret)
 
 	// WebSocket hub
-	hub := ws.BuildHub()
-
-	amq, err := events.NewAMQP(startupCtx, cfg.RabbitMQURI, "chat.events")
-	if err != nil {
-		log.Fatalf("failed to connect rabbitmq: %v", err)
-	}
-	defer amq.Close()
+	hub := ws.BuildHub().WithLimits(ws.Limits{
+		PingInterval:    cfg.WSPingInterval,
+		PongWait:        cfg.WSPongWait,
+		WriteWait:       cfg.WSWriteWait,
+		MaxMessageBytes: cfg.WSMaxMessageBytes,
+	})
 
 	// Wire publisher and register ws routes
 	pub := &ws.Publisher{AMQP: amq}
-	hub.WithPublisher(pub)
+	hub.WithPublisher(pub).WithMembership(roomService).WithReadMarker(msgService).WithPresence(presenceService).WithHistory(msgService)
 	hub.RegisterRoutes(r, cfg)
 
-	// Start consumers
-	ingress := &events.IngressConsumer{AMQP: amq, Service: msgService, Users: userRepo}
-	_ = ingress.Start(appCtx)
-	broadcast := &events.BroadcastConsumer{AMQP: amq, Hub: hub}
-	_ = broadcast.Start(appCtx)
-	botResp := &events.BotResponseConsumer{AMQP: amq, Service: msgService}
-	_ = botResp.Start(appCtx)
+	// Start consumers; they are restarted whenever the broker connection is re-established
+	amq.Register(
+		&events.IngressConsumer{AMQP: amq, Service: msgService, Users: userRepo, Rooms: roomService, Bots: roomService},
+		&events.BroadcastConsumer{AMQP: amq, Hub: hub},
+		&events.TypingConsumer{AMQP: amq, Hub: hub},
+		&events.PresenceConsumer{AMQP: amq, Hub: hub},
+		&events.BotResponseConsumer{AMQP: amq, Service: msgService},
+	)
+	go amq.Supervise(appCtx)
 
 	server := &http.Server{
 		Addr:    ":" + cfg.Port,
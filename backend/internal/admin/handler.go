@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"chatapp/internal/auth"
+	"chatapp/internal/constants"
+	"chatapp/internal/events"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxLimit caps how many dead letters one request inspects or requeues.
+const maxLimit = 500
+
+// DeadLetterQueues gives access to the dead-letter queues of the message broker.
+type DeadLetterQueues interface {
+	DeadLetters(ctx context.Context, name string, limit int) ([]events.DeadLetter, int, error)
+	RequeueDeadLetters(ctx context.Context, name string, limit int) (int, error)
+}
+
+// Handler serves operations endpoints to the users listed in ADMIN_USER_IDS.
+type Handler struct {
+	queues DeadLetterQueues
+	admins map[string]bool
+}
+
+func NewHandler(q DeadLetterQueues, adminIDs []string) *Handler {
+	admins := make(map[string]bool, len(adminIDs))
+	for _, id := range adminIDs {
+		admins[id] = true
+	}
+	return &Handler{queues: q, admins: admins}
+}
+
+func (h *Handler) RegisterRoutes(r *gin.Engine, jwtSecret string) {
+	group := r.Group(constants.APIv1 + "/admin")
+	group.Use(auth.AuthMiddleware(jwtSecret), h.requireAdmin)
+	group.GET("dead-letters/:queue", h.deadLetters)
+	group.POST("dead-letters/:queue/requeue", h.requeue)
+}
+
+func (h *Handler) requireAdmin(c *gin.Context) {
+	if !h.admins[c.GetString("uid")] {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+		return
+	}
+	c.Next()
+}
+
+// limit reads the limit query parameter, defaulting to def and capped at maxLimit.
+func limit(c *gin.Context, def int) int {
+	n, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || n <= 0 {
+		return def
+	}
+	if n > maxLimit {
+		return maxLimit
+	}
+	return n
+}
+
+// deadLetters lists the oldest dead letters of a queue without removing them.
+func (h *Handler) deadLetters(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	items, total, err := h.queues.DeadLetters(ctx, c.Param("queue"), limit(c, 50))
+	if err != nil {
+		h.fail(c, err, "failed to read dead letters")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items, "total": total})
+}
+
+// requeue moves the oldest dead letters of a queue back into it.
+func (h *Handler) requeue(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	moved, err := h.queues.RequeueDeadLetters(ctx, c.Param("queue"), limit(c, maxLimit))
+	if err != nil && moved == 0 {
+		h.fail(c, err, "failed to requeue dead letters")
+		return
+	}
+	if err != nil {
+		// Some were moved before the failure; report them so the caller can retry the rest.
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to requeue all dead letters", "requeued": moved})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"requeued": moved})
+}
+
+func (h *Handler) fail(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, events.ErrUnknownQueue):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, events.ErrNotConnected):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": msg})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
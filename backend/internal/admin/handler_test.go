@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"chatapp/internal/events"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type stubQueues struct {
+	dead       map[string][]events.DeadLetter
+	requeueErr error
+}
+
+func (q *stubQueues) DeadLetters(ctx context.Context, name string, limit int) ([]events.DeadLetter, int, error) {
+	items, ok := q.dead[name]
+	if !ok {
+		return nil, 0, events.ErrUnknownQueue
+	}
+	return items[:min(limit, len(items))], len(items), nil
+}
+
+func (q *stubQueues) RequeueDeadLetters(ctx context.Context, name string, limit int) (int, error) {
+	items, ok := q.dead[name]
+	if !ok {
+		return 0, events.ErrUnknownQueue
+	}
+	n := min(limit, len(items))
+	q.dead[name] = items[n:]
+	return n, q.requeueErr
+}
+
+func serve(h *Handler, uid string, method string, path string) int {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("uid", uid) })
+	group := r.Group("/admin", h.requireAdmin)
+	group.GET("dead-letters/:queue", h.deadLetters)
+	group.POST("dead-letters/:queue/requeue", h.requeue)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w.Code
+}
+
+func TestHandler_DeadLetters(t *testing.T) {
+	q := &stubQueues{dead: map[string][]events.DeadLetter{"chat.ingress": {{Body: "{"}, {Body: "x"}}}}
+	h := NewHandler(q, []string{"root"})
+
+	if code := serve(h, "alice", http.MethodGet, "/admin/dead-letters/chat.ingress"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a non-admin, got %d", code)
+	}
+	if code := serve(h, "root", http.MethodGet, "/admin/dead-letters/chat.ingress"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := serve(h, "root", http.MethodGet, "/admin/dead-letters/nope"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown queue, got %d", code)
+	}
+	if code := serve(h, "root", http.MethodPost, "/admin/dead-letters/chat.ingress/requeue?limit=1"); code != http.StatusOK || len(q.dead["chat.ingress"]) != 1 {
+		t.Fatalf("expected one dead letter requeued, got %d with %d left", code, len(q.dead["chat.ingress"]))
+	}
+	q.requeueErr = errors.New("broker gone")
+	if code := serve(h, "root", http.MethodPost, "/admin/dead-letters/chat.ingress/requeue"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 after a partial requeue, got %d", code)
+	}
+}
@@ -0,0 +1,72 @@
+package chatroom
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type BanRepository interface {
+	// Add upserts the ban so banning twice keeps the first record.
+	Add(ctx context.Context, b *Ban) error
+	Remove(ctx context.Context, roomID string, userID string) (bool, error)
+	Exists(ctx context.Context, roomID string, userID string) (bool, error)
+	ListByRoom(ctx context.Context, roomID string) ([]Ban, error)
+	DeleteByRoom(ctx context.Context, roomID string) error
+}
+
+type mongoBanRepository struct {
+	col *mongo.Collection
+}
+
+func NewBanRepository(db *mongo.Database) BanRepository {
+	return &mongoBanRepository{col: db.Collection("chatroom_bans")}
+}
+
+func (r *mongoBanRepository) Add(ctx context.Context, b *Ban) error {
+	filter := bson.M{"roomId": b.RoomID, "userId": b.UserID}
+	update := bson.M{"$setOnInsert": bson.M{"roomId": b.RoomID, "userId": b.UserID, "bannedBy": b.BannedBy, "createdAt": b.CreatedAt}}
+	_, err := r.col.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (r *mongoBanRepository) Remove(ctx context.Context, roomID string, userID string) (bool, error) {
+	res, err := r.col.DeleteOne(ctx, bson.M{"roomId": roomID, "userId": userID})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount > 0, nil
+}
+
+func (r *mongoBanRepository) Exists(ctx context.Context, roomID string, userID string) (bool, error) {
+	n, err := r.col.CountDocuments(ctx, bson.M{"roomId": roomID, "userId": userID}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *mongoBanRepository) ListByRoom(ctx context.Context, roomID string) ([]Ban, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := r.col.Find(ctx, bson.M{"roomId": roomID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var result []Ban
+	for cursor.Next(ctx) {
+		var b Ban
+		if err := cursor.Decode(&b); err != nil {
+			return nil, err
+		}
+		result = append(result, b)
+	}
+	return result, cursor.Err()
+}
+
+func (r *mongoBanRepository) DeleteByRoom(ctx context.Context, roomID string) error {
+	_, err := r.col.DeleteMany(ctx, bson.M{"roomId": roomID})
+	return err
+}
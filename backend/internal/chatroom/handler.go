@@ -24,7 +24,22 @@ func (h *Handler) RegisterRoutes(r *gin.Engine, jwtSecret string) {
 	group.POST("", h.create)
 	group.GET("all", h.listAll)
 	group.PUT(":id", h.rename)
+	group.PUT(":id/bots", h.setBots)
 	group.DELETE(":id", h.delete)
+	group.GET(":id/members", h.listMembers)
+	group.POST(":id/members", h.join)
+	group.DELETE(":id/members/me", h.leave)
+	group.DELETE(":id/members/:userId", h.kick)
+	group.PUT(":id/members/:userId/role", h.setRole)
+	group.DELETE(":id/members/:userId/role", h.revokeRole)
+	group.GET(":id/bans", h.listBans)
+	group.PUT(":id/bans/:userId", h.ban)
+	group.DELETE(":id/bans/:userId", h.unban)
+	group.PUT(":id/owner", h.transferOwnership)
+	group.GET(":id/invites", h.listInvites)
+	group.POST(":id/invites", h.createInvite)
+	group.DELETE(":id/invites/:code", h.revokeInvite)
+	group.POST("invites/:code", h.redeemInvite)
 }
 
 func (h *Handler) create(c *gin.Context) {
@@ -49,7 +64,7 @@ func (h *Handler) listAll(c *gin.Context) {
 	skip, _ := strconv.ParseInt(c.DefaultQuery("skip", "0"), 10, 64)
 	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
 	defer cancel()
-	items, err := h.service.ListAll(ctx, limit, skip)
+	items, err := h.service.ListAll(ctx, c.GetString("uid"), limit, skip)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list"})
 		return
@@ -68,11 +83,24 @@ func (h *Handler) rename(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
 	defer cancel()
 	if err := h.service.Rename(ctx, uid, id, req); err != nil {
-		status := http.StatusBadRequest
-		if err == ErrNotFound {
-			status = http.StatusNotFound
-		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) setBots(c *gin.Context) {
+	uid := c.GetString("uid")
+	id := c.Param("id")
+	var req SetBotsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	if err := h.service.SetBotsEnabled(ctx, uid, id, *req.Enabled); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.Status(http.StatusNoContent)
@@ -84,12 +112,215 @@ func (h *Handler) delete(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
 	defer cancel()
 	if err := h.service.Delete(ctx, uid, id); err != nil {
-		status := http.StatusInternalServerError
-		if err == ErrNotFound {
-			status = http.StatusNotFound
-		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.Status(http.StatusNoContent)
 }
+
+func (h *Handler) listMembers(c *gin.Context) {
+	uid := c.GetString("uid")
+	id := c.Param("id")
+	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+	skip, _ := strconv.ParseInt(c.DefaultQuery("skip", "0"), 10, 64)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	items, err := h.service.ListMembers(ctx, uid, id, limit, skip)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *Handler) join(c *gin.Context) {
+	uid := c.GetString("uid")
+	id := c.Param("id")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	if err := h.service.Join(ctx, uid, id); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) leave(c *gin.Context) {
+	uid := c.GetString("uid")
+	id := c.Param("id")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	if err := h.service.Leave(ctx, uid, id); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) kick(c *gin.Context) {
+	uid := c.GetString("uid")
+	id := c.Param("id")
+	target := c.Param("userId")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	if err := h.service.Kick(ctx, uid, id, target); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) ban(c *gin.Context) {
+	uid := c.GetString("uid")
+	id := c.Param("id")
+	target := c.Param("userId")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	if err := h.service.Ban(ctx, uid, id, target); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) unban(c *gin.Context) {
+	uid := c.GetString("uid")
+	id := c.Param("id")
+	target := c.Param("userId")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	if err := h.service.Unban(ctx, uid, id, target); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) listBans(c *gin.Context) {
+	uid := c.GetString("uid")
+	id := c.Param("id")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	items, err := h.service.ListBans(ctx, uid, id)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *Handler) setRole(c *gin.Context) {
+	var req SetRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+	h.applyRole(c, req.Role)
+}
+
+func (h *Handler) revokeRole(c *gin.Context) {
+	h.applyRole(c, RoleMember)
+}
+
+func (h *Handler) applyRole(c *gin.Context, role string) {
+	uid := c.GetString("uid")
+	id := c.Param("id")
+	target := c.Param("userId")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	if err := h.service.SetRole(ctx, uid, id, target, role); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) transferOwnership(c *gin.Context) {
+	uid := c.GetString("uid")
+	id := c.Param("id")
+	var req TransferOwnershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	if err := h.service.TransferOwnership(ctx, uid, id, req.UserID); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) listInvites(c *gin.Context) {
+	uid := c.GetString("uid")
+	id := c.Param("id")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	items, err := h.service.ListInvites(ctx, uid, id)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *Handler) createInvite(c *gin.Context) {
+	uid := c.GetString("uid")
+	id := c.Param("id")
+	var req CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	resp, err := h.service.CreateInvite(ctx, uid, id, req)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+func (h *Handler) revokeInvite(c *gin.Context) {
+	uid := c.GetString("uid")
+	id := c.Param("id")
+	code := c.Param("code")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	if err := h.service.RevokeInvite(ctx, uid, id, code); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) redeemInvite(c *gin.Context) {
+	uid := c.GetString("uid")
+	code := c.Param("code")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	resp, err := h.service.RedeemInvite(ctx, uid, code)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// errorStatus maps service errors to HTTP status codes.
+func errorStatus(err error) int {
+	switch err {
+	case ErrNotFound, ErrNotMember, ErrInviteInvalid:
+		return http.StatusNotFound
+	case ErrForbidden, ErrInviteRequired, ErrDirectMessage, ErrBanned:
+		return http.StatusForbidden
+	case ErrInvalidInput:
+		return http.StatusBadRequest
+	case ErrOwnerCannotLeave:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,95 @@
+package chatroom
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type InviteRepository interface {
+	Create(ctx context.Context, inv *Invite) error
+	ListByRoom(ctx context.Context, roomID string) ([]Invite, error)
+	Revoke(ctx context.Context, roomID string, code string) (bool, error)
+	// FindValid returns an invite that can still be redeemed, without using it up.
+	FindValid(ctx context.Context, code string, now time.Time) (*Invite, error)
+	// Consume atomically increments the use count of a valid invite and returns it.
+	Consume(ctx context.Context, code string, now time.Time) (*Invite, error)
+	DeleteByRoom(ctx context.Context, roomID string) error
+}
+
+type mongoInviteRepository struct {
+	col *mongo.Collection
+}
+
+func NewInviteRepository(db *mongo.Database) InviteRepository {
+	return &mongoInviteRepository{col: db.Collection("chatroom_invites")}
+}
+
+func (r *mongoInviteRepository) Create(ctx context.Context, inv *Invite) error {
+	_, err := r.col.InsertOne(ctx, inv)
+	return err
+}
+
+func (r *mongoInviteRepository) ListByRoom(ctx context.Context, roomID string) ([]Invite, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := r.col.Find(ctx, bson.M{"roomId": roomID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var result []Invite
+	for cursor.Next(ctx) {
+		var inv Invite
+		if err := cursor.Decode(&inv); err != nil {
+			return nil, err
+		}
+		result = append(result, inv)
+	}
+	return result, cursor.Err()
+}
+
+func (r *mongoInviteRepository) Revoke(ctx context.Context, roomID string, code string) (bool, error) {
+	res, err := r.col.UpdateOne(ctx, bson.M{"roomId": roomID, "code": code}, bson.M{"$set": bson.M{"revoked": true}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// validInvite matches the invite with code if it is neither revoked, expired nor used up.
+func validInvite(code string, now time.Time) bson.M {
+	return bson.M{
+		"code":      code,
+		"revoked":   false,
+		"expiresAt": bson.M{"$gt": now},
+		"$or": bson.A{
+			bson.M{"maxUses": 0},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$maxUses"}}},
+		},
+	}
+}
+
+func (r *mongoInviteRepository) FindValid(ctx context.Context, code string, now time.Time) (*Invite, error) {
+	var inv Invite
+	if err := r.col.FindOne(ctx, validInvite(code, now)).Decode(&inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (r *mongoInviteRepository) Consume(ctx context.Context, code string, now time.Time) (*Invite, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var inv Invite
+	if err := r.col.FindOneAndUpdate(ctx, validInvite(code, now), bson.M{"$inc": bson.M{"uses": 1}}, opts).Decode(&inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (r *mongoInviteRepository) DeleteByRoom(ctx context.Context, roomID string) error {
+	_, err := r.col.DeleteMany(ctx, bson.M{"roomId": roomID})
+	return err
+}
@@ -0,0 +1,146 @@
+package chatroom
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MemberRepository interface {
+	Add(ctx context.Context, m *Member) error
+	Remove(ctx context.Context, roomID string, userID string) (bool, error)
+	Exists(ctx context.Context, roomID string, userID string) (bool, error)
+	Find(ctx context.Context, roomID string, userID string) (*Member, error)
+	SetRole(ctx context.Context, roomID string, userID string, role string) (bool, error)
+	ListByRoom(ctx context.Context, roomID string, limit int64, skip int64) ([]Member, error)
+	ListRoomIDsByUser(ctx context.Context, userID string) ([]string, error)
+	ListByUser(ctx context.Context, userID string) ([]Member, error)
+	SetLastRead(ctx context.Context, roomID string, userID string, msgID string) (bool, error)
+	DeleteByRoom(ctx context.Context, roomID string) error
+}
+
+type mongoMemberRepository struct {
+	col *mongo.Collection
+}
+
+func NewMemberRepository(db *mongo.Database) MemberRepository {
+	return &mongoMemberRepository{col: db.Collection("chatroom_members")}
+}
+
+// Add upserts the membership so joining twice is a no-op.
+func (r *mongoMemberRepository) Add(ctx context.Context, m *Member) error {
+	filter := bson.M{"roomId": m.RoomID, "userId": m.UserID}
+	update := bson.M{"$setOnInsert": bson.M{"roomId": m.RoomID, "userId": m.UserID, "role": m.Role, "joinedAt": m.JoinedAt}}
+	_, err := r.col.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (r *mongoMemberRepository) Remove(ctx context.Context, roomID string, userID string) (bool, error) {
+	res, err := r.col.DeleteOne(ctx, bson.M{"roomId": roomID, "userId": userID})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount > 0, nil
+}
+
+func (r *mongoMemberRepository) Exists(ctx context.Context, roomID string, userID string) (bool, error) {
+	n, err := r.col.CountDocuments(ctx, bson.M{"roomId": roomID, "userId": userID}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *mongoMemberRepository) Find(ctx context.Context, roomID string, userID string) (*Member, error) {
+	var m Member
+	if err := r.col.FindOne(ctx, bson.M{"roomId": roomID, "userId": userID}).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *mongoMemberRepository) SetRole(ctx context.Context, roomID string, userID string, role string) (bool, error) {
+	res, err := r.col.UpdateOne(ctx, bson.M{"roomId": roomID, "userId": userID}, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+func (r *mongoMemberRepository) ListByRoom(ctx context.Context, roomID string, limit int64, skip int64) ([]Member, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "joinedAt", Value: 1}}).SetLimit(limit).SetSkip(skip)
+	cursor, err := r.col.Find(ctx, bson.M{"roomId": roomID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var result []Member
+	for cursor.Next(ctx) {
+		var m Member
+		if err := cursor.Decode(&m); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, cursor.Err()
+}
+
+func (r *mongoMemberRepository) ListRoomIDsByUser(ctx context.Context, userID string) ([]string, error) {
+	opts := options.Find().SetProjection(bson.M{"roomId": 1})
+	cursor, err := r.col.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var result []string
+	for cursor.Next(ctx) {
+		var m Member
+		if err := cursor.Decode(&m); err != nil {
+			return nil, err
+		}
+		result = append(result, m.RoomID)
+	}
+	return result, cursor.Err()
+}
+
+func (r *mongoMemberRepository) ListByUser(ctx context.Context, userID string) ([]Member, error) {
+	cursor, err := r.col.Find(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var result []Member
+	for cursor.Next(ctx) {
+		var m Member
+		if err := cursor.Decode(&m); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, cursor.Err()
+}
+
+// SetLastRead moves the member's read position forward to msgID. Message IDs are hex ObjectIDs,
+// so comparing them as strings follows insertion order; an older msgID leaves the position as is.
+func (r *mongoMemberRepository) SetLastRead(ctx context.Context, roomID string, userID string, msgID string) (bool, error) {
+	filter := bson.M{
+		"roomId": roomID,
+		"userId": userID,
+		"$or": bson.A{
+			bson.M{"lastReadId": bson.M{"$exists": false}},
+			bson.M{"lastReadId": bson.M{"$lt": msgID}},
+		},
+	}
+	res, err := r.col.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"lastReadId": msgID}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+func (r *mongoMemberRepository) DeleteByRoom(ctx context.Context, roomID string) error {
+	_, err := r.col.DeleteMany(ctx, bson.M{"roomId": roomID})
+	return err
+}
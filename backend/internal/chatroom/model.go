@@ -6,28 +6,162 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Visibility values control who can discover and join a chatroom.
+const (
+	// VisibilityPublic rooms are listed for everyone and anyone may join.
+	VisibilityPublic = "public"
+	// VisibilityInviteOnly rooms are listed for everyone but joining requires an invite.
+	VisibilityInviteOnly = "invite_only"
+	// VisibilityPrivate rooms are only listed for members and joining requires an invite.
+	VisibilityPrivate = "private"
+)
+
+// KindDM marks a room as a 1:1 direct message conversation.
+const KindDM = "dm"
+
 // ChatRoom represents a conversation room.
 type ChatRoom struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty"`
-	Title     string             `bson:"title"`
-	OwnerID   string             `bson:"ownerId"`
-	CreatedAt time.Time          `bson:"createdAt"`
-	UpdatedAt time.Time          `bson:"updatedAt"`
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	Title      string             `bson:"title"`
+	OwnerID    string             `bson:"ownerId"`
+	Visibility string             `bson:"visibility,omitempty"`
+	// Kind is empty for regular rooms and KindDM for direct messages.
+	Kind string `bson:"kind,omitempty"`
+	// Participants and DMKey are only set on direct messages; DMKey is unique per user pair.
+	Participants []string `bson:"participants,omitempty"`
+	DMKey        string   `bson:"dmKey,omitempty"`
+	// BotsDisabled stops bot commands posted in the room from being run.
+	BotsDisabled bool      `bson:"botsDisabled,omitempty"`
+	CreatedAt    time.Time `bson:"createdAt"`
+	UpdatedAt    time.Time `bson:"updatedAt"`
+}
+
+// IsDM reports whether the room is a direct message conversation.
+func (c *ChatRoom) IsDM() bool {
+	return c.Kind == KindDM
+}
+
+// EffectiveVisibility treats rooms created before visibility existed as public.
+func (c *ChatRoom) EffectiveVisibility() string {
+	if c.Visibility == "" {
+		return VisibilityPublic
+	}
+	return c.Visibility
+}
+
+func (c *ChatRoom) toResponse() ChatRoomResponse {
+	return ChatRoomResponse{ID: c.ID.Hex(), Title: c.Title, OwnerID: c.OwnerID, Visibility: c.EffectiveVisibility(), BotsEnabled: !c.BotsDisabled}
 }
 
 // CreateChatRoomRequest is the payload to create a chatroom.
 type CreateChatRoomRequest struct {
-	Title string `json:"title" binding:"required,min=1,max=120"`
+	Title      string `json:"title" binding:"required,min=1,max=120"`
+	Visibility string `json:"visibility" binding:"omitempty,oneof=public private invite_only"`
 }
 
 // ChatRoomResponse is a public representation of a chatroom.
 type ChatRoomResponse struct {
-	ID      string `json:"id"`
-	Title   string `json:"title"`
-	OwnerID string `json:"ownerId"`
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	OwnerID     string `json:"ownerId"`
+	Visibility  string `json:"visibility"`
+	BotsEnabled bool   `json:"botsEnabled"`
+	// LastReadID and UnreadCount are only filled in for rooms the caller belongs to.
+	LastReadID  string `json:"lastReadId,omitempty"`
+	UnreadCount int64  `json:"unreadCount"`
 }
 
 // UpdateChatRoomRequest allows renaming the chatroom.
 type UpdateChatRoomRequest struct {
 	Title string `json:"title" binding:"required,min=1,max=120"`
 }
+
+// SetBotsRequest turns bot commands on or off in a chatroom.
+type SetBotsRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// Member represents a user's membership in a chatroom.
+type Member struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	RoomID   string             `bson:"roomId"`
+	UserID   string             `bson:"userId"`
+	Role     string             `bson:"role,omitempty"`
+	JoinedAt time.Time          `bson:"joinedAt"`
+	// LastReadID is the newest message the member has read in the room.
+	LastReadID string `bson:"lastReadId,omitempty"`
+}
+
+// MemberResponse is a public representation of a chatroom member.
+type MemberResponse struct {
+	UserID   string    `json:"userId"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+// SetRoleRequest grants a role to a member. Ownership is moved with TransferOwnershipRequest instead.
+type SetRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=admin moderator member"`
+}
+
+// TransferOwnershipRequest hands the room over to another member.
+type TransferOwnershipRequest struct {
+	UserID string `json:"userId" binding:"required"`
+}
+
+// Ban keeps a user out of a chatroom until it is lifted.
+type Ban struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	RoomID    string             `bson:"roomId"`
+	UserID    string             `bson:"userId"`
+	BannedBy  string             `bson:"bannedBy"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
+
+// BanResponse is a public representation of a ban.
+type BanResponse struct {
+	UserID    string    `json:"userId"`
+	BannedBy  string    `json:"bannedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Invite is a redeemable code granting membership to a chatroom.
+type Invite struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Code      string             `bson:"code"`
+	RoomID    string             `bson:"roomId"`
+	CreatedBy string             `bson:"createdBy"`
+	MaxUses   int                `bson:"maxUses"`
+	Uses      int                `bson:"uses"`
+	Revoked   bool               `bson:"revoked"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
+
+// CreateInviteRequest is the payload to mint an invite code.
+// ExpiresIn is a Go duration string (e.g. "24h"); MaxUses of 0 means unlimited.
+type CreateInviteRequest struct {
+	ExpiresIn string `json:"expiresIn"`
+	MaxUses   int    `json:"maxUses" binding:"omitempty,min=0,max=1000"`
+}
+
+// InviteResponse is a public representation of an invite.
+type InviteResponse struct {
+	Code      string    `json:"code"`
+	RoomID    string    `json:"roomId"`
+	MaxUses   int       `json:"maxUses"`
+	Uses      int       `json:"uses"`
+	Revoked   bool      `json:"revoked"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (inv *Invite) toResponse() InviteResponse {
+	return InviteResponse{
+		Code:      inv.Code,
+		RoomID:    inv.RoomID,
+		MaxUses:   inv.MaxUses,
+		Uses:      inv.Uses,
+		Revoked:   inv.Revoked,
+		ExpiresAt: inv.ExpiresAt,
+	}
+}
@@ -2,6 +2,7 @@ package chatroom
 
 import (
 	"context"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,8 +13,13 @@ import (
 type Repository interface {
 	Create(ctx context.Context, c *ChatRoom) error
 	FindByID(ctx context.Context, id primitive.ObjectID) (*ChatRoom, error)
-	FindAll(ctx context.Context, limit int64, skip int64) ([]ChatRoom, error)
+	FindVisible(ctx context.Context, memberRoomIDs []primitive.ObjectID, limit int64, skip int64) ([]ChatRoom, error)
+	FindByDMKey(ctx context.Context, key string) (*ChatRoom, error)
+	FindDMs(ctx context.Context, roomIDs []primitive.ObjectID, limit int64, skip int64) ([]ChatRoom, error)
 	UpdateTitle(ctx context.Context, id primitive.ObjectID, title string) error
+	UpdateOwner(ctx context.Context, id primitive.ObjectID, ownerID string) error
+	SetBotsDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error
+	Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error
 	Delete(ctx context.Context, id primitive.ObjectID, ownerID string) (bool, error)
 }
 
@@ -44,9 +50,37 @@ func (r *mongoRepository) FindByID(ctx context.Context, id primitive.ObjectID) (
 	return &c, nil
 }
 
-func (r *mongoRepository) FindAll(ctx context.Context, limit int64, skip int64) ([]ChatRoom, error) {
-	opts := options.Find().SetLimit(limit).SetSkip(skip)
-	cursor, err := r.col.Find(ctx, bson.M{}, opts)
+// FindVisible returns discoverable rooms plus the given rooms the caller is a member of.
+// Direct messages are never included.
+func (r *mongoRepository) FindVisible(ctx context.Context, memberRoomIDs []primitive.ObjectID, limit int64, skip int64) ([]ChatRoom, error) {
+	filter := bson.M{
+		"kind": bson.M{"$ne": KindDM},
+		"$or": bson.A{
+			bson.M{"visibility": bson.M{"$in": bson.A{VisibilityPublic, VisibilityInviteOnly}}},
+			bson.M{"visibility": bson.M{"$exists": false}},
+			bson.M{"_id": bson.M{"$in": memberRoomIDs}},
+		},
+	}
+	return r.find(ctx, filter, options.Find().SetLimit(limit).SetSkip(skip))
+}
+
+func (r *mongoRepository) FindByDMKey(ctx context.Context, key string) (*ChatRoom, error) {
+	var c ChatRoom
+	if err := r.col.FindOne(ctx, bson.M{"kind": KindDM, "dmKey": key}).Decode(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// FindDMs returns the direct messages among the given rooms, most recently updated first.
+func (r *mongoRepository) FindDMs(ctx context.Context, roomIDs []primitive.ObjectID, limit int64, skip int64) ([]ChatRoom, error) {
+	filter := bson.M{"kind": KindDM, "_id": bson.M{"$in": roomIDs}}
+	opts := options.Find().SetSort(bson.D{{Key: "updatedAt", Value: -1}}).SetLimit(limit).SetSkip(skip)
+	return r.find(ctx, filter, opts)
+}
+
+func (r *mongoRepository) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]ChatRoom, error) {
+	cursor, err := r.col.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -67,6 +101,27 @@ func (r *mongoRepository) UpdateTitle(ctx context.Context, id primitive.ObjectID
 	return err
 }
 
+func (r *mongoRepository) UpdateOwner(ctx context.Context, id primitive.ObjectID, ownerID string) error {
+	_, err := r.col.UpdateByID(ctx, id, bson.M{"$set": bson.M{"ownerId": ownerID, "updatedAt": time.Now().UTC()}})
+	return err
+}
+
+// SetBotsDisabled records whether bot commands are turned off; rooms with bots enabled have no field.
+func (r *mongoRepository) SetBotsDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error {
+	update := bson.M{"$unset": bson.M{"botsDisabled": ""}}
+	if disabled {
+		update = bson.M{"$set": bson.M{"botsDisabled": true}}
+	}
+	_, err := r.col.UpdateByID(ctx, id, update)
+	return err
+}
+
+// Touch moves the room's updatedAt forward to at, leaving it alone if it is already later.
+func (r *mongoRepository) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := r.col.UpdateByID(ctx, id, bson.M{"$max": bson.M{"updatedAt": at}})
+	return err
+}
+
 func (r *mongoRepository) Delete(ctx context.Context, id primitive.ObjectID, ownerID string) (bool, error) {
 	res, err := r.col.DeleteOne(ctx, bson.M{"_id": id, "ownerId": ownerID})
 	if err != nil {
@@ -0,0 +1,70 @@
+package chatroom
+
+// Roles a member can hold within a chatroom, from most to least privileged.
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// Permission names an action that is gated by the caller's room role.
+type Permission string
+
+const (
+	PermRename         Permission = "rename"
+	PermDelete         Permission = "delete"
+	PermKick           Permission = "kick"
+	PermBan            Permission = "ban"
+	PermPin            Permission = "pin"
+	PermDeleteMessages Permission = "delete_messages"
+	PermManageBots     Permission = "manage_bots"
+	PermManageRoles    Permission = "manage_roles"
+	PermManageInvites  Permission = "manage_invites"
+)
+
+// permissions is the role -> permission matrix. Owners implicitly hold every permission.
+var permissions = map[string]map[Permission]bool{
+	RoleAdmin: {
+		PermRename:         true,
+		PermKick:           true,
+		PermBan:            true,
+		PermPin:            true,
+		PermDeleteMessages: true,
+		PermManageBots:     true,
+		PermManageRoles:    true,
+		PermManageInvites:  true,
+	},
+	RoleModerator: {
+		PermKick:           true,
+		PermBan:            true,
+		PermPin:            true,
+		PermDeleteMessages: true,
+	},
+	RoleMember: {},
+}
+
+var roleRank = map[string]int{
+	RoleMember:    1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+	RoleOwner:     4,
+}
+
+// Can reports whether the given role grants the permission.
+func Can(role string, perm Permission) bool {
+	if role == RoleOwner {
+		return true
+	}
+	return permissions[role][perm]
+}
+
+// Outranks reports whether role a is strictly more privileged than role b.
+func Outranks(a, b string) bool {
+	return roleRank[a] > roleRank[b]
+}
+
+// validRole reports whether the role can be assigned through SetRole; ownership is transferred instead.
+func validRole(role string) bool {
+	return role == RoleAdmin || role == RoleModerator || role == RoleMember
+}
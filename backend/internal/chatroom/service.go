@@ -2,91 +2,353 @@ package chatroom
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
+	"log"
 	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultInviteTTL = 24 * time.Hour
+	maxInviteTTL     = 30 * 24 * time.Hour
 )
 
 var ErrNotFound = errors.New("chatroom not found")
 var ErrForbidden = errors.New("forbidden")
+var ErrNotMember = errors.New("not a member of this chatroom")
+var ErrOwnerCannotLeave = errors.New("owner cannot leave the chatroom")
+var ErrInvalidInput = errors.New("invalid input")
+var ErrInviteRequired = errors.New("an invite is required to join this chatroom")
+var ErrInviteInvalid = errors.New("invite is invalid or expired")
+var ErrDirectMessage = errors.New("operation not supported on direct messages")
+var ErrBanned = errors.New("banned from this chatroom")
 
 type Service interface {
 	Create(ctx context.Context, ownerID string, req CreateChatRoomRequest) (*ChatRoomResponse, error)
-	ListAll(ctx context.Context, limit int64, skip int64) ([]ChatRoomResponse, error)
+	ListAll(ctx context.Context, userID string, limit int64, skip int64) ([]ChatRoomResponse, error)
 	Rename(ctx context.Context, userID string, id string, req UpdateChatRoomRequest) error
+	SetBotsEnabled(ctx context.Context, userID string, id string, enabled bool) error
+	BotsEnabled(ctx context.Context, roomID string) (bool, error)
 	Delete(ctx context.Context, userID string, id string) error
+	Join(ctx context.Context, userID string, id string) error
+	Leave(ctx context.Context, userID string, id string) error
+	Kick(ctx context.Context, userID string, id string, targetID string) error
+	Ban(ctx context.Context, userID string, id string, targetID string) error
+	Unban(ctx context.Context, userID string, id string, targetID string) error
+	ListBans(ctx context.Context, userID string, id string) ([]BanResponse, error)
+	ListMembers(ctx context.Context, userID string, id string, limit int64, skip int64) ([]MemberResponse, error)
+	IsMember(ctx context.Context, roomID string, userID string) (bool, error)
+	MemberRoomIDs(ctx context.Context, userID string) ([]string, error)
+	MarkRead(ctx context.Context, roomID string, userID string, msgID string) (bool, error)
+	Touch(ctx context.Context, roomID string, at time.Time) error
+	CreateInvite(ctx context.Context, userID string, id string, req CreateInviteRequest) (*InviteResponse, error)
+	ListInvites(ctx context.Context, userID string, id string) ([]InviteResponse, error)
+	RevokeInvite(ctx context.Context, userID string, id string, code string) error
+	RedeemInvite(ctx context.Context, userID string, code string) (*ChatRoomResponse, error)
+	SetRole(ctx context.Context, userID string, id string, targetID string, role string) error
+	TransferOwnership(ctx context.Context, userID string, id string, targetID string) error
+	Authorize(ctx context.Context, roomID string, userID string, perm Permission) error
+}
+
+// UnreadCounter counts the messages a user has not read yet in each of several rooms.
+// readPositions maps each room ID to the user's read position there, empty if they have read
+// nothing; rooms without unread messages may be missing from the result.
+type UnreadCounter interface {
+	CountUnread(ctx context.Context, userID string, readPositions map[string]string) (map[string]int64, error)
+}
+
+// Notifier publishes membership changes so live connections can follow them.
+type Notifier interface {
+	MemberRemoved(ctx context.Context, roomID string, userID string) error
 }
 
 type service struct {
-	repo Repository
+	repo     Repository
+	members  MemberRepository
+	invites  InviteRepository
+	bans     BanRepository
+	unread   UnreadCounter
+	notifier Notifier
 }
 
-func NewService(r Repository) Service {
-	return &service{repo: r}
+func NewService(r Repository, m MemberRepository, i InviteRepository, b BanRepository, u UnreadCounter, n Notifier) Service {
+	return &service{repo: r, members: m, invites: i, bans: b, unread: u, notifier: n}
 }
 
 func (s *service) Create(ctx context.Context, ownerID string, req CreateChatRoomRequest) (*ChatRoomResponse, error) {
 	title := strings.TrimSpace(req.Title)
 	if title == "" {
-		return nil, errors.New("invalid input")
+		return nil, ErrInvalidInput
+	}
+	visibility := req.Visibility
+	if visibility == "" {
+		visibility = VisibilityPublic
 	}
 	room := &ChatRoom{
-		Title:     title,
-		OwnerID:   ownerID,
-		CreatedAt: time.Now().UTC(),
-		UpdatedAt: time.Now().UTC(),
+		Title:      title,
+		OwnerID:    ownerID,
+		Visibility: visibility,
+		CreatedAt:  time.Now().UTC(),
+		UpdatedAt:  time.Now().UTC(),
 	}
 	if err := s.repo.Create(ctx, room); err != nil {
 		return nil, err
 	}
-	return &ChatRoomResponse{ID: room.ID.Hex(), Title: room.Title, OwnerID: room.OwnerID}, nil
+	if err := s.members.Add(ctx, &Member{RoomID: room.ID.Hex(), UserID: ownerID, Role: RoleOwner, JoinedAt: room.CreatedAt}); err != nil {
+		return nil, err
+	}
+	resp := room.toResponse()
+	return &resp, nil
 }
 
-func (s *service) ListAll(ctx context.Context, limit int64, skip int64) ([]ChatRoomResponse, error) {
+// ListAll returns public and invite-only rooms plus any private rooms the user belongs to.
+func (s *service) ListAll(ctx context.Context, userID string, limit int64, skip int64) ([]ChatRoomResponse, error) {
 	if limit <= 0 || limit > 100 {
 		limit = 20
 	}
 	if skip < 0 {
 		skip = 0
 	}
-	items, err := s.repo.FindAll(ctx, limit, skip)
+	memberships, err := s.members.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	lastRead := make(map[string]string, len(memberships))
+	oids := make([]primitive.ObjectID, 0, len(memberships))
+	for _, m := range memberships {
+		lastRead[m.RoomID] = m.LastReadID
+		if oid, err := primitive.ObjectIDFromHex(m.RoomID); err == nil {
+			oids = append(oids, oid)
+		}
+	}
+	items, err := s.repo.FindVisible(ctx, oids, limit, skip)
+	if err != nil {
+		return nil, err
+	}
+	positions := make(map[string]string)
+	for _, c := range items {
+		if readID, ok := lastRead[c.ID.Hex()]; ok {
+			positions[c.ID.Hex()] = readID
+		}
+	}
+	unread, err := s.countUnread(ctx, userID, positions)
 	if err != nil {
 		return nil, err
 	}
 	resp := make([]ChatRoomResponse, 0, len(items))
 	for _, c := range items {
-		resp = append(resp, ChatRoomResponse{ID: c.ID.Hex(), Title: c.Title, OwnerID: c.OwnerID})
+		r := c.toResponse()
+		if readID, ok := positions[r.ID]; ok {
+			r.LastReadID = readID
+			r.UnreadCount = unread[r.ID]
+		}
+		resp = append(resp, r)
 	}
 	return resp, nil
 }
 
-func (s *service) Rename(ctx context.Context, userID string, id string, req UpdateChatRoomRequest) error {
-	oid, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return ErrNotFound
+// countUnread counts the unread messages of the rooms in readPositions in a single query.
+func (s *service) countUnread(ctx context.Context, userID string, readPositions map[string]string) (map[string]int64, error) {
+	if s.unread == nil || len(readPositions) == 0 {
+		return map[string]int64{}, nil
 	}
-	c, err := s.repo.FindByID(ctx, oid)
+	return s.unread.CountUnread(ctx, userID, readPositions)
+}
+
+func (s *service) Rename(ctx context.Context, userID string, id string, req UpdateChatRoomRequest) error {
+	c, err := s.findRoom(ctx, id)
 	if err != nil {
-		return ErrNotFound
+		return err
 	}
-	if c.OwnerID != userID {
-		return ErrForbidden
+	if err := s.authorize(ctx, c, userID, PermRename); err != nil {
+		return err
 	}
 	title := strings.TrimSpace(req.Title)
 	if title == "" {
-		return errors.New("invalid input")
+		return ErrInvalidInput
 	}
-	return s.repo.UpdateTitle(ctx, oid, title)
+	return s.repo.UpdateTitle(ctx, c.ID, title)
+}
+
+// SetBotsEnabled turns bot commands on or off in the room. It requires the manage-bots permission.
+func (s *service) SetBotsEnabled(ctx context.Context, userID string, id string, enabled bool) error {
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.authorize(ctx, c, userID, PermManageBots); err != nil {
+		return err
+	}
+	return s.repo.SetBotsDisabled(ctx, c.ID, !enabled)
+}
+
+// BotsEnabled reports whether bot commands posted in the room should be run.
+func (s *service) BotsEnabled(ctx context.Context, roomID string) (bool, error) {
+	c, err := s.findRoom(ctx, roomID)
+	if err != nil {
+		return false, err
+	}
+	return !c.BotsDisabled, nil
 }
 
 func (s *service) Delete(ctx context.Context, userID string, id string) error {
-	oid, err := primitive.ObjectIDFromHex(id)
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.authorize(ctx, c, userID, PermDelete); err != nil {
+		return err
+	}
+	ok, err := s.repo.Delete(ctx, c.ID, c.OwnerID)
 	if err != nil {
+		return err
+	}
+	if !ok {
 		return ErrNotFound
 	}
-	ok, err := s.repo.Delete(ctx, oid, userID)
+	if err := s.invites.DeleteByRoom(ctx, id); err != nil {
+		return err
+	}
+	if err := s.bans.DeleteByRoom(ctx, id); err != nil {
+		return err
+	}
+	return s.members.DeleteByRoom(ctx, id)
+}
+
+func (s *service) Join(ctx context.Context, userID string, id string) error {
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.checkBan(ctx, id, userID); err != nil {
+		return err
+	}
+	if c.EffectiveVisibility() != VisibilityPublic {
+		ok, err := s.members.Exists(ctx, id, userID)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if c.IsDM() {
+			return ErrDirectMessage
+		}
+		return ErrInviteRequired
+	}
+	return s.members.Add(ctx, &Member{RoomID: id, UserID: userID, Role: RoleMember, JoinedAt: time.Now().UTC()})
+}
+
+func (s *service) Leave(ctx context.Context, userID string, id string) error {
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return err
+	}
+	if c.IsDM() {
+		return ErrDirectMessage
+	}
+	if c.OwnerID == userID {
+		return ErrOwnerCannotLeave
+	}
+	ok, err := s.members.Remove(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotMember
+	}
+	s.memberRemoved(ctx, id, userID)
+	return nil
+}
+
+func (s *service) Kick(ctx context.Context, userID string, id string, targetID string) error {
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return err
+	}
+	if c.IsDM() {
+		return ErrDirectMessage
+	}
+	actorRole, err := s.roleOf(ctx, c, userID)
+	if err != nil {
+		return err
+	}
+	if !Can(actorRole, PermKick) {
+		return ErrForbidden
+	}
+	targetRole, err := s.roleOf(ctx, c, targetID)
+	if err != nil {
+		return err
+	}
+	if targetRole == "" {
+		return ErrNotMember
+	}
+	if !Outranks(actorRole, targetRole) {
+		return ErrForbidden
+	}
+	ok, err := s.members.Remove(ctx, id, targetID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotMember
+	}
+	s.memberRemoved(ctx, id, targetID)
+	return nil
+}
+
+// Ban removes a user from the room and keeps them from joining again, even through an invite.
+// Users who are not members can be banned pre-emptively; members only by someone who outranks them.
+func (s *service) Ban(ctx context.Context, userID string, id string, targetID string) error {
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return err
+	}
+	if c.IsDM() {
+		return ErrDirectMessage
+	}
+	actorRole, err := s.roleOf(ctx, c, userID)
+	if err != nil {
+		return err
+	}
+	if !Can(actorRole, PermBan) {
+		return ErrForbidden
+	}
+	targetRole, err := s.roleOf(ctx, c, targetID)
+	if err != nil {
+		return err
+	}
+	if targetRole != "" && !Outranks(actorRole, targetRole) {
+		return ErrForbidden
+	}
+	if err := s.bans.Add(ctx, &Ban{RoomID: id, UserID: targetID, BannedBy: userID, CreatedAt: time.Now().UTC()}); err != nil {
+		return err
+	}
+	ok, err := s.members.Remove(ctx, id, targetID)
+	if err != nil {
+		return err
+	}
+	if ok {
+		s.memberRemoved(ctx, id, targetID)
+	}
+	return nil
+}
+
+// Unban lifts a ban; the user has to join or redeem an invite again.
+func (s *service) Unban(ctx context.Context, userID string, id string, targetID string) error {
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.authorize(ctx, c, userID, PermBan); err != nil {
+		return err
+	}
+	ok, err := s.bans.Remove(ctx, id, targetID)
 	if err != nil {
 		return err
 	}
@@ -95,3 +357,361 @@ func (s *service) Delete(ctx context.Context, userID string, id string) error {
 	}
 	return nil
 }
+
+func (s *service) ListBans(ctx context.Context, userID string, id string) ([]BanResponse, error) {
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, c, userID, PermBan); err != nil {
+		return nil, err
+	}
+	items, err := s.bans.ListByRoom(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]BanResponse, 0, len(items))
+	for _, b := range items {
+		resp = append(resp, BanResponse{UserID: b.UserID, BannedBy: b.BannedBy, CreatedAt: b.CreatedAt})
+	}
+	return resp, nil
+}
+
+// checkBan fails with ErrBanned if the user is banned from the room.
+func (s *service) checkBan(ctx context.Context, roomID string, userID string) error {
+	banned, err := s.bans.Exists(ctx, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return ErrBanned
+	}
+	return nil
+}
+
+// memberRemoved announces that userID left roomID, so their open subscriptions are dropped.
+// The membership change stands even if the announcement fails.
+func (s *service) memberRemoved(ctx context.Context, roomID string, userID string) {
+	if s.notifier == nil {
+		return
+	}
+	if err := s.notifier.MemberRemoved(ctx, roomID, userID); err != nil {
+		log.Printf("chatroom: failed to publish removal of %s from %s: %v", userID, roomID, err)
+	}
+}
+
+func (s *service) ListMembers(ctx context.Context, userID string, id string, limit int64, skip int64) ([]MemberResponse, error) {
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.members.Exists(ctx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrForbidden
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	if skip < 0 {
+		skip = 0
+	}
+	items, err := s.members.ListByRoom(ctx, id, limit, skip)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]MemberResponse, 0, len(items))
+	for _, m := range items {
+		resp = append(resp, MemberResponse{UserID: m.UserID, Role: memberRole(c, &m), JoinedAt: m.JoinedAt})
+	}
+	return resp, nil
+}
+
+// IsMember reports whether the user has joined the room; used by other packages for access checks.
+func (s *service) IsMember(ctx context.Context, roomID string, userID string) (bool, error) {
+	return s.members.Exists(ctx, roomID, userID)
+}
+
+// CreateInvite mints a new invite code for the room.
+func (s *service) CreateInvite(ctx context.Context, userID string, id string, req CreateInviteRequest) (*InviteResponse, error) {
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, c, userID, PermManageInvites); err != nil {
+		return nil, err
+	}
+	ttl := defaultInviteTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > maxInviteTTL {
+			return nil, ErrInvalidInput
+		}
+		ttl = d
+	}
+	if req.MaxUses < 0 {
+		return nil, ErrInvalidInput
+	}
+	code, err := newInviteCode()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	inv := &Invite{
+		Code:      code,
+		RoomID:    id,
+		CreatedBy: userID,
+		MaxUses:   req.MaxUses,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if err := s.invites.Create(ctx, inv); err != nil {
+		return nil, err
+	}
+	resp := inv.toResponse()
+	return &resp, nil
+}
+
+func (s *service) ListInvites(ctx context.Context, userID string, id string) ([]InviteResponse, error) {
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, c, userID, PermManageInvites); err != nil {
+		return nil, err
+	}
+	items, err := s.invites.ListByRoom(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]InviteResponse, 0, len(items))
+	for _, inv := range items {
+		resp = append(resp, inv.toResponse())
+	}
+	return resp, nil
+}
+
+func (s *service) RevokeInvite(ctx context.Context, userID string, id string, code string) error {
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.authorize(ctx, c, userID, PermManageInvites); err != nil {
+		return err
+	}
+	ok, err := s.invites.Revoke(ctx, id, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInviteInvalid
+	}
+	return nil
+}
+
+// RedeemInvite adds the user to the invite's room. A use of the invite is only spent when the user
+// is actually added, not when they are banned or already a member.
+func (s *service) RedeemInvite(ctx context.Context, userID string, code string) (*ChatRoomResponse, error) {
+	inv, err := s.invites.FindValid(ctx, code, time.Now().UTC())
+	if err != nil {
+		return nil, inviteError(err)
+	}
+	c, err := s.findRoom(ctx, inv.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkBan(ctx, inv.RoomID, userID); err != nil {
+		return nil, err
+	}
+	resp := c.toResponse()
+	ok, err := s.members.Exists(ctx, inv.RoomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return &resp, nil
+	}
+	// The invite may have been used up or revoked since it was looked up.
+	if _, err := s.invites.Consume(ctx, code, time.Now().UTC()); err != nil {
+		return nil, inviteError(err)
+	}
+	if err := s.members.Add(ctx, &Member{RoomID: inv.RoomID, UserID: userID, Role: RoleMember, JoinedAt: time.Now().UTC()}); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// inviteError reports a missing, revoked, expired or used up invite as ErrInviteInvalid.
+func inviteError(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrInviteInvalid
+	}
+	return err
+}
+
+// SetRole grants a role to a member. The caller must hold PermManageRoles and outrank
+// both the member's current role and the role being granted.
+func (s *service) SetRole(ctx context.Context, userID string, id string, targetID string, role string) error {
+	if !validRole(role) {
+		return ErrInvalidInput
+	}
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return err
+	}
+	if c.IsDM() {
+		return ErrDirectMessage
+	}
+	actorRole, err := s.roleOf(ctx, c, userID)
+	if err != nil {
+		return err
+	}
+	if !Can(actorRole, PermManageRoles) {
+		return ErrForbidden
+	}
+	targetRole, err := s.roleOf(ctx, c, targetID)
+	if err != nil {
+		return err
+	}
+	if targetRole == "" {
+		return ErrNotMember
+	}
+	if !Outranks(actorRole, targetRole) || !Outranks(actorRole, role) {
+		return ErrForbidden
+	}
+	ok, err := s.members.SetRole(ctx, id, targetID, role)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotMember
+	}
+	return nil
+}
+
+// TransferOwnership makes another member the owner; the previous owner becomes an admin.
+func (s *service) TransferOwnership(ctx context.Context, userID string, id string, targetID string) error {
+	c, err := s.findRoom(ctx, id)
+	if err != nil {
+		return err
+	}
+	if c.IsDM() {
+		return ErrDirectMessage
+	}
+	if c.OwnerID != userID {
+		return ErrForbidden
+	}
+	if targetID == userID {
+		return ErrInvalidInput
+	}
+	ok, err := s.members.Exists(ctx, id, targetID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotMember
+	}
+	if err := s.repo.UpdateOwner(ctx, c.ID, targetID); err != nil {
+		return err
+	}
+	if _, err := s.members.SetRole(ctx, id, targetID, RoleOwner); err != nil {
+		return err
+	}
+	if _, err := s.members.SetRole(ctx, id, userID, RoleAdmin); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Authorize returns ErrForbidden unless the user's role in the room grants perm.
+func (s *service) Authorize(ctx context.Context, roomID string, userID string, perm Permission) error {
+	c, err := s.findRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	return s.authorize(ctx, c, userID, perm)
+}
+
+// authorize denies every role-gated action in direct messages, which have no owner or staff.
+func (s *service) authorize(ctx context.Context, c *ChatRoom, userID string, perm Permission) error {
+	if c.IsDM() {
+		return ErrDirectMessage
+	}
+	role, err := s.roleOf(ctx, c, userID)
+	if err != nil {
+		return err
+	}
+	if !Can(role, perm) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// roleOf returns the user's role in the room, or an empty string when they are not a member.
+func (s *service) roleOf(ctx context.Context, c *ChatRoom, userID string) (string, error) {
+	if c.OwnerID == userID {
+		return RoleOwner, nil
+	}
+	m, err := s.members.Find(ctx, c.ID.Hex(), userID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", nil
+		}
+		return "", err
+	}
+	return memberRole(c, m), nil
+}
+
+// memberRole resolves a membership record to a role; the room's OwnerID is authoritative for ownership.
+func memberRole(c *ChatRoom, m *Member) string {
+	if m.UserID == c.OwnerID {
+		return RoleOwner
+	}
+	if m.Role == "" || m.Role == RoleOwner {
+		return RoleMember
+	}
+	return m.Role
+}
+
+func newInviteCode() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// MemberRoomIDs lists every room (including direct messages) the user belongs to.
+func (s *service) MemberRoomIDs(ctx context.Context, userID string) ([]string, error) {
+	return s.members.ListRoomIDsByUser(ctx, userID)
+}
+
+// MarkRead advances the user's read position in a room and reports whether it moved.
+// The caller is responsible for checking that msgID belongs to the room.
+func (s *service) MarkRead(ctx context.Context, roomID string, userID string, msgID string) (bool, error) {
+	return s.members.SetLastRead(ctx, roomID, userID, msgID)
+}
+
+// Touch records activity in a room at the given time, so direct messages list the most recently
+// active conversation first.
+func (s *service) Touch(ctx context.Context, roomID string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(roomID)
+	if err != nil {
+		return ErrNotFound
+	}
+	return s.repo.Touch(ctx, oid, at)
+}
+
+func (s *service) findRoom(ctx context.Context, id string) (*ChatRoom, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	c, err := s.repo.FindByID(ctx, oid)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
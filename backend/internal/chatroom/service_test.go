@@ -0,0 +1,737 @@
+package chatroom
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mockRepository implements Repository for unit tests
+type mockRepository struct {
+	rooms map[primitive.ObjectID]*ChatRoom
+}
+
+func newMockRepository(rooms ...*ChatRoom) *mockRepository {
+	m := &mockRepository{rooms: make(map[primitive.ObjectID]*ChatRoom)}
+	for _, c := range rooms {
+		m.rooms[c.ID] = c
+	}
+	return m
+}
+
+func (m *mockRepository) Create(ctx context.Context, c *ChatRoom) error {
+	c.ID = primitive.NewObjectID()
+	m.rooms[c.ID] = c
+	return nil
+}
+
+func (m *mockRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*ChatRoom, error) {
+	if c, ok := m.rooms[id]; ok {
+		return c, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *mockRepository) FindVisible(ctx context.Context, memberRoomIDs []primitive.ObjectID, limit int64, skip int64) ([]ChatRoom, error) {
+	joined := make(map[primitive.ObjectID]bool)
+	for _, id := range memberRoomIDs {
+		joined[id] = true
+	}
+	var out []ChatRoom
+	for _, c := range m.rooms {
+		if !c.IsDM() && (c.EffectiveVisibility() != VisibilityPrivate || joined[c.ID]) {
+			out = append(out, *c)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockRepository) FindByDMKey(ctx context.Context, key string) (*ChatRoom, error) {
+	for _, c := range m.rooms {
+		if c.DMKey == key {
+			return c, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *mockRepository) FindDMs(ctx context.Context, roomIDs []primitive.ObjectID, limit int64, skip int64) ([]ChatRoom, error) {
+	var out []ChatRoom
+	for _, id := range roomIDs {
+		if c, ok := m.rooms[id]; ok && c.IsDM() {
+			out = append(out, *c)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockRepository) UpdateTitle(ctx context.Context, id primitive.ObjectID, title string) error {
+	if c, ok := m.rooms[id]; ok {
+		c.Title = title
+	}
+	return nil
+}
+
+func (m *mockRepository) SetBotsDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error {
+	if c, ok := m.rooms[id]; ok {
+		c.BotsDisabled = disabled
+	}
+	return nil
+}
+
+func (m *mockRepository) UpdateOwner(ctx context.Context, id primitive.ObjectID, ownerID string) error {
+	if c, ok := m.rooms[id]; ok {
+		c.OwnerID = ownerID
+	}
+	return nil
+}
+
+func (m *mockRepository) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	if c, ok := m.rooms[id]; ok && at.After(c.UpdatedAt) {
+		c.UpdatedAt = at
+	}
+	return nil
+}
+
+func (m *mockRepository) Delete(ctx context.Context, id primitive.ObjectID, ownerID string) (bool, error) {
+	c, ok := m.rooms[id]
+	if !ok || c.OwnerID != ownerID {
+		return false, nil
+	}
+	delete(m.rooms, id)
+	return true, nil
+}
+
+// mockMemberRepository implements MemberRepository for unit tests
+type mockMemberRepository struct {
+	members map[string]map[string]Member
+}
+
+func newMockMemberRepository() *mockMemberRepository {
+	return &mockMemberRepository{members: make(map[string]map[string]Member)}
+}
+
+func (m *mockMemberRepository) Add(ctx context.Context, mem *Member) error {
+	set, ok := m.members[mem.RoomID]
+	if !ok {
+		set = make(map[string]Member)
+		m.members[mem.RoomID] = set
+	}
+	if _, exists := set[mem.UserID]; !exists {
+		set[mem.UserID] = *mem
+	}
+	return nil
+}
+
+func (m *mockMemberRepository) Remove(ctx context.Context, roomID string, userID string) (bool, error) {
+	if _, ok := m.members[roomID][userID]; !ok {
+		return false, nil
+	}
+	delete(m.members[roomID], userID)
+	return true, nil
+}
+
+func (m *mockMemberRepository) Exists(ctx context.Context, roomID string, userID string) (bool, error) {
+	_, ok := m.members[roomID][userID]
+	return ok, nil
+}
+
+func (m *mockMemberRepository) Find(ctx context.Context, roomID string, userID string) (*Member, error) {
+	mem, ok := m.members[roomID][userID]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &mem, nil
+}
+
+func (m *mockMemberRepository) SetRole(ctx context.Context, roomID string, userID string, role string) (bool, error) {
+	mem, ok := m.members[roomID][userID]
+	if !ok {
+		return false, nil
+	}
+	mem.Role = role
+	m.members[roomID][userID] = mem
+	return true, nil
+}
+
+func (m *mockMemberRepository) ListByRoom(ctx context.Context, roomID string, limit int64, skip int64) ([]Member, error) {
+	var out []Member
+	for _, mem := range m.members[roomID] {
+		out = append(out, mem)
+	}
+	return out, nil
+}
+
+func (m *mockMemberRepository) ListRoomIDsByUser(ctx context.Context, userID string) ([]string, error) {
+	var out []string
+	for roomID, set := range m.members {
+		if _, ok := set[userID]; ok {
+			out = append(out, roomID)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockMemberRepository) ListByUser(ctx context.Context, userID string) ([]Member, error) {
+	var out []Member
+	for _, set := range m.members {
+		if mem, ok := set[userID]; ok {
+			out = append(out, mem)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockMemberRepository) SetLastRead(ctx context.Context, roomID string, userID string, msgID string) (bool, error) {
+	mem, ok := m.members[roomID][userID]
+	if !ok || mem.LastReadID >= msgID {
+		return false, nil
+	}
+	mem.LastReadID = msgID
+	m.members[roomID][userID] = mem
+	return true, nil
+}
+
+func (m *mockMemberRepository) DeleteByRoom(ctx context.Context, roomID string) error {
+	delete(m.members, roomID)
+	return nil
+}
+
+// mockInviteRepository implements InviteRepository for unit tests
+type mockInviteRepository struct {
+	invites map[string]*Invite
+}
+
+func newMockInviteRepository() *mockInviteRepository {
+	return &mockInviteRepository{invites: make(map[string]*Invite)}
+}
+
+func (m *mockInviteRepository) Create(ctx context.Context, inv *Invite) error {
+	m.invites[inv.Code] = inv
+	return nil
+}
+
+func (m *mockInviteRepository) ListByRoom(ctx context.Context, roomID string) ([]Invite, error) {
+	var out []Invite
+	for _, inv := range m.invites {
+		if inv.RoomID == roomID {
+			out = append(out, *inv)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockInviteRepository) Revoke(ctx context.Context, roomID string, code string) (bool, error) {
+	inv, ok := m.invites[code]
+	if !ok || inv.RoomID != roomID {
+		return false, nil
+	}
+	inv.Revoked = true
+	return true, nil
+}
+
+func (m *mockInviteRepository) FindValid(ctx context.Context, code string, now time.Time) (*Invite, error) {
+	inv, ok := m.invites[code]
+	if !ok || inv.Revoked || !inv.ExpiresAt.After(now) || (inv.MaxUses > 0 && inv.Uses >= inv.MaxUses) {
+		return nil, mongo.ErrNoDocuments
+	}
+	return inv, nil
+}
+
+func (m *mockInviteRepository) Consume(ctx context.Context, code string, now time.Time) (*Invite, error) {
+	inv, ok := m.invites[code]
+	if !ok || inv.Revoked || !inv.ExpiresAt.After(now) || (inv.MaxUses > 0 && inv.Uses >= inv.MaxUses) {
+		return nil, mongo.ErrNoDocuments
+	}
+	inv.Uses++
+	return inv, nil
+}
+
+func (m *mockInviteRepository) DeleteByRoom(ctx context.Context, roomID string) error {
+	for code, inv := range m.invites {
+		if inv.RoomID == roomID {
+			delete(m.invites, code)
+		}
+	}
+	return nil
+}
+
+// mockBanRepository implements BanRepository for unit tests
+type mockBanRepository struct {
+	bans map[string]map[string]Ban
+}
+
+func newMockBanRepository() *mockBanRepository {
+	return &mockBanRepository{bans: make(map[string]map[string]Ban)}
+}
+
+func (m *mockBanRepository) Add(ctx context.Context, b *Ban) error {
+	if m.bans[b.RoomID] == nil {
+		m.bans[b.RoomID] = make(map[string]Ban)
+	}
+	if _, ok := m.bans[b.RoomID][b.UserID]; !ok {
+		m.bans[b.RoomID][b.UserID] = *b
+	}
+	return nil
+}
+
+func (m *mockBanRepository) Remove(ctx context.Context, roomID string, userID string) (bool, error) {
+	if _, ok := m.bans[roomID][userID]; !ok {
+		return false, nil
+	}
+	delete(m.bans[roomID], userID)
+	return true, nil
+}
+
+func (m *mockBanRepository) Exists(ctx context.Context, roomID string, userID string) (bool, error) {
+	_, ok := m.bans[roomID][userID]
+	return ok, nil
+}
+
+func (m *mockBanRepository) ListByRoom(ctx context.Context, roomID string) ([]Ban, error) {
+	var out []Ban
+	for _, b := range m.bans[roomID] {
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+func (m *mockBanRepository) DeleteByRoom(ctx context.Context, roomID string) error {
+	delete(m.bans, roomID)
+	return nil
+}
+
+func newTestService(rooms ...*ChatRoom) Service {
+	return NewService(newMockRepository(rooms...), newMockMemberRepository(), newMockInviteRepository(), newMockBanRepository(), nil, nil)
+}
+
+func TestService_Create_AddsOwnerAsMember(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+
+	resp, err := s.Create(ctx, "owner", CreateChatRoomRequest{Title: "general"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ok, _ := s.IsMember(ctx, resp.ID, "owner")
+	if !ok {
+		t.Fatalf("expected owner to be a member of the new room")
+	}
+}
+
+func TestService_JoinAndLeave(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner"}
+	s := newTestService(room)
+	ctx := context.Background()
+	id := room.ID.Hex()
+
+	if err := s.Join(ctx, "alice", id); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if ok, _ := s.IsMember(ctx, id, "alice"); !ok {
+		t.Fatalf("expected alice to be a member after join")
+	}
+	if err := s.Leave(ctx, "alice", id); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if ok, _ := s.IsMember(ctx, id, "alice"); ok {
+		t.Fatalf("expected alice not to be a member after leave")
+	}
+	if err := s.Leave(ctx, "alice", id); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("expected ErrNotMember on second leave, got %v", err)
+	}
+}
+
+func TestService_Join_UnknownRoom(t *testing.T) {
+	s := newTestService()
+	if err := s.Join(context.Background(), "alice", primitive.NewObjectID().Hex()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestService_Leave_OwnerCannotLeave(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner"}
+	s := newTestService(room)
+	if err := s.Leave(context.Background(), "owner", room.ID.Hex()); !errors.Is(err, ErrOwnerCannotLeave) {
+		t.Fatalf("expected ErrOwnerCannotLeave, got %v", err)
+	}
+}
+
+func TestService_Kick(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner"}
+	s := newTestService(room)
+	ctx := context.Background()
+	id := room.ID.Hex()
+	_ = s.Join(ctx, "alice", id)
+	_ = s.Join(ctx, "bob", id)
+
+	if err := s.Kick(ctx, "alice", id, "bob"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for non-owner kick, got %v", err)
+	}
+	if err := s.Kick(ctx, "owner", id, "bob"); err != nil {
+		t.Fatalf("kick: %v", err)
+	}
+	if ok, _ := s.IsMember(ctx, id, "bob"); ok {
+		t.Fatalf("expected bob to be removed")
+	}
+}
+
+// mockNotifier records the membership removals it is told about.
+type mockNotifier struct {
+	removed []string
+}
+
+func (n *mockNotifier) MemberRemoved(ctx context.Context, roomID string, userID string) error {
+	n.removed = append(n.removed, roomID+"/"+userID)
+	return nil
+}
+
+func TestService_LeaveAndKick_AnnounceRemoval(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner"}
+	n := &mockNotifier{}
+	s := NewService(newMockRepository(room), newMockMemberRepository(), newMockInviteRepository(), newMockBanRepository(), nil, n)
+	ctx := context.Background()
+	id := room.ID.Hex()
+	_ = s.Join(ctx, "alice", id)
+	_ = s.Join(ctx, "bob", id)
+
+	if err := s.Leave(ctx, "alice", id); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if err := s.Kick(ctx, "owner", id, "bob"); err != nil {
+		t.Fatalf("kick: %v", err)
+	}
+	_ = s.Leave(ctx, "alice", id)
+	if len(n.removed) != 2 || n.removed[0] != id+"/alice" || n.removed[1] != id+"/bob" {
+		t.Fatalf("expected one removal each for alice and bob, got %v", n.removed)
+	}
+}
+
+func TestService_Ban_RemovesMemberAndBlocksRejoin(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner"}
+	s := newTestService(room)
+	ctx := context.Background()
+	id := room.ID.Hex()
+	_ = s.Join(ctx, "alice", id)
+	_ = s.Join(ctx, "bob", id)
+
+	if err := s.Ban(ctx, "alice", id, "bob"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for a member banning, got %v", err)
+	}
+	if err := s.Ban(ctx, "owner", id, "bob"); err != nil {
+		t.Fatalf("ban: %v", err)
+	}
+	if ok, _ := s.IsMember(ctx, id, "bob"); ok {
+		t.Fatalf("expected bob to be removed by the ban")
+	}
+	if err := s.Join(ctx, "bob", id); !errors.Is(err, ErrBanned) {
+		t.Fatalf("expected ErrBanned on rejoin, got %v", err)
+	}
+	inv, err := s.CreateInvite(ctx, "owner", id, CreateInviteRequest{})
+	if err != nil {
+		t.Fatalf("create invite: %v", err)
+	}
+	if _, err := s.RedeemInvite(ctx, "bob", inv.Code); !errors.Is(err, ErrBanned) {
+		t.Fatalf("expected ErrBanned when redeeming an invite, got %v", err)
+	}
+	if err := s.Unban(ctx, "owner", id, "bob"); err != nil {
+		t.Fatalf("unban: %v", err)
+	}
+	if err := s.Join(ctx, "bob", id); err != nil {
+		t.Fatalf("expected bob to rejoin after unban, got %v", err)
+	}
+}
+
+func TestService_Ban_RequiresOutranking(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner"}
+	s := newTestService(room)
+	ctx := context.Background()
+	id := room.ID.Hex()
+	_ = s.Join(ctx, "mod", id)
+	_ = s.Join(ctx, "admin", id)
+	_ = s.SetRole(ctx, "owner", id, "mod", RoleModerator)
+	_ = s.SetRole(ctx, "owner", id, "admin", RoleAdmin)
+
+	if err := s.Ban(ctx, "mod", id, "admin"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden banning a higher role, got %v", err)
+	}
+	if err := s.Ban(ctx, "mod", id, "owner"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden banning the owner, got %v", err)
+	}
+	if err := s.Ban(ctx, "mod", id, "stranger"); err != nil {
+		t.Fatalf("expected a non-member to be bannable, got %v", err)
+	}
+}
+
+func TestService_ListMembers_RequiresMembership(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner"}
+	s := newTestService(room)
+	if _, err := s.ListMembers(context.Background(), "stranger", room.ID.Hex(), 10, 0); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+}
+
+func TestService_ListAll_HidesPrivateRooms(t *testing.T) {
+	public := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner", Visibility: VisibilityPublic}
+	private := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner", Visibility: VisibilityPrivate}
+	s := newTestService(public, private)
+	ctx := context.Background()
+
+	items, err := s.ListAll(ctx, "stranger", 20, 0)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(items) != 1 || items[0].ID != public.ID.Hex() {
+		t.Fatalf("expected only the public room, got %+v", items)
+	}
+}
+
+func TestService_Join_RequiresInvite(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner", Visibility: VisibilityInviteOnly}
+	s := newTestService(room)
+	if err := s.Join(context.Background(), "alice", room.ID.Hex()); !errors.Is(err, ErrInviteRequired) {
+		t.Fatalf("expected ErrInviteRequired, got %v", err)
+	}
+}
+
+func TestService_Invite_RedeemAndRevoke(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner", Visibility: VisibilityPrivate}
+	s := newTestService(room)
+	ctx := context.Background()
+	id := room.ID.Hex()
+
+	if _, err := s.CreateInvite(ctx, "alice", id, CreateInviteRequest{}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for non-owner, got %v", err)
+	}
+	if _, err := s.CreateInvite(ctx, "owner", id, CreateInviteRequest{ExpiresIn: "nope"}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+
+	inv, err := s.CreateInvite(ctx, "owner", id, CreateInviteRequest{ExpiresIn: "1h", MaxUses: 1})
+	if err != nil {
+		t.Fatalf("create invite: %v", err)
+	}
+	resp, err := s.RedeemInvite(ctx, "alice", inv.Code)
+	if err != nil {
+		t.Fatalf("redeem: %v", err)
+	}
+	if resp.ID != id {
+		t.Fatalf("expected room %s, got %s", id, resp.ID)
+	}
+	if ok, _ := s.IsMember(ctx, id, "alice"); !ok {
+		t.Fatalf("expected alice to be a member after redeeming")
+	}
+	if _, err := s.RedeemInvite(ctx, "bob", inv.Code); !errors.Is(err, ErrInviteInvalid) {
+		t.Fatalf("expected exhausted invite to be rejected, got %v", err)
+	}
+
+	inv2, _ := s.CreateInvite(ctx, "owner", id, CreateInviteRequest{})
+	if err := s.RevokeInvite(ctx, "owner", id, inv2.Code); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, err := s.RedeemInvite(ctx, "bob", inv2.Code); !errors.Is(err, ErrInviteInvalid) {
+		t.Fatalf("expected revoked invite to be rejected, got %v", err)
+	}
+}
+
+func TestService_Invite_OnlyNewMembersUseItUp(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner", Visibility: VisibilityPrivate}
+	s := newTestService(room)
+	ctx := context.Background()
+	id := room.ID.Hex()
+	if err := s.Ban(ctx, "owner", id, "mallory"); err != nil {
+		t.Fatalf("ban: %v", err)
+	}
+	inv, err := s.CreateInvite(ctx, "owner", id, CreateInviteRequest{MaxUses: 2})
+	if err != nil {
+		t.Fatalf("create invite: %v", err)
+	}
+	uses := func() int {
+		items, _ := s.ListInvites(ctx, "owner", id)
+		return items[0].Uses
+	}
+
+	if _, err := s.RedeemInvite(ctx, "mallory", inv.Code); !errors.Is(err, ErrBanned) {
+		t.Fatalf("expected ErrBanned, got %v", err)
+	}
+	if n := uses(); n != 0 {
+		t.Fatalf("expected a banned user not to use the invite, got %d uses", n)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.RedeemInvite(ctx, "alice", inv.Code); err != nil {
+			t.Fatalf("redeem as alice: %v", err)
+		}
+	}
+	if n := uses(); n != 1 {
+		t.Fatalf("expected alice to use the invite once as a new member only, got %d uses", n)
+	}
+	if _, err := s.RedeemInvite(ctx, "bob", inv.Code); err != nil {
+		t.Fatalf("expected a use to be left for bob, got %v", err)
+	}
+}
+
+func TestService_Roles_ModeratorCanKickMembersOnly(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner"}
+	s := newTestService(room)
+	ctx := context.Background()
+	id := room.ID.Hex()
+	for _, u := range []string{"admin", "mod", "alice"} {
+		_ = s.Join(ctx, u, id)
+	}
+
+	if err := s.SetRole(ctx, "owner", id, "admin", RoleAdmin); err != nil {
+		t.Fatalf("grant admin: %v", err)
+	}
+	if err := s.SetRole(ctx, "admin", id, "mod", RoleModerator); err != nil {
+		t.Fatalf("grant moderator: %v", err)
+	}
+	if err := s.SetRole(ctx, "admin", id, "alice", RoleAdmin); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected admin unable to grant admin, got %v", err)
+	}
+	if err := s.Kick(ctx, "mod", id, "admin"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected moderator unable to kick admin, got %v", err)
+	}
+	if err := s.Kick(ctx, "mod", id, "alice"); err != nil {
+		t.Fatalf("moderator kick member: %v", err)
+	}
+	if err := s.Rename(ctx, "mod", id, UpdateChatRoomRequest{Title: "x"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected moderator unable to rename, got %v", err)
+	}
+	if err := s.Rename(ctx, "admin", id, UpdateChatRoomRequest{Title: "x"}); err != nil {
+		t.Fatalf("admin rename: %v", err)
+	}
+	if err := s.Authorize(ctx, id, "mod", PermDeleteMessages); err != nil {
+		t.Fatalf("expected moderator to delete messages, got %v", err)
+	}
+	if err := s.Authorize(ctx, id, "mod", PermPin); err != nil {
+		t.Fatalf("expected moderator to pin messages, got %v", err)
+	}
+}
+
+func TestService_SetBotsEnabled_RequiresManageBots(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner"}
+	s := newTestService(room)
+	ctx := context.Background()
+	id := room.ID.Hex()
+	for _, u := range []string{"admin", "mod"} {
+		_ = s.Join(ctx, u, id)
+	}
+	_ = s.SetRole(ctx, "owner", id, "admin", RoleAdmin)
+	_ = s.SetRole(ctx, "owner", id, "mod", RoleModerator)
+
+	if err := s.SetBotsEnabled(ctx, "mod", id, false); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected moderator unable to manage bots, got %v", err)
+	}
+	if err := s.SetBotsEnabled(ctx, "admin", id, false); err != nil {
+		t.Fatalf("admin disable bots: %v", err)
+	}
+	if on, err := s.BotsEnabled(ctx, id); err != nil || on {
+		t.Fatalf("expected bots disabled, got %v, %v", on, err)
+	}
+	if err := s.SetBotsEnabled(ctx, "owner", id, true); err != nil {
+		t.Fatalf("owner enable bots: %v", err)
+	}
+	if on, err := s.BotsEnabled(ctx, id); err != nil || !on {
+		t.Fatalf("expected bots enabled, got %v, %v", on, err)
+	}
+}
+
+func TestService_TransferOwnership(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner"}
+	s := newTestService(room)
+	ctx := context.Background()
+	id := room.ID.Hex()
+	_ = s.Join(ctx, "alice", id)
+
+	if err := s.TransferOwnership(ctx, "alice", id, "alice"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for non-owner, got %v", err)
+	}
+	if err := s.TransferOwnership(ctx, "owner", id, "alice"); err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+	if err := s.Authorize(ctx, id, "alice", PermDelete); err != nil {
+		t.Fatalf("expected new owner to be allowed to delete, got %v", err)
+	}
+	if err := s.Authorize(ctx, id, "owner", PermDelete); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected previous owner to lose delete permission, got %v", err)
+	}
+}
+
+func TestService_DirectMessage_RejectsRoomManagement(t *testing.T) {
+	room := &ChatRoom{ID: primitive.NewObjectID(), Kind: KindDM, Visibility: VisibilityPrivate, Participants: []string{"alice", "bob"}}
+	members := newMockMemberRepository()
+	s := NewService(newMockRepository(room), members, newMockInviteRepository(), newMockBanRepository(), nil, nil)
+	ctx := context.Background()
+	id := room.ID.Hex()
+	_ = members.Add(ctx, &Member{RoomID: id, UserID: "alice"})
+	_ = members.Add(ctx, &Member{RoomID: id, UserID: "bob"})
+
+	if items, _ := s.ListAll(ctx, "alice", 20, 0); len(items) != 0 {
+		t.Fatalf("expected direct messages to be excluded from ListAll, got %+v", items)
+	}
+	if err := s.Join(ctx, "carol", id); !errors.Is(err, ErrDirectMessage) {
+		t.Fatalf("expected ErrDirectMessage on join, got %v", err)
+	}
+	if _, err := s.CreateInvite(ctx, "alice", id, CreateInviteRequest{}); !errors.Is(err, ErrDirectMessage) {
+		t.Fatalf("expected ErrDirectMessage on invite, got %v", err)
+	}
+	if err := s.Leave(ctx, "bob", id); !errors.Is(err, ErrDirectMessage) {
+		t.Fatalf("expected ErrDirectMessage on leave, got %v", err)
+	}
+}
+
+// stubUnreadCounter reports a fixed count and records the read positions it was asked about.
+type stubUnreadCounter struct {
+	count int64
+	after map[string]string
+	calls int
+}
+
+func (s *stubUnreadCounter) CountUnread(ctx context.Context, userID string, readPositions map[string]string) (map[string]int64, error) {
+	s.calls++
+	counts := make(map[string]int64, len(readPositions))
+	for roomID, afterID := range readPositions {
+		s.after[roomID] = afterID
+		counts[roomID] = s.count
+	}
+	return counts, nil
+}
+
+func TestService_ListAll_ReportsUnreadForMemberRooms(t *testing.T) {
+	joined := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner", Visibility: VisibilityPublic}
+	other := &ChatRoom{ID: primitive.NewObjectID(), OwnerID: "owner", Visibility: VisibilityPublic}
+	counter := &stubUnreadCounter{count: 3, after: make(map[string]string)}
+	s := NewService(newMockRepository(joined, other), newMockMemberRepository(), newMockInviteRepository(), newMockBanRepository(), counter, nil)
+	ctx := context.Background()
+	_ = s.Join(ctx, "alice", joined.ID.Hex())
+	if _, err := s.MarkRead(ctx, joined.ID.Hex(), "alice", "msg1"); err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+
+	items, err := s.ListAll(ctx, "alice", 20, 0)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	for _, r := range items {
+		switch r.ID {
+		case joined.ID.Hex():
+			if r.UnreadCount != 3 || r.LastReadID != "msg1" {
+				t.Fatalf("expected unread count and read position, got %+v", r)
+			}
+		case other.ID.Hex():
+			if r.UnreadCount != 0 || r.LastReadID != "" {
+				t.Fatalf("expected no per-user state for a room alice did not join, got %+v", r)
+			}
+		}
+	}
+	if counter.after[joined.ID.Hex()] != "msg1" {
+		t.Fatalf("expected unread to be counted after msg1, got %q", counter.after[joined.ID.Hex()])
+	}
+	if counter.calls != 1 {
+		t.Fatalf("expected one count for the whole page, got %d", counter.calls)
+	}
+}
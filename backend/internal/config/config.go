@@ -3,6 +3,9 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // AppConfig holds application configuration values.
@@ -13,6 +16,15 @@ type AppConfig struct {
 	MongoDB     string
 	JWTSecret   string
 	JWTExpires  string
+	// AdminUserIDs may use the operations endpoints under /api/v1/admin.
+	AdminUserIDs []string
+
+	// WebSocket keepalive and limits. The server pings every WSPingInterval and drops
+	// connections that have sent nothing (not even a pong) for WSPongWait.
+	WSPingInterval    time.Duration
+	WSPongWait        time.Duration
+	WSWriteWait       time.Duration
+	WSMaxMessageBytes int64
 }
 
 // Load returns configuration populated from environment variables with sane defaults.
@@ -42,14 +54,65 @@ func Load() AppConfig {
 		log.Printf("JWT_EXPIRES_IN not set, using default: %s", jwtExpires)
 	}
 
+	pongWait := getDuration("WS_PONG_WAIT", 60*time.Second)
+	pingInterval := getDuration("WS_PING_INTERVAL", 30*time.Second)
+	if pingInterval >= pongWait {
+		pingInterval = pongWait * 9 / 10
+		log.Printf("WS_PING_INTERVAL must be shorter than WS_PONG_WAIT, using %s", pingInterval)
+	}
+
 	return AppConfig{
-		Port:        port,
-		MongoURI:    mongoURI,
-		MongoDB:     mongoDB,
-		JWTSecret:   jwtSecret,
-		JWTExpires:  jwtExpires,
-		RabbitMQURI: rabbitMQURI,
+		Port:              port,
+		MongoURI:          mongoURI,
+		MongoDB:           mongoDB,
+		JWTSecret:         jwtSecret,
+		JWTExpires:        jwtExpires,
+		RabbitMQURI:       rabbitMQURI,
+		AdminUserIDs:      getList("ADMIN_USER_IDS"),
+		WSPingInterval:    pingInterval,
+		WSPongWait:        pongWait,
+		WSWriteWait:       getDuration("WS_WRITE_WAIT", 10*time.Second),
+		WSMaxMessageBytes: getInt64("WS_MAX_MESSAGE_BYTES", 16*1024),
+	}
+}
+
+// getDuration parses a Go duration env var, falling back to def when unset or invalid.
+func getDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("invalid %s=%q, using default: %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// getInt64 parses a positive integer env var, falling back to def when unset or invalid.
+func getInt64(key string, def int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		log.Printf("invalid %s=%q, using default: %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// getList splits a comma-separated env var, dropping empty entries.
+func getList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
 	}
+	return out
 }
 
 // getEnv returns env var value or the provided default when empty.
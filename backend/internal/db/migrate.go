@@ -0,0 +1,134 @@
+package db
+
+import (
+	"chatapp/internal/chatroom"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrationBatch is how many writes a migration sends per bulk write.
+const migrationBatch = 500
+
+// migration is a one-off data change, recorded by name in the migrations collection once applied.
+type migration struct {
+	name string
+	run  func(ctx context.Context, db *mongo.Database) error
+}
+
+var migrations = []migration{
+	{name: "backfill_chatroom_members", run: backfillChatRoomMembers},
+}
+
+// Migrate applies the migrations that have not been applied yet, in order. A migration that fails
+// is not recorded and runs again on the next start, so every migration must be safe to repeat.
+func Migrate(ctx context.Context, db *mongo.Database) error {
+	applied := db.Collection("migrations")
+	for _, m := range migrations {
+		n, err := applied.CountDocuments(ctx, bson.M{"_id": m.name}, options.Count().SetLimit(1))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if err := m.run(ctx, db); err != nil {
+			return fmt.Errorf("migration %s: %v", m.name, err)
+		}
+		if _, err := applied.InsertOne(ctx, bson.M{"_id": m.name, "appliedAt": time.Now().UTC()}); err != nil {
+			return err
+		}
+		log.Printf("applied migration %s", m.name)
+	}
+	return nil
+}
+
+// backfillChatRoomMembers adds member rows for rooms created before membership was tracked: the
+// owner of every room, and everyone who has posted in it. Existing rows are left untouched.
+func backfillChatRoomMembers(ctx context.Context, db *mongo.Database) error {
+	members := db.Collection("chatroom_members")
+	var writes []mongo.WriteModel
+	flush := func(force bool) error {
+		if len(writes) == 0 || (!force && len(writes) < migrationBatch) {
+			return nil
+		}
+		_, err := members.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+		writes = writes[:0]
+		return err
+	}
+	add := func(roomID string, userID string, role string, joinedAt time.Time) error {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"roomId": roomID, "userId": userID}).
+			SetUpdate(bson.M{"$setOnInsert": bson.M{"roomId": roomID, "userId": userID, "role": role, "joinedAt": joinedAt}}).
+			SetUpsert(true))
+		return flush(false)
+	}
+
+	rooms := make(map[string]bool)
+	cursor, err := db.Collection("chatrooms").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"ownerId": 1, "createdAt": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var room struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			OwnerID   string             `bson:"ownerId"`
+			CreatedAt time.Time          `bson:"createdAt"`
+		}
+		if err := cursor.Decode(&room); err != nil {
+			return err
+		}
+		rooms[room.ID.Hex()] = true
+		if room.OwnerID == "" {
+			continue
+		}
+		if err := add(room.ID.Hex(), room.OwnerID, chatroom.RoleOwner, room.CreatedAt); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	// Bot messages carry no user ID and make nobody a member.
+	posters, err := db.Collection("messages").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userId": bson.M{"$ne": ""}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"roomId": "$roomId", "userId": "$userId"},
+			"first": bson.M{"$min": "$createdAt"},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer posters.Close(ctx)
+	for posters.Next(ctx) {
+		var p struct {
+			ID struct {
+				RoomID string `bson:"roomId"`
+				UserID string `bson:"userId"`
+			} `bson:"_id"`
+			First time.Time `bson:"first"`
+		}
+		if err := posters.Decode(&p); err != nil {
+			return err
+		}
+		if !rooms[p.ID.RoomID] {
+			continue
+		}
+		if err := add(p.ID.RoomID, p.ID.UserID, chatroom.RoleMember, p.First); err != nil {
+			return err
+		}
+	}
+	if err := posters.Err(); err != nil {
+		return err
+	}
+	return flush(true)
+}
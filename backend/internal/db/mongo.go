@@ -2,10 +2,12 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
@@ -39,6 +41,10 @@ func BuildIndexes(ctx context.Context, db *mongo.Database) error {
 		return fmt.Errorf("error ensuring message indexes: %v", err)
 	}
 
+	if err := BuildPresenceIndexes(ctx, db); err != nil {
+		return fmt.Errorf("error ensuring presence indexes: %v", err)
+	}
+
 	return nil
 }
 
@@ -54,6 +60,16 @@ func BuildUserIndexes(ctx context.Context, db *mongo.Database) error {
 		log.Printf("error creating users.email unique index: %v", err)
 		return err
 	}
+	// Case-insensitive name lookups for @mentions; the collation must match user.FindByNames.
+	nameModel := mongo.IndexModel{
+		Keys: map[string]int{"name": 1},
+		Options: options.Index().SetName("idx_users_name").
+			SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+	}
+	if _, err := users.Indexes().CreateOne(ctx, nameModel); err != nil {
+		log.Printf("error creating users.name index: %v", err)
+		return err
+	}
 	return nil
 }
 
@@ -65,6 +81,15 @@ func BuildChatRoomIndexes(ctx context.Context, db *mongo.Database) error {
 			Keys:    map[string]int{"ownerId": 1},
 			Options: options.Index().SetName("idx_chatrooms_owner"),
 		},
+		{
+			Keys:    map[string]int{"visibility": 1},
+			Options: options.Index().SetName("idx_chatrooms_visibility"),
+		},
+		{
+			Keys: map[string]int{"dmKey": 1},
+			Options: options.Index().SetUnique(true).SetName("uq_chatrooms_dm_key").
+				SetPartialFilterExpression(bson.M{"dmKey": bson.M{"$exists": true}}),
+		},
 	}
 	for _, m := range models {
 		if _, err := rooms.Indexes().CreateOne(ctx, m); err != nil {
@@ -72,6 +97,52 @@ func BuildChatRoomIndexes(ctx context.Context, db *mongo.Database) error {
 			return err
 		}
 	}
+
+	members := db.Collection("chatroom_members")
+	memberModels := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "roomId", Value: 1}, {Key: "userId", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("uq_chatroom_members_room_user"),
+		},
+		{
+			Keys:    map[string]int{"userId": 1},
+			Options: options.Index().SetName("idx_chatroom_members_user"),
+		},
+	}
+	for _, m := range memberModels {
+		if _, err := members.Indexes().CreateOne(ctx, m); err != nil {
+			log.Printf("error creating chatroom member index: %v", err)
+			return err
+		}
+	}
+
+	invites := db.Collection("chatroom_invites")
+	inviteModels := []mongo.IndexModel{
+		{
+			Keys:    map[string]int{"code": 1},
+			Options: options.Index().SetUnique(true).SetName("uq_chatroom_invites_code"),
+		},
+		{
+			Keys:    map[string]int{"roomId": 1},
+			Options: options.Index().SetName("idx_chatroom_invites_room"),
+		},
+	}
+	for _, m := range inviteModels {
+		if _, err := invites.Indexes().CreateOne(ctx, m); err != nil {
+			log.Printf("error creating chatroom invite index: %v", err)
+			return err
+		}
+	}
+
+	bans := db.Collection("chatroom_bans")
+	banModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "roomId", Value: 1}, {Key: "userId", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("uq_chatroom_bans_room_user"),
+	}
+	if _, err := bans.Indexes().CreateOne(ctx, banModel); err != nil {
+		log.Printf("error creating chatroom ban index: %v", err)
+		return err
+	}
 	return nil
 }
 
@@ -83,6 +154,31 @@ func BuildMessageIndexes(ctx context.Context, db *mongo.Database) error {
 			Keys:    map[string]int{"roomId": 1},
 			Options: options.Index().SetName("idx_messages_room"),
 		},
+		{
+			Keys: bson.D{{Key: "parentId", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("idx_messages_thread").
+				SetPartialFilterExpression(bson.M{"parentId": bson.M{"$exists": true}}),
+		},
+		{
+			Keys: bson.D{{Key: "mentions", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("idx_messages_mentions").
+				SetPartialFilterExpression(bson.M{"mentions": bson.M{"$exists": true}}),
+		},
+		{
+			Keys: bson.D{{Key: "roomId", Value: 1}, {Key: "pinnedAt", Value: -1}},
+			Options: options.Index().SetName("idx_messages_pinned").
+				SetPartialFilterExpression(bson.M{"pinnedAt": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "text", Value: "text"}},
+			Options: options.Index().SetName("txt_messages_text"),
+		},
+		{
+			// One message per submission; messages stored without a key are not indexed.
+			Keys: map[string]int{"idempotencyKey": 1},
+			Options: options.Index().SetUnique(true).SetName("uq_messages_idempotency").
+				SetPartialFilterExpression(bson.M{"idempotencyKey": bson.M{"$exists": true}}),
+		},
 	}
 	for _, m := range models {
 		if _, err := col.Indexes().CreateOne(ctx, m); err != nil {
@@ -92,3 +188,47 @@ func BuildMessageIndexes(ctx context.Context, db *mongo.Database) error {
 	}
 	return nil
 }
+
+// presenceSessionTTL is how long, in seconds, an unrefreshed presence session survives. Running
+// instances sweep stale sessions well before that and announce them offline; the TTL index only
+// cleans up after deployments where no instance was left to sweep.
+const presenceSessionTTL = 600
+
+// indexOptionsConflict is the server error for an index that exists with other options.
+const indexOptionsConflict = 85
+
+// BuildPresenceIndexes ensures indexes for presence sessions, including the TTL index that backs
+// up the presence sweeper.
+func BuildPresenceIndexes(ctx context.Context, db *mongo.Database) error {
+	col := db.Collection("presence_sessions")
+	models := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "roomId", Value: 1}, {Key: "userId", Value: 1}},
+			Options: options.Index().SetName("idx_presence_room_user"),
+		},
+		{
+			Keys:    map[string]int{"instanceId": 1},
+			Options: options.Index().SetName("idx_presence_instance"),
+		},
+		{
+			Keys:    map[string]int{"seenAt": 1},
+			Options: options.Index().SetName("ttl_presence_seen").SetExpireAfterSeconds(presenceSessionTTL),
+		},
+	}
+	for _, m := range models {
+		_, err := col.Indexes().CreateOne(ctx, m)
+		var se mongo.ServerError
+		if errors.As(err, &se) && se.HasErrorCode(indexOptionsConflict) && *m.Options.Name == "ttl_presence_seen" {
+			// Older releases expired sessions after 90 seconds; move the existing index to the new TTL.
+			err = db.RunCommand(ctx, bson.D{
+				{Key: "collMod", Value: col.Name()},
+				{Key: "index", Value: bson.M{"name": "ttl_presence_seen", "expireAfterSeconds": presenceSessionTTL}},
+			}).Err()
+		}
+		if err != nil {
+			log.Printf("error creating presence index: %v", err)
+			return err
+		}
+	}
+	return nil
+}
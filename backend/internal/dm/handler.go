@@ -0,0 +1,63 @@
+package dm
+
+import (
+	"chatapp/internal/auth"
+	"chatapp/internal/constants"
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(s Service) *Handler {
+	return &Handler{service: s}
+}
+
+func (h *Handler) RegisterRoutes(r *gin.Engine, jwtSecret string) {
+	group := r.Group(constants.APIv1 + "/dm")
+	group.Use(auth.AuthMiddleware(jwtSecret))
+	group.POST("", h.open)
+	group.GET("", h.list)
+}
+
+func (h *Handler) open(c *gin.Context) {
+	var req OpenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+	uid := c.GetString("uid")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	resp, err := h.service.Open(ctx, uid, req.UserID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case ErrInvalidTarget:
+			status = http.StatusBadRequest
+		case ErrUserNotFound:
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) list(c *gin.Context) {
+	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 64)
+	skip, _ := strconv.ParseInt(c.DefaultQuery("skip", "0"), 10, 64)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	items, err := h.service.List(ctx, c.GetString("uid"), limit, skip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
@@ -0,0 +1,19 @@
+package dm
+
+import "time"
+
+// OpenRequest is the payload to open (or fetch) a direct message with another user.
+type OpenRequest struct {
+	UserID string `json:"userId" binding:"required"`
+}
+
+// Response is a public representation of a direct message conversation.
+type Response struct {
+	ID           string    `json:"id"`
+	Participants []string  `json:"participants"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	// LastReadID and UnreadCount are only filled in when listing conversations.
+	LastReadID  string `json:"lastReadId,omitempty"`
+	UnreadCount int64  `json:"unreadCount"`
+}
@@ -0,0 +1,153 @@
+package dm
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"chatapp/internal/chatroom"
+	"chatapp/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrInvalidTarget = errors.New("invalid direct message target")
+var ErrUserNotFound = errors.New("user not found")
+
+type Service interface {
+	Open(ctx context.Context, userID string, targetID string) (*Response, error)
+	List(ctx context.Context, userID string, limit int64, skip int64) ([]Response, error)
+}
+
+type service struct {
+	rooms   chatroom.Repository
+	members chatroom.MemberRepository
+	users   user.Repository
+	unread  chatroom.UnreadCounter
+}
+
+// NewService creates the direct message service. unread may be nil, in which case no unread counts
+// are reported.
+func NewService(rooms chatroom.Repository, members chatroom.MemberRepository, users user.Repository, unread chatroom.UnreadCounter) Service {
+	return &service{rooms: rooms, members: members, users: users, unread: unread}
+}
+
+// Open returns the direct message room between the two users, creating it on first use. Both
+// participants are made members on every call, so a conversation whose creation was interrupted
+// before its members were added is repaired the next time either user opens it.
+func (s *service) Open(ctx context.Context, userID string, targetID string) (*Response, error) {
+	targetID = strings.TrimSpace(targetID)
+	if targetID == "" || targetID == userID {
+		return nil, ErrInvalidTarget
+	}
+	if _, err := s.users.FindByID(ctx, targetID); err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	key := dmKey(userID, targetID)
+	room, err := s.rooms.FindByDMKey(ctx, key)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	if room == nil {
+		room, err = s.create(ctx, key, userID, targetID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.addParticipants(ctx, room); err != nil {
+		return nil, err
+	}
+	resp := toResponse(room)
+	return &resp, nil
+}
+
+func (s *service) create(ctx context.Context, key string, userID string, targetID string) (*chatroom.ChatRoom, error) {
+	now := time.Now().UTC()
+	room := &chatroom.ChatRoom{
+		Visibility:   chatroom.VisibilityPrivate,
+		Kind:         chatroom.KindDM,
+		Participants: []string{userID, targetID},
+		DMKey:        key,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.rooms.Create(ctx, room); err != nil {
+		// Another request created the same conversation concurrently; use theirs.
+		if mongo.IsDuplicateKeyError(err) {
+			return s.rooms.FindByDMKey(ctx, key)
+		}
+		return nil, err
+	}
+	return room, nil
+}
+
+// addParticipants makes both participants members of the room. Adding a member is an upsert, so
+// repeating it for existing members changes nothing.
+func (s *service) addParticipants(ctx context.Context, room *chatroom.ChatRoom) error {
+	now := time.Now().UTC()
+	for _, uid := range room.Participants {
+		m := &chatroom.Member{RoomID: room.ID.Hex(), UserID: uid, Role: chatroom.RoleMember, JoinedAt: now}
+		if err := s.members.Add(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *service) List(ctx context.Context, userID string, limit int64, skip int64) ([]Response, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if skip < 0 {
+		skip = 0
+	}
+	memberships, err := s.members.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	lastRead := make(map[string]string, len(memberships))
+	oids := make([]primitive.ObjectID, 0, len(memberships))
+	for _, m := range memberships {
+		lastRead[m.RoomID] = m.LastReadID
+		if oid, err := primitive.ObjectIDFromHex(m.RoomID); err == nil {
+			oids = append(oids, oid)
+		}
+	}
+	items, err := s.rooms.FindDMs(ctx, oids, limit, skip)
+	if err != nil {
+		return nil, err
+	}
+	positions := make(map[string]string, len(items))
+	for _, c := range items {
+		positions[c.ID.Hex()] = lastRead[c.ID.Hex()]
+	}
+	unread := map[string]int64{}
+	if s.unread != nil && len(positions) > 0 {
+		if unread, err = s.unread.CountUnread(ctx, userID, positions); err != nil {
+			return nil, err
+		}
+	}
+	resp := make([]Response, 0, len(items))
+	for i := range items {
+		r := toResponse(&items[i])
+		r.LastReadID = positions[r.ID]
+		r.UnreadCount = unread[r.ID]
+		resp = append(resp, r)
+	}
+	return resp, nil
+}
+
+// dmKey identifies a user pair independently of who opened the conversation.
+func dmKey(a, b string) string {
+	ids := []string{a, b}
+	sort.Strings(ids)
+	return ids[0] + ":" + ids[1]
+}
+
+func toResponse(c *chatroom.ChatRoom) Response {
+	return Response{ID: c.ID.Hex(), Participants: c.Participants, CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt}
+}
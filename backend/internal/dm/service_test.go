@@ -0,0 +1,231 @@
+package dm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"chatapp/internal/chatroom"
+	"chatapp/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mockRoomRepository implements the chatroom.Repository methods used by the dm service.
+type mockRoomRepository struct {
+	chatroom.Repository
+	rooms []*chatroom.ChatRoom
+}
+
+func (m *mockRoomRepository) Create(ctx context.Context, c *chatroom.ChatRoom) error {
+	c.ID = primitive.NewObjectID()
+	m.rooms = append(m.rooms, c)
+	return nil
+}
+
+func (m *mockRoomRepository) FindByDMKey(ctx context.Context, key string) (*chatroom.ChatRoom, error) {
+	for _, c := range m.rooms {
+		if c.DMKey == key {
+			return c, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *mockRoomRepository) FindDMs(ctx context.Context, roomIDs []primitive.ObjectID, limit int64, skip int64) ([]chatroom.ChatRoom, error) {
+	var out []chatroom.ChatRoom
+	for _, c := range m.rooms {
+		for _, id := range roomIDs {
+			if c.ID == id && c.IsDM() {
+				out = append(out, *c)
+			}
+		}
+	}
+	return out, nil
+}
+
+// mockMemberRepository implements the chatroom.MemberRepository methods used by the dm service.
+type mockMemberRepository struct {
+	chatroom.MemberRepository
+	members []chatroom.Member
+	// failAdds makes that many calls to Add fail before they start succeeding.
+	failAdds int
+}
+
+// Add upserts like the real repository: an existing member is left as is.
+func (m *mockMemberRepository) Add(ctx context.Context, mem *chatroom.Member) error {
+	if m.failAdds > 0 {
+		m.failAdds--
+		return errors.New("write failed")
+	}
+	for _, existing := range m.members {
+		if existing.RoomID == mem.RoomID && existing.UserID == mem.UserID {
+			return nil
+		}
+	}
+	m.members = append(m.members, *mem)
+	return nil
+}
+
+func (m *mockMemberRepository) ListByUser(ctx context.Context, userID string) ([]chatroom.Member, error) {
+	var out []chatroom.Member
+	for _, mem := range m.members {
+		if mem.UserID == userID {
+			out = append(out, mem)
+		}
+	}
+	return out, nil
+}
+
+// mockUnreadCounter reports a fixed count per room and records how often it was asked.
+type mockUnreadCounter struct {
+	count int64
+	calls int
+	after map[string]string
+}
+
+func (m *mockUnreadCounter) CountUnread(ctx context.Context, userID string, readPositions map[string]string) (map[string]int64, error) {
+	m.calls++
+	m.after = readPositions
+	counts := make(map[string]int64, len(readPositions))
+	for roomID := range readPositions {
+		counts[roomID] = m.count
+	}
+	return counts, nil
+}
+
+// mockUserRepository implements the user.Repository methods used by the dm service.
+type mockUserRepository struct {
+	user.Repository
+	known map[string]bool
+}
+
+func (m *mockUserRepository) FindByID(ctx context.Context, id string) (*user.User, error) {
+	if !m.known[id] {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &user.User{Name: id}, nil
+}
+
+func newTestService() (Service, *mockRoomRepository, *mockMemberRepository) {
+	rooms := &mockRoomRepository{}
+	members := &mockMemberRepository{}
+	users := &mockUserRepository{known: map[string]bool{"alice": true, "bob": true}}
+	return NewService(rooms, members, users, nil), rooms, members
+}
+
+func TestService_Open_ReusesConversation(t *testing.T) {
+	s, rooms, members := newTestService()
+	ctx := context.Background()
+
+	first, err := s.Open(ctx, "alice", "bob")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	second, err := s.Open(ctx, "bob", "alice")
+	if err != nil {
+		t.Fatalf("open reverse: %v", err)
+	}
+	if first.ID != second.ID {
+		t.Fatalf("expected the same conversation, got %s and %s", first.ID, second.ID)
+	}
+	if len(rooms.rooms) != 1 || !rooms.rooms[0].IsDM() {
+		t.Fatalf("expected exactly one dm room, got %+v", rooms.rooms)
+	}
+	if len(members.members) != 2 {
+		t.Fatalf("expected both participants to be members, got %+v", members.members)
+	}
+}
+
+func TestService_Open_RepairsMissingMembers(t *testing.T) {
+	s, rooms, members := newTestService()
+	members.failAdds = 1
+	ctx := context.Background()
+
+	if _, err := s.Open(ctx, "alice", "bob"); err == nil {
+		t.Fatal("expected the failed member write to be reported")
+	}
+	if len(rooms.rooms) != 1 || len(members.members) != 0 {
+		t.Fatalf("expected a room without members, got %d rooms and %+v", len(rooms.rooms), members.members)
+	}
+
+	resp, err := s.Open(ctx, "bob", "alice")
+	if err != nil {
+		t.Fatalf("open again: %v", err)
+	}
+	if resp.ID != rooms.rooms[0].ID.Hex() || len(rooms.rooms) != 1 {
+		t.Fatalf("expected the existing room to be reused, got %s", resp.ID)
+	}
+	if len(members.members) != 2 {
+		t.Fatalf("expected both participants to be members again, got %+v", members.members)
+	}
+}
+
+func TestService_Open_InvalidTarget(t *testing.T) {
+	s, _, _ := newTestService()
+	ctx := context.Background()
+
+	if _, err := s.Open(ctx, "alice", "alice"); !errors.Is(err, ErrInvalidTarget) {
+		t.Fatalf("expected ErrInvalidTarget, got %v", err)
+	}
+	if _, err := s.Open(ctx, "alice", "ghost"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestService_List(t *testing.T) {
+	s, _, _ := newTestService()
+	ctx := context.Background()
+	if _, err := s.Open(ctx, "alice", "bob"); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	items, err := s.List(ctx, "bob", 20, 0)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(items) != 1 || len(items[0].Participants) != 2 {
+		t.Fatalf("unexpected list: %+v", items)
+	}
+}
+
+func TestService_List_ReportsUnread(t *testing.T) {
+	rooms := &mockRoomRepository{}
+	members := &mockMemberRepository{}
+	users := &mockUserRepository{known: map[string]bool{"alice": true, "bob": true, "carol": true}}
+	counter := &mockUnreadCounter{count: 2}
+	s := NewService(rooms, members, users, counter)
+	ctx := context.Background()
+
+	first, err := s.Open(ctx, "alice", "bob")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := s.Open(ctx, "carol", "bob"); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := range members.members {
+		if members.members[i].RoomID == first.ID && members.members[i].UserID == "bob" {
+			members.members[i].LastReadID = "msg1"
+		}
+	}
+
+	items, err := s.List(ctx, "bob", 20, 0)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("expected two conversations, got %+v", items)
+	}
+	if counter.calls != 1 {
+		t.Fatalf("expected one count for the whole page, got %d", counter.calls)
+	}
+	if counter.after[first.ID] != "msg1" {
+		t.Fatalf("expected unread to be counted after msg1, got %q", counter.after[first.ID])
+	}
+	for _, it := range items {
+		if it.UnreadCount != 2 {
+			t.Fatalf("expected 2 unread in %s, got %d", it.ID, it.UnreadCount)
+		}
+	}
+}
@@ -2,48 +2,237 @@ package events
 
 import (
 	"context"
+	"errors"
+	"log"
+	"sync"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// transientTTL is the per-message expiration, in milliseconds, applied by PublishTransientJSON.
+const transientTTL = "5000"
+
+// maxBackoff caps the delay between connection attempts.
+const maxBackoff = 3 * time.Second
+
+// confirmTimeout bounds the wait for the broker to confirm a publish.
+const confirmTimeout = 5 * time.Second
+
+// publishAttempts is how often PublishJSON tries before giving up.
+const publishAttempts = 3
+
+// ErrNotConnected is returned while the broker connection is down and being re-established.
+var ErrNotConnected = errors.New("amqp: not connected")
+
+// ErrNacked is returned when the broker refused to take responsibility for a message.
+var ErrNacked = errors.New("amqp: publish not confirmed by broker")
+
+// Consumer declares its queues and consumes from them on a channel of its own. Start must return
+// once consuming has begun; its delivery loop ends when the connection is lost. A Start that fails
+// closes its channel and is called again later.
+type Consumer interface {
+	Start(ctx context.Context) error
+}
+
+// AMQP is a supervised broker connection. Supervise watches it, reconnects with backoff after a
+// loss, re-declares the exchange and restarts every registered consumer, so a broker restart does
+// not leave the service deaf.
 type AMQP struct {
-	conn     *amqp.Connection
-	channel  *amqp.Channel
+	uri      string
 	exchange string
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	channel   *amqp.Channel
+	consumers []Consumer
+	// closed is set by Close and stops Supervise from reconnecting.
+	closed bool
 }
 
 func NewAMQP(ctx context.Context, uri string, exchange string) (*AMQP, error) {
+	a := &AMQP{uri: uri, exchange: exchange}
 	deadline := time.Now().Add(60 * time.Second)
 	var lastErr error
 	for attempt := 0; time.Now().Before(deadline); attempt++ {
-		conn, err := amqp.Dial(uri)
-		if err == nil {
-			ch, chErr := conn.Channel()
-			if chErr == nil {
-				if err := ch.ExchangeDeclare(exchange, "topic", true, false, false, false, nil); err == nil {
-					return &AMQP{conn: conn, channel: ch, exchange: exchange}, nil
+		if lastErr = a.connect(); lastErr == nil {
+			return a, nil
+		}
+		time.Sleep(backoff(attempt))
+	}
+	return nil, lastErr
+}
+
+// backoff is the delay before connection attempt n+1: exponential from 200ms, capped at maxBackoff.
+func backoff(attempt int) time.Duration {
+	if attempt > 4 {
+		return maxBackoff
+	}
+	sleep := time.Duration(200*(1<<attempt)) * time.Millisecond
+	if sleep > maxBackoff {
+		sleep = maxBackoff
+	}
+	return sleep
+}
+
+// connect dials the broker, declares the exchange and installs the new connection.
+func (a *AMQP) connect() error {
+	conn, err := amqp.Dial(a.uri)
+	if err != nil {
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if err := ch.Confirm(false); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if err := ch.ExchangeDeclare(a.exchange, "topic", true, false, false, false, nil); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	a.mu.Lock()
+	a.conn, a.channel = conn, ch
+	a.mu.Unlock()
+	return nil
+}
+
+// Register adds consumers that Supervise starts now and again after every reconnect.
+// It must be called before Supervise.
+func (a *AMQP) Register(consumers ...Consumer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.consumers = append(a.consumers, consumers...)
+}
+
+// Supervise starts the registered consumers and keeps the connection alive until ctx is done.
+func (a *AMQP) Supervise(ctx context.Context) {
+	for {
+		a.mu.RLock()
+		conn, ch := a.conn, a.channel
+		a.mu.RUnlock()
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+		// session ends the start attempts of consumers that have not come up on this connection.
+		session := make(chan struct{})
+		a.startConsumers(ctx, session)
+
+	watch:
+		for {
+			select {
+			case <-ctx.Done():
+				close(session)
+				return
+			case err := <-connClosed:
+				log.Printf("amqp: connection lost: %v", err)
+				close(session)
+				break watch
+			case err := <-chClosed:
+				// The publishing channel died on its own (a channel-level exception); replace it.
+				log.Printf("amqp: publish channel closed: %v", err)
+				chClosed = a.reopenChannel(conn)
+				if chClosed == nil {
+					_ = conn.Close()
 				}
-				lastErr = err
-				_ = ch.Close()
-				_ = conn.Close()
-			} else {
-				lastErr = chErr
-				_ = conn.Close()
 			}
-		} else {
-			lastErr = err
 		}
-		sleep := time.Duration(200*(1<<attempt)) * time.Millisecond
-		if sleep > 3*time.Second {
-			sleep = 3 * time.Second
+		if a.isClosed() || !a.reconnect(ctx) {
+			return
 		}
-		time.Sleep(sleep)
+		log.Printf("amqp: reconnected")
 	}
-	return nil, lastErr
+}
+
+// startConsumers starts every registered consumer independently. A consumer that fails to start
+// is retried with its own backoff until it starts or session ends; the connection, the publish
+// channel and the other consumers are left alone.
+func (a *AMQP) startConsumers(ctx context.Context, session <-chan struct{}) {
+	a.mu.RLock()
+	consumers := append([]Consumer(nil), a.consumers...)
+	a.mu.RUnlock()
+	for _, c := range consumers {
+		if err := c.Start(ctx); err != nil {
+			log.Printf("amqp: consumer %T failed to start: %v", c, err)
+			go a.retryConsumer(ctx, session, c)
+		}
+	}
+}
+
+func (a *AMQP) retryConsumer(ctx context.Context, session <-chan struct{}, c Consumer) {
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-session:
+			return
+		case <-time.After(backoff(attempt)):
+		}
+		err := c.Start(ctx)
+		if err == nil {
+			log.Printf("amqp: consumer %T started", c)
+			return
+		}
+		log.Printf("amqp: consumer %T failed to start (attempt %d): %v", c, attempt+2, err)
+	}
+}
+
+// reopenChannel replaces the publishing channel on conn and returns its close notifications, or
+// nil if conn can no longer open channels.
+func (a *AMQP) reopenChannel(conn *amqp.Connection) chan *amqp.Error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil
+	}
+	if err := ch.Confirm(false); err != nil {
+		return nil
+	}
+	a.mu.Lock()
+	a.channel = ch
+	a.mu.Unlock()
+	return ch.NotifyClose(make(chan *amqp.Error, 1))
+}
+
+// reconnect dials with backoff until it succeeds or ctx is done.
+func (a *AMQP) reconnect(ctx context.Context) bool {
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff(attempt)):
+		}
+		err := a.connect()
+		if err == nil {
+			return true
+		}
+		log.Printf("amqp: reconnect attempt %d failed: %v", attempt+1, err)
+	}
+}
+
+// Channel opens a channel on the current connection, for consumers.
+func (a *AMQP) Channel() (*amqp.Channel, error) {
+	a.mu.RLock()
+	conn := a.conn
+	a.mu.RUnlock()
+	if conn == nil || conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+	return conn.Channel()
+}
+
+func (a *AMQP) isClosed() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.closed
 }
 
 func (a *AMQP) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
 	if a.channel != nil {
 		_ = a.channel.Close()
 	}
@@ -52,6 +241,42 @@ func (a *AMQP) Close() {
 	}
 }
 
+// currentChannel returns the publishing channel, or ErrNotConnected during a reconnect.
+func (a *AMQP) currentChannel() (*amqp.Channel, error) {
+	a.mu.RLock()
+	ch := a.channel
+	a.mu.RUnlock()
+	if ch == nil || ch.IsClosed() {
+		return nil, ErrNotConnected
+	}
+	return ch, nil
+}
+
+// publishConfirmed publishes once to exchange and waits up to confirmTimeout for the broker's
+// confirmation.
+func (a *AMQP) publishConfirmed(ctx context.Context, exchange string, routingKey string, pub amqp.Publishing) error {
+	ch, err := a.currentChannel()
+	if err != nil {
+		return err
+	}
+	dc, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, false, false, pub)
+	if err != nil {
+		return err
+	}
+	wait, cancel := context.WithTimeout(ctx, confirmTimeout)
+	defer cancel()
+	acked, err := dc.WaitContext(wait)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return ErrNacked
+	}
+	return nil
+}
+
+// PublishJSON publishes a persistent message and returns once the broker has confirmed it. Nacks,
+// confirmation timeouts and publishes during a reconnect are retried up to publishAttempts times.
 func (a *AMQP) PublishJSON(ctx context.Context, routingKey string, body []byte) error {
 	pub := amqp.Publishing{
 		ContentType:  "application/json",
@@ -59,5 +284,37 @@ func (a *AMQP) PublishJSON(ctx context.Context, routingKey string, body []byte)
 		DeliveryMode: amqp.Persistent,
 		Timestamp:    time.Now(),
 	}
-	return a.channel.PublishWithContext(ctx, a.exchange, routingKey, false, false, pub)
+	var err error
+	for attempt := 0; attempt < publishAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(backoff(attempt - 1)):
+			}
+		}
+		if err = a.publishConfirmed(ctx, a.exchange, routingKey, pub); err == nil {
+			return nil
+		}
+		log.Printf("amqp: publish %s attempt %d failed: %v", routingKey, attempt+1, err)
+	}
+	return err
+}
+
+// PublishTransientJSON publishes an ephemeral event that is neither written to disk by the broker
+// nor kept longer than transientTTL, for signals such as typing indicators that are useless once stale.
+// It neither waits for a confirmation nor retries.
+func (a *AMQP) PublishTransientJSON(ctx context.Context, routingKey string, body []byte) error {
+	pub := amqp.Publishing{
+		ContentType:  "application/json",
+		Body:         body,
+		DeliveryMode: amqp.Transient,
+		Expiration:   transientTTL,
+		Timestamp:    time.Now(),
+	}
+	ch, err := a.currentChannel()
+	if err != nil {
+		return err
+	}
+	return ch.PublishWithContext(ctx, a.exchange, routingKey, false, false, pub)
 }
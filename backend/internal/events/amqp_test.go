@@ -0,0 +1,66 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyConsumer fails its first failures starts.
+type flakyConsumer struct {
+	mu       sync.Mutex
+	failures int
+	starts   int
+}
+
+func (c *flakyConsumer) Start(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.starts++
+	if c.starts <= c.failures {
+		return errors.New("declare failed")
+	}
+	return nil
+}
+
+func (c *flakyConsumer) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.starts
+}
+
+func TestStartConsumers_RetriesOnlyTheFailingConsumer(t *testing.T) {
+	failing := &flakyConsumer{failures: 2}
+	healthy := &flakyConsumer{}
+	a := &AMQP{}
+	a.Register(failing, healthy)
+	session := make(chan struct{})
+	defer close(session)
+
+	a.startConsumers(context.Background(), session)
+	if healthy.count() != 1 {
+		t.Fatalf("expected the consumer after the failing one to start, got %d starts", healthy.count())
+	}
+	for deadline := time.Now().Add(3 * time.Second); failing.count() < 3 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if failing.count() != 3 || healthy.count() != 1 {
+		t.Fatalf("expected 3 starts of the failing consumer and 1 of the healthy one, got %d and %d", failing.count(), healthy.count())
+	}
+}
+
+func TestStartConsumers_StopsRetryingWhenSessionEnds(t *testing.T) {
+	failing := &flakyConsumer{failures: 100}
+	a := &AMQP{}
+	a.Register(failing)
+	session := make(chan struct{})
+
+	a.startConsumers(context.Background(), session)
+	close(session)
+	time.Sleep(300 * time.Millisecond)
+	if failing.count() != 1 {
+		t.Fatalf("expected no retries after the session ended, got %d starts", failing.count())
+	}
+}
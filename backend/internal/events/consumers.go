@@ -5,105 +5,384 @@ import (
 	"chatapp/internal/user"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"strings"
 	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// MembershipChecker reports whether a user may post into a room.
+type MembershipChecker interface {
+	IsMember(ctx context.Context, roomID string, userID string) (bool, error)
+}
+
+// BotSwitch reports whether bot commands are turned on in a room.
+type BotSwitch interface {
+	BotsEnabled(ctx context.Context, roomID string) (bool, error)
+}
+
+var errNotRoomMember = errors.New("not a member of this room")
+
+var errBotsDisabled = errors.New("bots are disabled in this room")
+
+// errNotPublished marks a submit whose event the broker did not confirm. A stored message is
+// announced again when the sender retries with the same idempotency key.
+var errNotPublished = errors.New("failed to deliver message")
+
 // IngressConsumer handles SubmitMessage, persists, emits MessageCreated, and triggers bot requests.
 type IngressConsumer struct {
 	AMQP    *AMQP
 	Service message.Service
 	Users   user.Repository
+	Rooms   MembershipChecker
+	// Bots, if set, is consulted before a bot command is handed to the bots.
+	Bots BotSwitch
 }
 
 func (c *IngressConsumer) Start(ctx context.Context) error {
-	ch, err := c.AMQP.conn.Channel()
+	return c.AMQP.consumeDurable(ctx, "chat.ingress.v2", "chat.ingress", []string{RKMessageSubmit}, func(d amqp.Delivery) error {
+		return c.handle(ctx, d)
+	})
+}
+
+// handle processes one submit. When storing or announcing the message fails, the sender is told
+// to retry and the error is returned so the submit is retried as well; the idempotency key keeps
+// either retry from storing the message twice.
+func (c *IngressConsumer) handle(ctx context.Context, d amqp.Delivery) error {
+	var s SubmitMessage
+	if err := json.Unmarshal(d.Body, &s); err != nil {
+		return permanent(err)
+	}
+	if c.Rooms != nil {
+		ok, err := c.Rooms.IsMember(ctx, s.RoomID, s.UserID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Printf("ingress: dropping submit from non-member uid=%s room=%s", s.UserID, s.RoomID)
+			c.reportResult(ctx, s, nil, errNotRoomMember)
+			return nil
+		}
+	}
+
+	trim := strings.TrimSpace(s.Text)
+	if strings.HasPrefix(trim, "/") {
+		if c.Bots != nil {
+			on, err := c.Bots.BotsEnabled(ctx, s.RoomID)
+			if err != nil {
+				return err
+			}
+			if !on {
+				c.reportResult(ctx, s, nil, errBotsDisabled)
+				return nil
+			}
+		}
+		parts := strings.SplitN(trim[1:], " ", 2)
+		cmd := parts[0]
+		args := ""
+		if len(parts) > 1 {
+			args = parts[1]
+		}
+		req := BotRequested{Command: cmd, Args: args, RoomID: s.RoomID, RequestUserID: s.UserID, MessageID: "", RequestedAt: time.Now().UTC(), RequestID: s.IdempotencyKey}
+		bb, _ := json.Marshal(req)
+		if err := c.AMQP.PublishJSON(ctx, RKBotRequested, bb); err != nil {
+			err = fmt.Errorf("%w: %v", errNotPublished, err)
+			c.reportResult(ctx, s, nil, err)
+			return err
+		}
+
+		// do not persist bot invocations to the DB
+		c.reportResult(ctx, s, nil, nil)
+		return nil
+	}
+
+	var userName string
+	if c.Users != nil {
+		if u, err := c.Users.FindByID(ctx, s.UserID); err == nil {
+			userName = u.Name
+		}
+	}
+	mentions := c.resolveMentions(ctx, s)
+	var m *message.Message
+	var err error
+	if s.ParentID != "" {
+		m, err = c.Service.CreateReply(ctx, s.UserID, userName, s.RoomID, s.ParentID, s.Text, mentions, s.IdempotencyKey)
+	} else {
+		m, err = c.Service.CreateWithName(ctx, s.UserID, userName, s.RoomID, s.Text, mentions, s.IdempotencyKey)
+	}
+	duplicate := errors.Is(err, message.ErrDuplicate)
+	if err != nil && !duplicate {
+		c.reportResult(ctx, s, nil, err)
+		if errors.Is(err, message.ErrEmptyMessage) || errors.Is(err, message.ErrParentNotFound) {
+			return nil
+		}
+		return err
+	}
+	// A duplicate was stored before; it is announced again only if that never got confirmed.
+	if !duplicate || m.PublishedAt == nil {
+		if err := announce(ctx, c.AMQP, c.Service, m, s.ClientMsgID); err != nil {
+			err = fmt.Errorf("%w: %v", errNotPublished, err)
+			c.reportResult(ctx, s, nil, err)
+			return err
+		}
+	}
+	c.reportResult(ctx, s, m, nil)
+	if len(m.Mentions) > 0 && !duplicate {
+		mb, _ := json.Marshal(newUserMentioned(m, m.Mentions))
+		if err := c.AMQP.PublishJSON(ctx, RKUserMentioned, mb); err != nil {
+			log.Printf("ingress: mention event for message %s not published: %v", m.ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// announce publishes message.created for m and, once the broker has confirmed it, records that on
+// the message. A failed announcement leaves the message unmarked so a retry announces it again.
+func announce(ctx context.Context, a *AMQP, svc message.Service, m *message.Message, clientMsgID string) error {
+	evt := MessageCreated{Event: RKMessageCreated, ID: m.ID.Hex(), RoomID: m.RoomID, UserID: m.UserID, UserName: m.UserName, Text: m.Text, Type: m.Type, CreatedAt: m.CreatedAt, ParentID: m.ParentID, ClientMsgID: clientMsgID}
+	b, _ := json.Marshal(evt)
+	if err := a.PublishJSON(ctx, RKMessageCreated, b); err != nil {
+		return err
+	}
+	if err := svc.MarkPublished(ctx, m); err != nil {
+		// The event is out; at worst a retry announces the message twice, which clients deduplicate.
+		log.Printf("events: failed to mark message %s published: %v", m.ID.Hex(), err)
+	}
+	return nil
+}
+
+// reportResult publishes the outcome of a submit that carries a client message ID: the stored
+// message m, nothing for a bot command, or the error that rejected it. Errors other than invalid
+// input are reported as temporary without their details.
+func (c *IngressConsumer) reportResult(ctx context.Context, s SubmitMessage, m *message.Message, err error) {
+	if s.ClientMsgID == "" {
+		return
+	}
+	res := SubmitResult{Event: RKSubmitResult, RoomID: s.RoomID, UserID: s.UserID, ClientMsgID: s.ClientMsgID}
+	switch {
+	case err == nil && m != nil:
+		res.MessageID = m.ID.Hex()
+		res.Message = m
+	case errors.Is(err, errNotRoomMember), errors.Is(err, errBotsDisabled), errors.Is(err, message.ErrEmptyMessage), errors.Is(err, message.ErrParentNotFound):
+		res.Error = err.Error()
+	case errors.Is(err, errNotPublished):
+		log.Printf("ingress: submit uid=%s room=%s not delivered: %v", s.UserID, s.RoomID, err)
+		res.Error = errNotPublished.Error()
+		res.Temporary = true
+	case err != nil:
+		log.Printf("ingress: failed to store message uid=%s room=%s: %v", s.UserID, s.RoomID, err)
+		res.Error = "failed to store message"
+		res.Temporary = true
+	}
+	b, _ := json.Marshal(res)
+	if err := c.AMQP.PublishJSON(ctx, RKSubmitResult, b); err != nil {
+		log.Printf("ingress: submit result uid=%s msg=%s not published: %v", s.UserID, s.ClientMsgID, err)
+	}
+}
+
+// Broadcaster broadcasts to clients subscribed via WebSocket.
+type Broadcaster interface {
+	Broadcast(roomID string, payload any)
+	// Acknowledge answers a pending submit on the sender's connection.
+	Acknowledge(res SubmitResult)
+	// RemoveMember drops the subscriptions a user holds to a room they no longer belong to.
+	RemoveMember(roomID string, userID string)
+	// BroadcastToUsers sends a room's payload to the connections of the given users only.
+	BroadcastToUsers(roomID string, userIDs []string, payload any)
+}
+
+// broadcastKeys are the routing keys forwarded verbatim to room clients. Submit results and
+// mentions are consumed alongside them but only reach the sender or the mentioned users. Presence
+// changes and typing indicators expire in transit and have consumers of their own, so that expired
+// ones are dropped instead of filling the dead-letter queue.
+var broadcastKeys = []string{RKMessageCreated, RKMessageUpdated, RKMessageDeleted, RKMessageReaction, RKMessagePinned, RKUserMentioned, RKMessageRead, RKMemberRemoved, RKSubmitResult}
+
+// legacyBroadcastQueue is the durable queue all instances used to share, which split events
+// between them. It is no longer consumed and is removed once nothing uses it.
+const legacyBroadcastQueue = "chat.broadcast"
+
+// broadcastDeadLetters names the dead-letter queue shared by the broadcast queues of all instances.
+const broadcastDeadLetters = "chat.broadcast"
+
+var errMissingRoom = errors.New("event has no roomId")
+
+// BroadcastConsumer fans room events out to the local hub. Like TypingConsumer, each instance
+// consumes from its own exclusive, auto-deleted queue, so every instance sees every event and can
+// serve the clients connected to it; events published while an instance is down are not kept for it.
+type BroadcastConsumer struct {
+	AMQP *AMQP
+	Hub  Broadcaster
+}
+
+func (c *BroadcastConsumer) Start(ctx context.Context) (err error) {
+	c.dropLegacyQueue()
+	ch, err := c.AMQP.Channel()
 	if err != nil {
 		return err
 	}
-	if _, err := ch.QueueDeclare("chat.ingress", true, false, false, false, nil); err != nil {
+	defer func() {
+		if err != nil {
+			_ = ch.Close()
+		}
+	}()
+	if err := declareDeadLetters(ch, broadcastDeadLetters); err != nil {
 		return err
 	}
-	if err := ch.QueueBind("chat.ingress", RKMessageSubmit, c.AMQP.exchange, false, nil); err != nil {
+	q, err := ch.QueueDeclare("", false, true, true, false, amqp.Table{"x-dead-letter-exchange": deadLetterExchange(broadcastDeadLetters)})
+	if err != nil {
 		return err
 	}
-	msgs, err := ch.Consume("chat.ingress", "", true, false, false, false, nil)
+	for _, key := range broadcastKeys {
+		if err := ch.QueueBind(q.Name, key, c.AMQP.exchange, false, nil); err != nil {
+			return err
+		}
+	}
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		return err
+	}
+	msgs, err := ch.Consume(q.Name, "", false, true, false, false, nil)
 	if err != nil {
 		return err
 	}
-	go func() {
-		for d := range msgs {
-			var s SubmitMessage
-			if err := json.Unmarshal(d.Body, &s); err != nil {
-				continue
-			}
-
-			trim := strings.TrimSpace(s.Text)
-			if strings.HasPrefix(trim, "/") {
-				parts := strings.SplitN(trim[1:], " ", 2)
-				cmd := parts[0]
-				args := ""
-				if len(parts) > 1 {
-					args = parts[1]
-				}
-				req := BotRequested{Command: cmd, Args: args, RoomID: s.RoomID, RequestUserID: s.UserID, MessageID: "", RequestedAt: time.Now().UTC()}
-				bb, _ := json.Marshal(req)
-				_ = c.AMQP.PublishJSON(ctx, RKBotRequested, bb)
-
-				// do not persist bot invocations to the DB
-				continue
-			}
+	// Handing an event to the hub cannot fail transiently, so only malformed events are dead-lettered.
+	go c.AMQP.handleDeliveries(ctx, msgs, broadcastDeadLetters, false, c.handle)
+	return nil
+}
 
-			var userName string
-			if c.Users != nil {
-				if u, err := c.Users.FindByID(ctx, s.UserID); err == nil {
-					userName = u.Name
-				}
-			}
-			m, err := c.Service.CreateWithName(ctx, s.UserID, userName, s.RoomID, s.Text)
-			if err != nil {
-				continue
-			}
-			evt := MessageCreated{ID: m.ID.Hex(), RoomID: m.RoomID, UserID: m.UserID, UserName: m.UserName, Text: m.Text, Type: m.Type, CreatedAt: m.CreatedAt}
-			b, _ := json.Marshal(evt)
-			_ = c.AMQP.PublishJSON(ctx, RKMessageCreated, b)
+func (c *BroadcastConsumer) handle(d amqp.Delivery) error {
+	if d.RoutingKey == RKSubmitResult {
+		var res SubmitResult
+		if err := json.Unmarshal(d.Body, &res); err != nil {
+			return permanent(err)
 		}
-	}()
+		c.Hub.Acknowledge(res)
+		return nil
+	}
+	if d.RoutingKey == RKUserMentioned {
+		var evt UserMentioned
+		if err := json.Unmarshal(d.Body, &evt); err != nil {
+			return permanent(err)
+		}
+		if evt.RoomID == "" {
+			return permanent(errMissingRoom)
+		}
+		c.Hub.BroadcastToUsers(evt.RoomID, evt.UserIDs, json.RawMessage(d.Body))
+		return nil
+	}
+	var evt struct {
+		RoomID string `json:"roomId"`
+	}
+	if err := json.Unmarshal(d.Body, &evt); err != nil {
+		return permanent(err)
+	}
+	if evt.RoomID == "" {
+		return permanent(errMissingRoom)
+	}
+	c.Hub.Broadcast(evt.RoomID, json.RawMessage(d.Body))
+	if d.RoutingKey == RKMemberRemoved {
+		var removed MemberRemoved
+		if err := json.Unmarshal(d.Body, &removed); err != nil {
+			return permanent(err)
+		}
+		c.Hub.RemoveMember(removed.RoomID, removed.UserID)
+	}
 	return nil
 }
 
-// Broadcaster broadcasts to clients subscribed via WebSocket.
-type Broadcaster interface {
+// dropLegacyQueue deletes the old shared broadcast queue so it stops collecting events nobody
+// reads. It is skipped while an instance of an older release still consumes it; a failed delete
+// closes only the throwaway channel.
+func (c *BroadcastConsumer) dropLegacyQueue() {
+	ch, err := c.AMQP.Channel()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	if _, err := ch.QueueDelete(legacyBroadcastQueue, true, false, false); err != nil {
+		log.Printf("broadcast: legacy queue %s not removed: %v", legacyBroadcastQueue, err)
+	}
+}
+
+// TypingBroadcaster sends a payload to a room's clients, skipping those of one user.
+type TypingBroadcaster interface {
+	BroadcastExcept(roomID string, userID string, payload any)
+}
+
+// TypingConsumer fans out typing indicators. Each instance consumes from its own exclusive,
+// auto-deleted queue so every instance sees every event; nothing is kept across restarts.
+type TypingConsumer struct {
+	AMQP *AMQP
+	Hub  TypingBroadcaster
+}
+
+func (c *TypingConsumer) Start(ctx context.Context) error {
+	return c.AMQP.consumeTransient(RKUserTyping, c.handle)
+}
+
+// handle passes a typing indicator on to the room's clients other than the typist's.
+// Malformed events are dropped; they are transient and not worth retrying.
+func (c *TypingConsumer) handle(body []byte) {
+	var evt UserTyping
+	if err := json.Unmarshal(body, &evt); err != nil || evt.RoomID == "" {
+		return
+	}
+	c.Hub.BroadcastExcept(evt.RoomID, evt.UserID, json.RawMessage(body))
+}
+
+// PresenceBroadcaster sends a payload to a room's clients.
+type PresenceBroadcaster interface {
 	Broadcast(roomID string, payload any)
 }
 
-type BroadcastConsumer struct {
+// PresenceConsumer fans out presence changes the same way TypingConsumer fans out typing indicators.
+type PresenceConsumer struct {
 	AMQP *AMQP
-	Hub  Broadcaster
+	Hub  PresenceBroadcaster
+}
+
+func (c *PresenceConsumer) Start(ctx context.Context) error {
+	return c.AMQP.consumeTransient(RKPresence, c.handle)
+}
+
+// handle passes a presence change on to the room's clients, dropping malformed events.
+func (c *PresenceConsumer) handle(body []byte) {
+	var evt PresenceChanged
+	if err := json.Unmarshal(body, &evt); err != nil || evt.RoomID == "" {
+		return
+	}
+	c.Hub.Broadcast(evt.RoomID, json.RawMessage(body))
 }
 
-func (c *BroadcastConsumer) Start(ctx context.Context) error {
-	ch, err := c.AMQP.conn.Channel()
+// consumeTransient passes the events published under key to handle. Each instance consumes from
+// its own exclusive, auto-deleted queue without acknowledgements or dead-lettering: the events
+// are published with PublishTransientJSON and are worthless once they expire.
+func (a *AMQP) consumeTransient(key string, handle func(body []byte)) (err error) {
+	ch, err := a.Channel()
 	if err != nil {
 		return err
 	}
-	if _, err := ch.QueueDeclare("chat.broadcast", true, false, false, false, nil); err != nil {
+	defer func() {
+		if err != nil {
+			_ = ch.Close()
+		}
+	}()
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
 		return err
 	}
-	if err := ch.QueueBind("chat.broadcast", RKMessageCreated, c.AMQP.exchange, false, nil); err != nil {
+	if err := ch.QueueBind(q.Name, key, a.exchange, false, nil); err != nil {
 		return err
 	}
-	msgs, err := ch.Consume("chat.broadcast", "", true, false, false, false, nil)
+	msgs, err := ch.Consume(q.Name, "", true, true, false, false, nil)
 	if err != nil {
 		return err
 	}
 	go func() {
 		for d := range msgs {
-			var evt MessageCreated
-			if err := json.Unmarshal(d.Body, &evt); err != nil {
-				continue
-			}
-			c.Hub.Broadcast(evt.RoomID, evt)
+			handle(d.Body)
 		}
 	}()
 	return nil
@@ -116,34 +395,28 @@ type BotResponseConsumer struct {
 }
 
 func (c *BotResponseConsumer) Start(ctx context.Context) error {
-	ch, err := c.AMQP.conn.Channel()
-	if err != nil {
-		return err
+	return c.AMQP.consumeDurable(ctx, "chat.bot.response.v2", "chat.bot.response", []string{RKBotResponse}, func(d amqp.Delivery) error {
+		return c.handle(ctx, d)
+	})
+}
+
+// handle stores a bot response and announces it. A response whose message was stored but not
+// announced is retried, and the retry announces the stored message.
+func (c *BotResponseConsumer) handle(ctx context.Context, d amqp.Delivery) error {
+	var r BotResponseSubmit
+	if err := json.Unmarshal(d.Body, &r); err != nil {
+		return permanent(err)
 	}
-	if _, err := ch.QueueDeclare("chat.bot.response", true, false, false, false, nil); err != nil {
-		return err
+	m, err := c.Service.CreateBotMessage(ctx, r.RoomID, r.Text, r.IdempotencyKey)
+	duplicate := errors.Is(err, message.ErrDuplicate)
+	if errors.Is(err, message.ErrEmptyMessage) {
+		return permanent(err)
 	}
-	if err := ch.QueueBind("chat.bot.response", RKBotResponse, c.AMQP.exchange, false, nil); err != nil {
+	if err != nil && !duplicate {
 		return err
 	}
-	msgs, err := ch.Consume("chat.bot.response", "", true, false, false, false, nil)
-	if err != nil {
-		return err
+	if !duplicate || m.PublishedAt == nil {
+		return announce(ctx, c.AMQP, c.Service, m, "")
 	}
-	go func() {
-		for d := range msgs {
-			var r BotResponseSubmit
-			if err := json.Unmarshal(d.Body, &r); err != nil {
-				continue
-			}
-			m, err := c.Service.CreateBotMessage(ctx, r.RoomID, r.Text)
-			if err != nil {
-				continue
-			}
-			evt := MessageCreated{ID: m.ID.Hex(), RoomID: m.RoomID, UserID: m.UserID, UserName: m.UserName, Text: m.Text, Type: m.Type, CreatedAt: m.CreatedAt}
-			b, _ := json.Marshal(evt)
-			_ = c.AMQP.PublishJSON(ctx, RKMessageCreated, b)
-		}
-	}()
 	return nil
 }
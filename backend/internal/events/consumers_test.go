@@ -2,12 +2,15 @@ package events
 
 import (
 	"context"
+	"encoding/json"
+	"reflect"
 	"testing"
 	"time"
 
 	"chatapp/internal/message"
 	"chatapp/internal/user"
 
+	amqp "github.com/rabbitmq/amqp091-go"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -19,18 +22,80 @@ type mockMessageService struct {
 	botError       error
 }
 
-func (m *mockMessageService) CreateWithName(ctx context.Context, userID, userName, roomID, text string) (*message.Message, error) {
+func (m *mockMessageService) CreateWithName(ctx context.Context, userID, userName, roomID, text string, mentions []string, key string) (*message.Message, error) {
 	return m.createdMessage, m.createError
 }
 
-func (m *mockMessageService) CreateBotMessage(ctx context.Context, roomID, text string) (*message.Message, error) {
+func (m *mockMessageService) CreateReply(ctx context.Context, userID, userName, roomID, parentID, text string, mentions []string, key string) (*message.Message, error) {
+	return m.createdMessage, m.createError
+}
+
+func (m *mockMessageService) Thread(ctx context.Context, userID, roomID, msgID string, limit int64, cursor string) (*message.ThreadResponse, error) {
+	return &message.ThreadResponse{}, nil
+}
+
+func (m *mockMessageService) CreateBotMessage(ctx context.Context, roomID, text, key string) (*message.Message, error) {
 	return m.botMessage, m.botError
 }
 
-func (m *mockMessageService) List(ctx context.Context, roomID string, limit int64, cursor string) ([]message.Message, string, error) {
+func (m *mockMessageService) MarkPublished(ctx context.Context, msg *message.Message) error {
+	now := time.Now()
+	msg.PublishedAt = &now
+	return nil
+}
+
+func (m *mockMessageService) List(ctx context.Context, userID, roomID string, limit int64, cursor string) ([]message.Message, string, error) {
 	return []message.Message{}, "", nil
 }
 
+func (m *mockMessageService) Since(ctx context.Context, userID, roomID, afterID string, limit int64) ([]message.Message, error) {
+	return []message.Message{}, nil
+}
+
+func (m *mockMessageService) Edit(ctx context.Context, userID, roomID, msgID, text string) (*message.Message, error) {
+	return m.createdMessage, m.createError
+}
+
+func (m *mockMessageService) Delete(ctx context.Context, userID, roomID, msgID string) error {
+	return nil
+}
+
+func (m *mockMessageService) AddReaction(ctx context.Context, userID, roomID, msgID, emoji string) (*message.Message, error) {
+	return m.createdMessage, m.createError
+}
+
+func (m *mockMessageService) RemoveReaction(ctx context.Context, userID, roomID, msgID, emoji string) (*message.Message, error) {
+	return m.createdMessage, m.createError
+}
+
+func (m *mockMessageService) Search(ctx context.Context, userID string, q message.SearchQuery) (*message.SearchResponse, error) {
+	return &message.SearchResponse{}, nil
+}
+
+func (m *mockMessageService) Mentions(ctx context.Context, userID string, limit int64, cursor string) ([]message.Message, string, error) {
+	return []message.Message{}, "", nil
+}
+
+func (m *mockMessageService) MarkRead(ctx context.Context, userID, roomID, msgID string) error {
+	return nil
+}
+
+func (m *mockMessageService) Revisions(ctx context.Context, userID, roomID, msgID string) ([]message.Revision, error) {
+	return []message.Revision{}, nil
+}
+
+func (m *mockMessageService) Pin(ctx context.Context, userID, roomID, msgID string) (*message.Message, error) {
+	return nil, nil
+}
+
+func (m *mockMessageService) Unpin(ctx context.Context, userID, roomID, msgID string) (*message.Message, error) {
+	return nil, nil
+}
+
+func (m *mockMessageService) Pins(ctx context.Context, userID, roomID string) ([]message.Message, error) {
+	return []message.Message{}, nil
+}
+
 // mockUserRepository mocks user.Repository for testing
 type mockUserRepository struct {
 	user *user.User
@@ -49,9 +114,19 @@ func (m *mockUserRepository) FindByID(ctx context.Context, id string) (*user.Use
 	return m.user, m.err
 }
 
+func (m *mockUserRepository) FindByNames(ctx context.Context, names []string) ([]user.User, error) {
+	if m.user == nil {
+		return nil, m.err
+	}
+	return []user.User{*m.user}, m.err
+}
+
 // mockBroadcaster mocks Broadcaster for testing
 type mockBroadcaster struct {
 	broadcasts []BroadcastCall
+	removed    []string
+	targeted   []string
+	skipped    []string
 }
 
 type BroadcastCall struct {
@@ -63,6 +138,57 @@ func (m *mockBroadcaster) Broadcast(roomID string, payload any) {
 	m.broadcasts = append(m.broadcasts, BroadcastCall{RoomID: roomID, Payload: payload})
 }
 
+func (m *mockBroadcaster) Acknowledge(res SubmitResult) {
+}
+
+func (m *mockBroadcaster) BroadcastToUsers(roomID string, userIDs []string, payload any) {
+	m.targeted = append(m.targeted, userIDs...)
+}
+
+func (m *mockBroadcaster) RemoveMember(roomID string, userID string) {
+	m.removed = append(m.removed, roomID+"/"+userID)
+}
+
+func (m *mockBroadcaster) BroadcastExcept(roomID string, userID string, payload any) {
+	m.broadcasts = append(m.broadcasts, BroadcastCall{RoomID: roomID, Payload: payload})
+	m.skipped = append(m.skipped, userID)
+}
+
+func TestPresenceConsumer_BroadcastsToRoom(t *testing.T) {
+	broadcaster := &mockBroadcaster{}
+	consumer := &PresenceConsumer{Hub: broadcaster}
+
+	body, _ := json.Marshal(PresenceChanged{Event: RKPresence, RoomID: "room1", UserID: "alice", Status: "away", At: time.Now().UTC()})
+	consumer.handle(body)
+	consumer.handle([]byte(`{"event":"presence.changed","userId":"alice"}`))
+
+	if len(broadcaster.broadcasts) != 1 || broadcaster.broadcasts[0].RoomID != "room1" {
+		t.Fatalf("expected one broadcast to room1, got %+v", broadcaster.broadcasts)
+	}
+	for _, key := range broadcastKeys {
+		if key == RKPresence {
+			t.Fatal("expected presence changes to stay off the dead-lettered broadcast queue")
+		}
+	}
+}
+
+func TestTypingConsumer_SkipsTypist(t *testing.T) {
+	broadcaster := &mockBroadcaster{}
+	consumer := &TypingConsumer{Hub: broadcaster}
+
+	body, _ := json.Marshal(UserTyping{Event: RKUserTyping, RoomID: "room1", UserID: "alice", At: time.Now().UTC()})
+	consumer.handle(body)
+	consumer.handle([]byte(`{"event":"user.typing","userId":"alice"}`))
+	consumer.handle([]byte(`not json`))
+
+	if len(broadcaster.broadcasts) != 1 || broadcaster.broadcasts[0].RoomID != "room1" {
+		t.Fatalf("expected one broadcast to room1, got %+v", broadcaster.broadcasts)
+	}
+	if len(broadcaster.skipped) != 1 || broadcaster.skipped[0] != "alice" {
+		t.Fatalf("expected the typist to be skipped, got %v", broadcaster.skipped)
+	}
+}
+
 func TestCommandParsing(t *testing.T) {
 	// Test command parsing logic
 	trim := "/echo hello world"
@@ -139,7 +265,7 @@ func TestBotResponseConsumer_ProcessResponse(t *testing.T) {
 	}
 
 	// Simulate message creation
-	m, err := service.CreateBotMessage(context.Background(), response.RoomID, response.Text)
+	m, err := service.CreateBotMessage(context.Background(), response.RoomID, response.Text, "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -162,6 +288,79 @@ func TestUserLookup(t *testing.T) {
 	}
 }
 
+func TestParseMentions(t *testing.T) {
+	got := parseMentions("@alice can you ask @Bob. and @alice? mail me at carol@example.com")
+	want := [][]string{{"alice can you", "alice can", "alice"}, {"Bob"}, {"alice"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	got = parseMentions("thanks @Jane Doe, and @Jane Doe again")
+	want = [][]string{{"Jane Doe", "Jane"}, {"Jane Doe again", "Jane Doe", "Jane"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestResolveMentions_MultiWordNames(t *testing.T) {
+	jane := &user.User{ID: primitive.NewObjectID(), Name: "Jane Doe"}
+	r := &MentionResolver{Users: &mockUserRepository{user: jane}}
+	ids := r.Resolve(context.Background(), "room1", "alice", "ask @jane doe about it, or @Jane Doe again")
+	if len(ids) != 1 || ids[0] != jane.ID.Hex() {
+		t.Fatalf("expected Jane Doe to be mentioned once, got %v", ids)
+	}
+	if ids := r.Resolve(context.Background(), "room1", "alice", "ask @Jane about it"); len(ids) != 0 {
+		t.Fatalf("expected a partial name not to match, got %v", ids)
+	}
+}
+
+type mockMembership struct {
+	members map[string]bool
+}
+
+func (m *mockMembership) IsMember(ctx context.Context, roomID, userID string) (bool, error) {
+	return m.members[roomID+"/"+userID], nil
+}
+
+func TestResolveMentions_OnlyRoomMembers(t *testing.T) {
+	bob := &user.User{ID: primitive.NewObjectID(), Name: "bob"}
+	c := &IngressConsumer{
+		Users: &mockUserRepository{user: bob},
+		Rooms: &mockMembership{members: map[string]bool{}},
+	}
+	s := SubmitMessage{RoomID: "room1", UserID: "alice", Text: "hi @bob"}
+	if ids := c.resolveMentions(context.Background(), s); len(ids) != 0 {
+		t.Fatalf("expected non-members to be ignored, got %v", ids)
+	}
+	c.Rooms = &mockMembership{members: map[string]bool{"room1/" + bob.ID.Hex(): true}}
+	if ids := c.resolveMentions(context.Background(), s); len(ids) != 1 || ids[0] != bob.ID.Hex() {
+		t.Fatalf("expected bob to be mentioned, got %v", ids)
+	}
+}
+
+type mockBotSwitch struct {
+	disabled map[string]bool
+	checked  int
+}
+
+func (m *mockBotSwitch) BotsEnabled(ctx context.Context, roomID string) (bool, error) {
+	m.checked++
+	return !m.disabled[roomID], nil
+}
+
+func TestIngressConsumer_SkipsCommandsWhenBotsDisabled(t *testing.T) {
+	bots := &mockBotSwitch{disabled: map[string]bool{"room1": true}}
+	svc := &mockMessageService{}
+	c := &IngressConsumer{Service: svc, Bots: bots}
+	body, _ := json.Marshal(SubmitMessage{RoomID: "room1", UserID: "alice", Text: "/stock=aapl.us"})
+	// With no AMQP connection, reaching the publish of the bot request would panic.
+	if err := c.handle(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if bots.checked != 1 {
+		t.Fatalf("expected the room's bot switch to be checked once, got %d", bots.checked)
+	}
+}
+
 // Helper functions for testing command parsing
 func startsWithSlash(s string) bool {
 	return len(s) > 0 && s[0] == '/'
@@ -1,22 +1,73 @@
 package events
 
-import "time"
+import (
+	"chatapp/internal/message"
+	"time"
+)
 
 // Routing keys
 const (
-	RKMessageSubmit  = "message.submit"
-	RKMessageCreated = "message.created"
-	RKBotRequested   = "bot.requested"
-	RKBotResponse    = "bot.response.submit"
+	RKMessageSubmit   = "message.submit"
+	RKMessageCreated  = "message.created"
+	RKMessageUpdated  = "message.updated"
+	RKMessageDeleted  = "message.deleted"
+	RKMessageReaction = "message.reaction"
+	RKMessagePinned   = "message.pinned"
+	RKUserMentioned   = "user.mentioned"
+	RKMessageRead     = "message.read"
+	RKUserTyping      = "user.typing"
+	RKPresence        = "presence.changed"
+	RKMemberRemoved   = "member.removed"
+	RKSubmitResult    = "message.submit.result"
+	RKBotRequested    = "bot.requested"
+	RKBotResponse     = "bot.response.submit"
 )
 
 type SubmitMessage struct {
 	RoomID string `json:"roomId"`
 	UserID string `json:"userId"`
 	Text   string `json:"text"`
+	// ParentID, when set, posts the message as a thread reply.
+	ParentID string `json:"parentId,omitempty"`
+	// ClientMsgID is the sender's request ID; when set, ingress reports the outcome as a SubmitResult.
+	ClientMsgID string `json:"clientMsgId,omitempty"`
+	// IdempotencyKey deduplicates retries and redeliveries: a key is stored and broadcast at most once.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+// SubmitResult tells the instance holding the sender's connection how a submit ended.
+// MessageID and Message are empty for bot commands, which are not persisted; Error is set on
+// rejection, with Temporary marking failures that are worth retrying.
+type SubmitResult struct {
+	Event       string           `json:"event"`
+	RoomID      string           `json:"roomId"`
+	UserID      string           `json:"userId"`
+	ClientMsgID string           `json:"clientMsgId"`
+	MessageID   string           `json:"messageId,omitempty"`
+	Message     *message.Message `json:"message,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	Temporary   bool             `json:"temporary,omitempty"`
 }
 
+// MessageCreated is broadcast to room clients when a message is persisted.
+// Event carries the routing key so clients can tell broadcast kinds apart.
 type MessageCreated struct {
+	Event     string    `json:"event"`
+	ID        string    `json:"id"`
+	RoomID    string    `json:"roomId"`
+	UserID    string    `json:"userId"`
+	UserName  string    `json:"userName"`
+	Text      string    `json:"text"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	ParentID  string    `json:"parentId,omitempty"`
+	// ClientMsgID echoes the sender's request ID so its client can match the message to its submit.
+	ClientMsgID string `json:"clientMsgId,omitempty"`
+}
+
+// MessageUpdated is broadcast to room clients when a message is edited.
+type MessageUpdated struct {
+	Event     string    `json:"event"`
 	ID        string    `json:"id"`
 	RoomID    string    `json:"roomId"`
 	UserID    string    `json:"userId"`
@@ -24,6 +75,89 @@ type MessageCreated struct {
 	Text      string    `json:"text"`
 	Type      string    `json:"type"`
 	CreatedAt time.Time `json:"createdAt"`
+	EditedAt  time.Time `json:"editedAt"`
+}
+
+// MessageDeleted is broadcast to room clients when a message is tombstoned.
+type MessageDeleted struct {
+	Event     string    `json:"event"`
+	ID        string    `json:"id"`
+	RoomID    string    `json:"roomId"`
+	DeletedBy string    `json:"deletedBy"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// MessageReaction is broadcast to room clients when a reaction is added or removed.
+// Reactions carries the full aggregated counts so clients can replace their copy.
+type MessageReaction struct {
+	Event     string                  `json:"event"`
+	ID        string                  `json:"id"`
+	RoomID    string                  `json:"roomId"`
+	UserID    string                  `json:"userId"`
+	Emoji     string                  `json:"emoji"`
+	Added     bool                    `json:"added"`
+	Reactions []message.ReactionCount `json:"reactions"`
+}
+
+// MessagePinned is broadcast to room clients when a message is pinned or unpinned.
+// PinnedAt is only set on pins.
+type MessagePinned struct {
+	Event    string     `json:"event"`
+	ID       string     `json:"id"`
+	RoomID   string     `json:"roomId"`
+	UserID   string     `json:"userId"`
+	Pinned   bool       `json:"pinned"`
+	PinnedAt *time.Time `json:"pinnedAt,omitempty"`
+}
+
+// UserMentioned is published when a new or edited message mentions users with @name.
+// It is delivered only to the users in UserIDs.
+type UserMentioned struct {
+	Event     string    `json:"event"`
+	MessageID string    `json:"messageId"`
+	RoomID    string    `json:"roomId"`
+	UserIDs   []string  `json:"userIds"`
+	ByUserID  string    `json:"byUserId"`
+	ByName    string    `json:"byUserName"`
+	Text      string    `json:"text"`
+	ParentID  string    `json:"parentId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// MessageRead is broadcast to room clients when a member's read position moves forward.
+type MessageRead struct {
+	Event     string    `json:"event"`
+	RoomID    string    `json:"roomId"`
+	UserID    string    `json:"userId"`
+	MessageID string    `json:"messageId"`
+	ReadAt    time.Time `json:"readAt"`
+}
+
+// UserTyping is a transient signal that a user is composing a message in a room.
+// It is never persisted and is delivered to every instance's room clients except the typist's.
+type UserTyping struct {
+	Event  string    `json:"event"`
+	RoomID string    `json:"roomId"`
+	UserID string    `json:"userId"`
+	At     time.Time `json:"at"`
+}
+
+// PresenceChanged is broadcast to room clients when a user's aggregated status in the room changes.
+type PresenceChanged struct {
+	Event  string    `json:"event"`
+	RoomID string    `json:"roomId"`
+	UserID string    `json:"userId"`
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+}
+
+// MemberRemoved is broadcast to room clients when a member leaves or is kicked. Each instance then
+// drops the removed user's subscriptions to the room.
+type MemberRemoved struct {
+	Event  string    `json:"event"`
+	RoomID string    `json:"roomId"`
+	UserID string    `json:"userId"`
+	At     time.Time `json:"at"`
 }
 
 type BotRequested struct {
@@ -33,9 +167,13 @@ type BotRequested struct {
 	RequestUserID string    `json:"requestUserId"`
 	MessageID     string    `json:"messageId"`
 	RequestedAt   time.Time `json:"requestedAt"`
+	// RequestID is the idempotency key of the submit that invoked the bot.
+	RequestID string `json:"requestId,omitempty"`
 }
 
 type BotResponseSubmit struct {
 	RoomID string `json:"roomId"`
 	Text   string `json:"text"`
+	// IdempotencyKey deduplicates redelivered responses; bots derive it from BotRequested.RequestID.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
 }
@@ -0,0 +1,322 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// maxRetries is how often a failed delivery is retried before it is dead-lettered.
+const maxRetries = 5
+
+// retryDelay is how long a failed delivery waits in its retry queue, in milliseconds.
+const retryDelay = 5000
+
+// prefetch bounds the unacknowledged deliveries a consumer holds at once.
+const prefetch = 16
+
+const (
+	// retryHeader counts the retries a delivery has been through.
+	retryHeader = "x-retry-count"
+	// errorHeader carries the error that sent a delivery to its dead-letter queue.
+	errorHeader = "x-last-error"
+)
+
+// ErrUnknownQueue is returned for a queue that has no dead-letter queue.
+var ErrUnknownQueue = errors.New("no dead-letter queue for this queue")
+
+// permanentError marks a failure that retrying cannot fix, such as a malformed payload.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// permanent wraps err so the delivery that caused it is dead-lettered without being retried.
+func permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// deliveryHandler processes one delivery. Returning an error sends the delivery for another
+// attempt, or straight to the dead-letter queue if the error is permanent.
+type deliveryHandler func(d amqp.Delivery) error
+
+// Every consumer queue name has a retry queue, where failed deliveries wait retryDelay before
+// returning to name, and a dead-letter exchange feeding a queue that parks deliveries for good.
+func retryQueue(name string) string         { return name + ".retry" }
+func deadLetterExchange(name string) string { return name + ".dlx" }
+func deadLetterQueue(name string) string    { return name + ".dead" }
+
+// DeadLetter is a delivery parked in a dead-letter queue.
+type DeadLetter struct {
+	RoutingKey string    `json:"routingKey"`
+	Retries    int       `json:"retries"`
+	Error      string    `json:"error,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	// Body is kept as text, since a dead letter may not be valid JSON.
+	Body string `json:"body"`
+}
+
+// declareDeadLetters declares the dead-letter exchange of queue name and the queue it feeds.
+func declareDeadLetters(ch *amqp.Channel, name string) error {
+	if err := ch.ExchangeDeclare(deadLetterExchange(name), "fanout", true, false, false, false, nil); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(deadLetterQueue(name), true, false, false, false, nil); err != nil {
+		return err
+	}
+	return ch.QueueBind(deadLetterQueue(name), "", deadLetterExchange(name), false, nil)
+}
+
+// consumeDurable declares the durable queue name, bound to keys, with its retry and dead-letter
+// queues, and passes its deliveries to handle. A delivery is acknowledged only once handled, so
+// one in flight when the process dies is delivered again. Messages left in legacy, the queue name
+// replaced when dead-lettering was added, are moved over in the background.
+func (a *AMQP) consumeDurable(ctx context.Context, name string, legacy string, keys []string, handle deliveryHandler) (err error) {
+	ch, err := a.Channel()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = ch.Close()
+		}
+	}()
+	if err := declareDeadLetters(ch, name); err != nil {
+		return err
+	}
+	// Retries expire from the retry queue back into name through the default exchange.
+	if _, err := ch.QueueDeclare(retryQueue(name), true, false, false, false, amqp.Table{
+		"x-message-ttl":             int32(retryDelay),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": name,
+	}); err != nil {
+		return err
+	}
+	args := amqp.Table{"x-dead-letter-exchange": deadLetterExchange(name)}
+	if _, err := ch.QueueDeclare(name, true, false, false, false, args); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := ch.QueueBind(name, key, a.exchange, false, nil); err != nil {
+			return err
+		}
+	}
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		return err
+	}
+	msgs, err := ch.Consume(name, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	go a.handleDeliveries(ctx, msgs, name, true, handle)
+	if legacy != "" {
+		go a.drainLegacyQueue(ctx, legacy, name, keys)
+	}
+	return nil
+}
+
+// drainLegacyQueue moves the messages of the queue legacy, which lacks dead-lettering and can
+// not be redeclared with it, into the queue name. The legacy queue is unbound first so it stops
+// collecting messages, and deleted once drained unless an older release still consumes from it.
+// Failures are logged and the drain is tried again on the next start; they never hold up the
+// consumer of name.
+func (a *AMQP) drainLegacyQueue(ctx context.Context, legacy string, name string, keys []string) {
+	ch, err := a.Channel()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	if _, err := ch.QueueDeclarePassive(legacy, true, false, false, false, nil); err != nil {
+		// Already gone; the failed passive declaration closed only this channel.
+		return
+	}
+	for _, key := range keys {
+		if err := ch.QueueUnbind(legacy, key, a.exchange, nil); err != nil {
+			log.Printf("amqp: failed to unbind legacy queue %s: %v", legacy, err)
+			return
+		}
+	}
+	moved := 0
+	for {
+		d, ok, err := ch.Get(legacy, false)
+		if err != nil {
+			log.Printf("amqp: failed to drain legacy queue %s: %v", legacy, err)
+			return
+		}
+		if !ok {
+			break
+		}
+		pub := amqp.Publishing{Headers: d.Headers, ContentType: d.ContentType, DeliveryMode: amqp.Persistent, MessageId: d.MessageId, Timestamp: d.Timestamp, Body: d.Body}
+		if err := a.publishConfirmed(ctx, "", name, pub); err != nil {
+			_ = d.Nack(false, true)
+			log.Printf("amqp: failed to move messages from legacy queue %s: %v", legacy, err)
+			return
+		}
+		_ = d.Ack(false)
+		moved++
+	}
+	if moved > 0 {
+		log.Printf("amqp: moved %d messages from legacy queue %s to %s", moved, legacy, name)
+	}
+	if _, err := ch.QueueDelete(legacy, true, false, false); err != nil {
+		log.Printf("amqp: legacy queue %s not removed: %v", legacy, err)
+	}
+}
+
+// handleDeliveries passes each delivery to handle and settles it by the outcome. Without retry,
+// every failure is dead-lettered at once.
+func (a *AMQP) handleDeliveries(ctx context.Context, msgs <-chan amqp.Delivery, name string, retry bool, handle deliveryHandler) {
+	for d := range msgs {
+		a.settle(ctx, name, retry, d, handle(d))
+	}
+}
+
+// settle acknowledges a handled delivery. A failed one is parked in the retry queue of name until
+// it has been retried maxRetries times, then forwarded to the dead-letter exchange with the error
+// recorded. If the broker does not confirm either, the delivery is requeued or, when it was due
+// for dead-lettering, rejected into the dead-letter exchange without the error.
+func (a *AMQP) settle(ctx context.Context, name string, retry bool, d amqp.Delivery, err error) {
+	if err == nil {
+		_ = d.Ack(false)
+		return
+	}
+	retries := retryCount(d)
+	var perm permanentError
+	if retry && !errors.As(err, &perm) && retries < maxRetries {
+		log.Printf("amqp: retrying delivery from %s (%d/%d): %v", name, retries+1, maxRetries, err)
+		if ferr := a.forward(ctx, "", retryQueue(name), d, retries+1, ""); ferr != nil {
+			log.Printf("amqp: failed to park delivery from %s for a retry: %v", name, ferr)
+			_ = d.Nack(false, true)
+			return
+		}
+		_ = d.Ack(false)
+		return
+	}
+	log.Printf("amqp: dead-lettering delivery from %s after %d retries: %v", name, retries, err)
+	if ferr := a.forward(ctx, deadLetterExchange(name), d.RoutingKey, d, retries, err.Error()); ferr != nil {
+		_ = d.Nack(false, false)
+		return
+	}
+	_ = d.Ack(false)
+}
+
+// forward publishes a copy of d with its retry count and, if not empty, the reason it failed.
+func (a *AMQP) forward(ctx context.Context, exchange string, routingKey string, d amqp.Delivery, retries int, reason string) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[retryHeader] = int32(retries)
+	if reason != "" {
+		headers[errorHeader] = reason
+	}
+	return a.publishConfirmed(ctx, exchange, routingKey, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    d.MessageId,
+		Timestamp:    d.Timestamp,
+		Body:         d.Body,
+	})
+}
+
+// retryCount reads the retry counter of d.
+func retryCount(d amqp.Delivery) int {
+	switch n := d.Headers[retryHeader].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
+
+// openDeadLetters opens a channel for the dead-letter queue of name and reports how many
+// messages it holds.
+func (a *AMQP) openDeadLetters(name string) (*amqp.Channel, int, error) {
+	ch, err := a.Channel()
+	if err != nil {
+		return nil, 0, err
+	}
+	q, err := ch.QueueDeclarePassive(deadLetterQueue(name), true, false, false, false, nil)
+	if err != nil {
+		var amqpErr *amqp.Error
+		if errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound {
+			return nil, 0, ErrUnknownQueue
+		}
+		return nil, 0, err
+	}
+	return ch, q.Messages, nil
+}
+
+// DeadLetters returns up to limit of the oldest dead letters of queue name, without removing
+// them, and how many the queue holds in total.
+func (a *AMQP) DeadLetters(ctx context.Context, name string, limit int) ([]DeadLetter, int, error) {
+	ch, total, err := a.openDeadLetters(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Closing the channel returns the unacknowledged messages to the queue in their original order.
+	defer ch.Close()
+	items := []DeadLetter{}
+	for len(items) < limit && ctx.Err() == nil {
+		d, ok, err := ch.Get(deadLetterQueue(name), false)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !ok {
+			break
+		}
+		reason, _ := d.Headers[errorHeader].(string)
+		items = append(items, DeadLetter{RoutingKey: d.RoutingKey, Retries: retryCount(d), Error: reason, Timestamp: d.Timestamp, Body: string(d.Body)})
+	}
+	return items, total, nil
+}
+
+// RequeueDeadLetters moves up to limit of the oldest dead letters of queue name back into it,
+// with their retry count reset, and returns how many were moved. Dead letters of the broadcast
+// queues are published to the exchange again, reaching every instance.
+func (a *AMQP) RequeueDeadLetters(ctx context.Context, name string, limit int) (int, error) {
+	ch, _, err := a.openDeadLetters(name)
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+	moved := 0
+	for moved < limit && ctx.Err() == nil {
+		d, ok, err := ch.Get(deadLetterQueue(name), false)
+		if err != nil {
+			return moved, err
+		}
+		if !ok {
+			break
+		}
+		exchange, key := "", name
+		if name == broadcastDeadLetters {
+			exchange, key = a.exchange, d.RoutingKey
+		}
+		headers := amqp.Table{}
+		for k, v := range d.Headers {
+			if k != retryHeader && k != errorHeader && !strings.HasPrefix(k, "x-death") &&
+				!strings.HasPrefix(k, "x-first-death") && !strings.HasPrefix(k, "x-last-death") {
+				headers[k] = v
+			}
+		}
+		pub := amqp.Publishing{Headers: headers, ContentType: d.ContentType, DeliveryMode: amqp.Persistent, MessageId: d.MessageId, Timestamp: d.Timestamp, Body: d.Body}
+		if err := a.publishConfirmed(ctx, exchange, key, pub); err != nil {
+			_ = d.Nack(false, true)
+			return moved, err
+		}
+		_ = d.Ack(false)
+		moved++
+	}
+	return moved, nil
+}
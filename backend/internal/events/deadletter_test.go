@@ -0,0 +1,104 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// recordingAcknowledger records how a delivery was settled.
+type recordingAcknowledger struct {
+	acked   bool
+	nacked  bool
+	requeue bool
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *recordingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacked, a.requeue = true, requeue
+	return nil
+}
+
+func (a *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestSettle(t *testing.T) {
+	// Without a connection nothing can be forwarded, so settle falls back to the broker.
+	disconnected := &AMQP{}
+	failure := errors.New("store unavailable")
+	cases := []struct {
+		name    string
+		retry   bool
+		retries int32
+		err     error
+		acked   bool
+		requeue bool
+	}{
+		{name: "handled", retry: true, err: nil, acked: true},
+		{name: "transient failure is requeued", retry: true, err: failure, requeue: true},
+		{name: "retries exhausted", retry: true, retries: maxRetries, err: failure},
+		{name: "permanent failure", retry: true, err: permanent(failure)},
+		{name: "no retries", retry: false, err: failure},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ack := &recordingAcknowledger{}
+			d := amqp.Delivery{Acknowledger: ack, Headers: amqp.Table{retryHeader: tc.retries}}
+			disconnected.settle(context.Background(), "chat.ingress", tc.retry, d, tc.err)
+			if ack.acked != tc.acked || ack.nacked == tc.acked || ack.requeue != tc.requeue {
+				t.Fatalf("expected acked=%v requeue=%v, got %+v", tc.acked, tc.requeue, ack)
+			}
+		})
+	}
+}
+
+func TestBroadcastConsumer_DeadLettersMalformedEvents(t *testing.T) {
+	hub := &mockBroadcaster{}
+	c := &BroadcastConsumer{Hub: hub}
+	var perm permanentError
+	if err := c.handle(amqp.Delivery{RoutingKey: RKMessageCreated, Body: []byte("{")}); !errors.As(err, &perm) {
+		t.Fatalf("expected a permanent error for invalid JSON, got %v", err)
+	}
+	if err := c.handle(amqp.Delivery{RoutingKey: RKMessageCreated, Body: []byte(`{"id":"1"}`)}); !errors.As(err, &perm) {
+		t.Fatalf("expected a permanent error without roomId, got %v", err)
+	}
+	if err := c.handle(amqp.Delivery{RoutingKey: RKMessageCreated, Body: []byte(`{"roomId":"room1"}`)}); err != nil || len(hub.broadcasts) != 1 {
+		t.Fatalf("expected the event to be broadcast, got %v", err)
+	}
+}
+
+func TestBroadcastConsumer_MemberRemovedDropsSubscriptions(t *testing.T) {
+	hub := &mockBroadcaster{}
+	c := &BroadcastConsumer{Hub: hub}
+	if err := c.handle(amqp.Delivery{RoutingKey: RKMemberRemoved, Body: []byte(`{"event":"member.removed","roomId":"room1","userId":"bob"}`)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hub.broadcasts) != 1 || hub.broadcasts[0].RoomID != "room1" {
+		t.Fatalf("expected the removal to be broadcast to room1, got %+v", hub.broadcasts)
+	}
+	if len(hub.removed) != 1 || hub.removed[0] != "room1/bob" {
+		t.Fatalf("expected bob's subscriptions to room1 to be dropped, got %v", hub.removed)
+	}
+}
+
+func TestBroadcastConsumer_MentionsReachOnlyMentionedUsers(t *testing.T) {
+	hub := &mockBroadcaster{}
+	c := &BroadcastConsumer{Hub: hub}
+	body := []byte(`{"event":"user.mentioned","roomId":"room1","userIds":["bob","carol"],"byUserId":"alice"}`)
+	if err := c.handle(amqp.Delivery{RoutingKey: RKUserMentioned, Body: body}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hub.broadcasts) != 0 {
+		t.Fatalf("expected no room-wide broadcast, got %+v", hub.broadcasts)
+	}
+	if len(hub.targeted) != 2 || hub.targeted[0] != "bob" || hub.targeted[1] != "carol" {
+		t.Fatalf("expected the mention to target bob and carol, got %v", hub.targeted)
+	}
+}
@@ -0,0 +1,127 @@
+package events
+
+import (
+	"chatapp/internal/user"
+	"context"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// maxMentions bounds how many distinct users a single message can notify.
+const maxMentions = 20
+
+// maxMentionWords bounds how many words a mentioned display name can span.
+const maxMentionWords = 3
+
+// parseMentions extracts the distinct @ tokens from text, in order of appearance. Each token is
+// given as the names it may stand for, longest first: "@Jane Doe, hi" may name "Jane Doe" or
+// "Jane". A name runs on across single spaces and ends at punctuation or another mention.
+// A token must start the text or follow whitespace, so e-mail addresses are not mentions.
+func parseMentions(text string) [][]string {
+	var mentions [][]string
+	seen := make(map[string]bool)
+	runes := []rune(text)
+	for i := 0; i < len(runes) && len(mentions) < maxMentions; i++ {
+		if runes[i] != '@' || (i > 0 && !unicode.IsSpace(runes[i-1])) {
+			continue
+		}
+		var words []string
+		for j := i + 1; len(words) < maxMentionWords; {
+			k := j
+			for k < len(runes) && isMentionRune(runes[k]) {
+				k++
+			}
+			word := string(runes[j:k])
+			name := strings.TrimRight(word, ".-")
+			if name == "" {
+				break
+			}
+			words = append(words, name)
+			if name != word || k+1 >= len(runes) || runes[k] != ' ' || !isMentionRune(runes[k+1]) {
+				break
+			}
+			j = k + 1
+		}
+		if len(words) == 0 {
+			continue
+		}
+		candidates := make([]string, 0, len(words))
+		for n := len(words); n > 0; n-- {
+			candidates = append(candidates, strings.Join(words[:n], " "))
+		}
+		key := strings.ToLower(candidates[0])
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		mentions = append(mentions, candidates)
+	}
+	return mentions
+}
+
+func isMentionRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-'
+}
+
+// MentionResolver maps the @mentions in a message to the IDs of the room members they name.
+// It implements message.MentionResolver.
+type MentionResolver struct {
+	Users user.Repository
+	Rooms MembershipChecker
+}
+
+// Resolve returns the IDs of the room members mentioned in text, in order of appearance. Each
+// mention names the users matching the longest name it may stand for. The author is never
+// mentioned, and lookup failures only drop the mentions.
+func (r *MentionResolver) Resolve(ctx context.Context, roomID string, authorID string, text string) []string {
+	mentions := parseMentions(text)
+	if len(mentions) == 0 || r.Users == nil {
+		return nil
+	}
+	var names []string
+	for _, candidates := range mentions {
+		names = append(names, candidates...)
+	}
+	users, err := r.Users.FindByNames(ctx, names)
+	if err != nil {
+		log.Printf("mentions: failed to resolve mentions: %v", err)
+		return nil
+	}
+	// Names are matched without regard to case, like the lookup.
+	byName := make(map[string][]string, len(users))
+	for _, u := range users {
+		key := strings.ToLower(u.Name)
+		byName[key] = append(byName[key], u.ID.Hex())
+	}
+	var ids []string
+	seen := make(map[string]bool)
+	for _, candidates := range mentions {
+		for _, name := range candidates {
+			matched, ok := byName[strings.ToLower(name)]
+			if !ok {
+				continue
+			}
+			for _, id := range matched {
+				if id == authorID || seen[id] {
+					continue
+				}
+				seen[id] = true
+				if r.Rooms != nil {
+					if ok, err := r.Rooms.IsMember(ctx, roomID, id); err != nil || !ok {
+						continue
+					}
+				}
+				ids = append(ids, id)
+			}
+			break
+		}
+	}
+	return ids
+}
+
+// resolveMentions resolves the mentions of a submit.
+func (c *IngressConsumer) resolveMentions(ctx context.Context, s SubmitMessage) []string {
+	r := &MentionResolver{Users: c.Users, Rooms: c.Rooms}
+	return r.Resolve(ctx, s.RoomID, s.UserID, s.Text)
+}
@@ -0,0 +1,108 @@
+package events
+
+import (
+	"chatapp/internal/message"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// MessageNotifier implements message.Notifier by publishing to the chat.events exchange.
+type MessageNotifier struct {
+	AMQP *AMQP
+}
+
+func (n *MessageNotifier) MessageUpdated(ctx context.Context, m *message.Message) error {
+	evt := MessageUpdated{Event: RKMessageUpdated, ID: m.ID.Hex(), RoomID: m.RoomID, UserID: m.UserID, UserName: m.UserName, Text: m.Text, Type: m.Type, CreatedAt: m.CreatedAt}
+	if m.EditedAt != nil {
+		evt.EditedAt = *m.EditedAt
+	}
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	return n.AMQP.PublishJSON(ctx, RKMessageUpdated, b)
+}
+
+func (n *MessageNotifier) MessageDeleted(ctx context.Context, m *message.Message) error {
+	evt := MessageDeleted{Event: RKMessageDeleted, ID: m.ID.Hex(), RoomID: m.RoomID, DeletedBy: m.DeletedBy}
+	if m.DeletedAt != nil {
+		evt.DeletedAt = *m.DeletedAt
+	}
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	return n.AMQP.PublishJSON(ctx, RKMessageDeleted, b)
+}
+
+func (n *MessageNotifier) ReactionChanged(ctx context.Context, m *message.Message, userID string, emoji string, added bool) error {
+	evt := MessageReaction{Event: RKMessageReaction, ID: m.ID.Hex(), RoomID: m.RoomID, UserID: userID, Emoji: emoji, Added: added, Reactions: m.ReactionCounts}
+	if evt.Reactions == nil {
+		evt.Reactions = []message.ReactionCount{}
+	}
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	return n.AMQP.PublishJSON(ctx, RKMessageReaction, b)
+}
+
+func (n *MessageNotifier) MessagePinned(ctx context.Context, m *message.Message, userID string, pinned bool) error {
+	evt := MessagePinned{Event: RKMessagePinned, ID: m.ID.Hex(), RoomID: m.RoomID, UserID: userID, Pinned: pinned, PinnedAt: m.PinnedAt}
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	return n.AMQP.PublishJSON(ctx, RKMessagePinned, b)
+}
+
+func (n *MessageNotifier) ReadPositionChanged(ctx context.Context, roomID string, userID string, msgID string) error {
+	evt := MessageRead{Event: RKMessageRead, RoomID: roomID, UserID: userID, MessageID: msgID, ReadAt: time.Now().UTC()}
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	return n.AMQP.PublishJSON(ctx, RKMessageRead, b)
+}
+
+func (n *MessageNotifier) UsersMentioned(ctx context.Context, m *message.Message, userIDs []string) error {
+	b, err := json.Marshal(newUserMentioned(m, userIDs))
+	if err != nil {
+		return err
+	}
+	return n.AMQP.PublishJSON(ctx, RKUserMentioned, b)
+}
+
+// newUserMentioned describes the mention of userIDs in m.
+func newUserMentioned(m *message.Message, userIDs []string) UserMentioned {
+	return UserMentioned{Event: RKUserMentioned, MessageID: m.ID.Hex(), RoomID: m.RoomID, UserIDs: userIDs, ByUserID: m.UserID, ByName: m.UserName, Text: m.Text, ParentID: m.ParentID, CreatedAt: m.CreatedAt}
+}
+
+// PresenceNotifier implements presence.Notifier. Presence changes are transient like typing events.
+type PresenceNotifier struct {
+	AMQP *AMQP
+}
+
+func (n *PresenceNotifier) PresenceChanged(ctx context.Context, roomID string, userID string, status string) error {
+	evt := PresenceChanged{Event: RKPresence, RoomID: roomID, UserID: userID, Status: status, At: time.Now().UTC()}
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	return n.AMQP.PublishTransientJSON(ctx, RKPresence, b)
+}
+
+// RoomNotifier implements chatroom.Notifier by publishing to the chat.events exchange.
+type RoomNotifier struct {
+	AMQP *AMQP
+}
+
+func (n *RoomNotifier) MemberRemoved(ctx context.Context, roomID string, userID string) error {
+	evt := MemberRemoved{Event: RKMemberRemoved, RoomID: roomID, UserID: userID, At: time.Now().UTC()}
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	return n.AMQP.PublishJSON(ctx, RKMemberRemoved, b)
+}
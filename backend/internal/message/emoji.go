@@ -0,0 +1,90 @@
+package message
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxEmojiBytes bounds the size of a reaction key (long enough for ZWJ sequences).
+const maxEmojiBytes = 64
+
+// emojiBase covers the code points that are emoji on their own: pictographs, symbols, dingbats,
+// flags and the handful of older symbols that render as emoji with a variation selector.
+var emojiBase = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00a9, Hi: 0x00ae, Stride: 5},
+		{Lo: 0x203c, Hi: 0x2049, Stride: 13},
+		{Lo: 0x2122, Hi: 0x2139, Stride: 23},
+		{Lo: 0x2194, Hi: 0x21aa, Stride: 1},
+		{Lo: 0x231a, Hi: 0x23ff, Stride: 1},
+		{Lo: 0x24c2, Hi: 0x24c2, Stride: 1},
+		{Lo: 0x25aa, Hi: 0x25fe, Stride: 1},
+		{Lo: 0x2600, Hi: 0x27bf, Stride: 1},
+		{Lo: 0x2934, Hi: 0x2935, Stride: 1},
+		{Lo: 0x2b05, Hi: 0x2b55, Stride: 1},
+		{Lo: 0x3030, Hi: 0x3030, Stride: 1},
+		{Lo: 0x303d, Hi: 0x303d, Stride: 1},
+		{Lo: 0x3297, Hi: 0x3299, Stride: 2},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f000, Hi: 0x1faff, Stride: 1},
+	},
+}
+
+// emojiModifiers may only follow a base emoji: variation selectors, skin tones and the tag
+// characters of subdivision flags.
+var emojiModifiers = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0xfe0e, Hi: 0xfe0f, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f3fb, Hi: 0x1f3ff, Stride: 1},
+		{Lo: 0xe0020, Hi: 0xe007f, Stride: 1},
+	},
+}
+
+const (
+	zwj    = '\u200d'
+	vs16   = '\ufe0f'
+	keycap = '\u20e3'
+)
+
+func isRegionalIndicator(r rune) bool { return r >= 0x1f1e6 && r <= 0x1f1ff }
+
+// isEmojiBase reports whether r can start an emoji, or continue one after a zero width joiner.
+func isEmojiBase(r rune) bool {
+	return unicode.Is(emojiBase, r) && !isRegionalIndicator(r) && !unicode.Is(emojiModifiers, r)
+}
+
+// validEmoji reports whether s is a single emoji or emoji sequence, such as 👍, 👍🏽, 🇩🇪,
+// 👩‍💻 or the keycap 1️⃣, and nothing else. Several emoji in a row are only accepted when they
+// are joined into one by zero width joiners.
+func validEmoji(s string) bool {
+	if s == "" || len(s) > maxEmojiBytes || !utf8.ValidString(s) {
+		return false
+	}
+	rs := []rune(s)
+	switch {
+	case isRegionalIndicator(rs[0]):
+		// A flag is exactly one pair of regional indicators.
+		return len(rs) == 2 && isRegionalIndicator(rs[1])
+	case strings.ContainsRune("0123456789#*", rs[0]):
+		return (len(rs) == 2 && rs[1] == keycap) || (len(rs) == 3 && rs[1] == vs16 && rs[2] == keycap)
+	}
+	for i := 0; ; i++ {
+		if i >= len(rs) || !isEmojiBase(rs[i]) {
+			return false
+		}
+		for i+1 < len(rs) && unicode.Is(emojiModifiers, rs[i+1]) {
+			i++
+		}
+		if i+1 == len(rs) {
+			return true
+		}
+		if rs[i+1] != zwj {
+			return false
+		}
+		i++
+	}
+}
@@ -0,0 +1,16 @@
+package message
+
+import "testing"
+
+func TestValidEmoji(t *testing.T) {
+	for _, s := range []string{"👍", "👍🏽", "❤️", "🇩🇪", "👩‍💻", "1️⃣", "🏴󠁧󠁢󠁳󠁣󠁴󠁿"} {
+		if !validEmoji(s) {
+			t.Errorf("expected %q to be a valid emoji", s)
+		}
+	}
+	for _, s := range []string{"", "a", "lol", "👍a", "1", "‍", "<script>", "👍 👍", "👍👎👍", "😀😀😀😀", "🇩🇪🇫🇷", "🇩", "🏽", "‍👍", "👍‍", "1⃣2"} {
+		if validEmoji(s) {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,19 +23,234 @@ func (h *Handler) RegisterRoutes(r *gin.Engine, jwtSecret string) {
 	group := r.Group(constants.APIv1 + "/rooms")
 	group.Use(auth.AuthMiddleware(jwtSecret))
 	group.GET(":id/messages", h.list)
+	group.PATCH(":id/messages/:msgId", h.edit)
+	group.DELETE(":id/messages/:msgId", h.delete)
+	group.GET(":id/messages/:msgId/revisions", h.revisions)
+	group.GET(":id/messages/:msgId/thread", h.thread)
+	group.PUT(":id/messages/:msgId/reactions/:emoji", h.addReaction)
+	group.DELETE(":id/messages/:msgId/reactions/:emoji", h.removeReaction)
+	group.PUT(":id/messages/:msgId/pin", h.pin)
+	group.DELETE(":id/messages/:msgId/pin", h.unpin)
+	group.GET(":id/pins", h.pins)
+	group.PUT(":id/read", h.markRead)
+
+	search := r.Group(constants.APIv1 + "/search")
+	search.Use(auth.AuthMiddleware(jwtSecret))
+	search.GET("messages", h.search)
+
+	me := r.Group(constants.APIv1 + "/me")
+	me.Use(auth.AuthMiddleware(jwtSecret))
+	me.GET("mentions", h.mentions)
 }
 
 func (h *Handler) list(c *gin.Context) {
+	uid := c.GetString("uid")
 	roomID := c.Param("id")
 	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 64)
 	cursor := c.Query("cursor")
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
 	defer cancel()
-	items, next, err := h.service.List(ctx, roomID, limit, cursor)
+	items, next, err := h.service.List(ctx, uid, roomID, limit, cursor)
 	if err != nil {
+		if err == ErrNotMember {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list messages"})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"items": items, "nextCursor": next})
 }
+
+func (h *Handler) mentions(c *gin.Context) {
+	uid := c.GetString("uid")
+	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 64)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	items, next, err := h.service.Mentions(ctx, uid, limit, c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list mentions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items, "nextCursor": next})
+}
+
+func (h *Handler) edit(c *gin.Context) {
+	uid := c.GetString("uid")
+	var req EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	m, err := h.service.Edit(ctx, uid, c.Param("id"), c.Param("msgId"), req.Text)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+func (h *Handler) revisions(c *gin.Context) {
+	uid := c.GetString("uid")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	items, err := h.service.Revisions(ctx, uid, c.Param("id"), c.Param("msgId"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *Handler) delete(c *gin.Context) {
+	uid := c.GetString("uid")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	if err := h.service.Delete(ctx, uid, c.Param("id"), c.Param("msgId")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) markRead(c *gin.Context) {
+	uid := c.GetString("uid")
+	var req MarkReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	if err := h.service.MarkRead(ctx, uid, c.Param("id"), req.MessageID); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) thread(c *gin.Context) {
+	uid := c.GetString("uid")
+	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 64)
+	cursor := c.Query("cursor")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	resp, err := h.service.Thread(ctx, uid, c.Param("id"), c.Param("msgId"), limit, cursor)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) addReaction(c *gin.Context) {
+	uid := c.GetString("uid")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	m, err := h.service.AddReaction(ctx, uid, c.Param("id"), c.Param("msgId"), c.Param("emoji"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+func (h *Handler) removeReaction(c *gin.Context) {
+	uid := c.GetString("uid")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	m, err := h.service.RemoveReaction(ctx, uid, c.Param("id"), c.Param("msgId"), c.Param("emoji"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+func (h *Handler) pin(c *gin.Context) {
+	uid := c.GetString("uid")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	m, err := h.service.Pin(ctx, uid, c.Param("id"), c.Param("msgId"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+func (h *Handler) unpin(c *gin.Context) {
+	uid := c.GetString("uid")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	m, err := h.service.Unpin(ctx, uid, c.Param("id"), c.Param("msgId"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+func (h *Handler) pins(c *gin.Context) {
+	uid := c.GetString("uid")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	items, err := h.service.Pins(ctx, uid, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *Handler) search(c *gin.Context) {
+	uid := c.GetString("uid")
+	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 64)
+	q := SearchQuery{
+		Text:   c.Query("q"),
+		RoomID: c.Query("roomId"),
+		UserID: c.Query("userId"),
+		Limit:  limit,
+		Cursor: c.Query("cursor"),
+	}
+	for key, dst := range map[string]**time.Time{"from": &q.From, "to": &q.To} {
+		v := c.Query(key)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + key + ", expected RFC3339"})
+			return
+		}
+		*dst = &t
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	resp, err := h.service.Search(ctx, uid, q)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// errorStatus maps service errors to HTTP status codes.
+func errorStatus(err error) int {
+	switch err {
+	case ErrNotMember, ErrForbidden, ErrEditWindowExpired:
+		return http.StatusForbidden
+	case ErrNotFound, ErrParentNotFound:
+		return http.StatusNotFound
+	case ErrEmptyMessage, ErrInvalidEmoji, ErrEmptyQuery:
+		return http.StatusBadRequest
+	case ErrConflict:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
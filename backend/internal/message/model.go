@@ -15,6 +15,105 @@ type Message struct {
 	Text      string             `bson:"text" json:"text"`
 	Type      string             `bson:"type" json:"type"`
 	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	EditedAt  *time.Time         `bson:"editedAt,omitempty" json:"editedAt,omitempty"`
+	// ParentID is set on thread replies; ReplyCount and LastReplyAt are maintained on the parent.
+	ParentID    string     `bson:"parentId,omitempty" json:"parentId,omitempty"`
+	ReplyCount  int        `bson:"replyCount,omitempty" json:"replyCount,omitempty"`
+	LastReplyAt *time.Time `bson:"lastReplyAt,omitempty" json:"lastReplyAt,omitempty"`
+	// DeletedAt and DeletedBy mark a tombstone: the document stays so cursors remain stable,
+	// but its text and revisions are erased.
+	DeletedAt *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy string     `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+	// PinnedAt and PinnedBy are set while the message is pinned to its room.
+	PinnedAt *time.Time `bson:"pinnedAt,omitempty" json:"pinnedAt,omitempty"`
+	PinnedBy string     `bson:"pinnedBy,omitempty" json:"pinnedBy,omitempty"`
+	// Revisions holds prior versions of the text, oldest first.
+	Revisions []Revision `bson:"revisions,omitempty" json:"-"`
+	// Reactions stores one entry per user and emoji; clients receive the aggregated ReactionCounts.
+	Reactions      []Reaction      `bson:"reactions,omitempty" json:"-"`
+	ReactionCounts []ReactionCount `bson:"-" json:"reactions,omitempty"`
+	// Mentions holds the IDs of users resolved from @name tokens when the message was posted.
+	Mentions []string `bson:"mentions,omitempty" json:"mentions,omitempty"`
+	// IdempotencyKey identifies the submission that produced the message; a key is stored at most once.
+	IdempotencyKey string `bson:"idempotencyKey,omitempty" json:"-"`
+	// PublishedAt is set once the broker has confirmed the message.created event for the message.
+	PublishedAt *time.Time `bson:"publishedAt,omitempty" json:"-"`
+}
+
+// Reaction is a single user's emoji reaction to a message.
+type Reaction struct {
+	Emoji  string `bson:"emoji" json:"emoji"`
+	UserID string `bson:"userId" json:"userId"`
+}
+
+// ReactionCount aggregates the reactions for one emoji, in order of first use.
+type ReactionCount struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	UserIDs []string `json:"userIds"`
+}
+
+// summarizeReactions fills ReactionCounts from the stored Reactions.
+func (m *Message) summarizeReactions() {
+	m.ReactionCounts = nil
+	index := make(map[string]int)
+	for _, r := range m.Reactions {
+		i, ok := index[r.Emoji]
+		if !ok {
+			i = len(m.ReactionCounts)
+			index[r.Emoji] = i
+			m.ReactionCounts = append(m.ReactionCounts, ReactionCount{Emoji: r.Emoji})
+		}
+		m.ReactionCounts[i].Count++
+		m.ReactionCounts[i].UserIDs = append(m.ReactionCounts[i].UserIDs, r.UserID)
+	}
+}
+
+// Revision is a previous version of an edited message.
+type Revision struct {
+	Text string `bson:"text" json:"text"`
+	// WrittenAt is when this version was originally posted or last edited.
+	WrittenAt time.Time `bson:"writtenAt" json:"writtenAt"`
+}
+
+// ThreadResponse wraps a thread parent with a page of its replies.
+type ThreadResponse struct {
+	Parent     Message   `json:"parent"`
+	Items      []Message `json:"items"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+}
+
+// SearchQuery filters a full-text message search. Empty fields are not applied.
+type SearchQuery struct {
+	Text   string
+	RoomID string
+	UserID string
+	From   *time.Time
+	To     *time.Time
+	Limit  int64
+	Cursor string
+}
+
+// SearchResult is a matching message with an HTML-escaped, <mark>-highlighted snippet.
+type SearchResult struct {
+	Message Message `json:"message"`
+	Snippet string  `json:"snippet"`
+}
+
+// SearchResponse wraps a page of search results.
+type SearchResponse struct {
+	Items      []SearchResult `json:"items"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// EditMessageRequest is the payload to edit a message.
+type EditMessageRequest struct {
+	Text string `json:"text" binding:"required,min=1,max=4000"`
+}
+
+// MarkReadRequest moves the caller's read position in a room to MessageID.
+type MarkReadRequest struct {
+	MessageID string `json:"messageId" binding:"required"`
 }
 
 // ListResponse wraps paginated messages.
@@ -2,6 +2,8 @@ package message
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,6 +14,21 @@ import (
 type Repository interface {
 	Insert(ctx context.Context, m *Message) error
 	ListByRoom(ctx context.Context, roomID string, limit int64, cursor string) ([]Message, string, error)
+	ListThread(ctx context.Context, parentID string, limit int64, cursor string) ([]Message, string, error)
+	ListAfter(ctx context.Context, roomID string, afterID primitive.ObjectID, limit int64) ([]Message, error)
+	Search(ctx context.Context, q SearchQuery, roomIDs []string) ([]Message, string, error)
+	ListMentions(ctx context.Context, userID string, roomIDs []string, limit int64, cursor string) ([]Message, string, error)
+	CountUnread(ctx context.Context, userID string, readPositions map[string]string) (map[string]int64, error)
+	IncrementReplies(ctx context.Context, parentID primitive.ObjectID, at time.Time) error
+	MarkPublished(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	FindByID(ctx context.Context, id string) (*Message, error)
+	FindByIdempotencyKey(ctx context.Context, key string) (*Message, error)
+	UpdateText(ctx context.Context, m *Message, text string, mentions []string, editedAt time.Time) (bool, error)
+	SoftDelete(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) (bool, error)
+	SetPinned(ctx context.Context, id primitive.ObjectID, pinnedBy string, pinnedAt *time.Time) (bool, error)
+	ListPinned(ctx context.Context, roomID string) ([]Message, error)
+	AddReaction(ctx context.Context, id primitive.ObjectID, r Reaction) (*Message, bool, error)
+	RemoveReaction(ctx context.Context, id primitive.ObjectID, r Reaction) (*Message, bool, error)
 }
 
 type mongoRepository struct {
@@ -22,8 +39,12 @@ func NewRepository(db *mongo.Database) Repository {
 	return &mongoRepository{col: db.Collection("messages")}
 }
 
+// Insert stores m, returning ErrDuplicate if its idempotency key has been stored before.
 func (r *mongoRepository) Insert(ctx context.Context, m *Message) error {
 	res, err := r.col.InsertOne(ctx, m)
+	if m.IdempotencyKey != "" && mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
 	if err != nil {
 		return err
 	}
@@ -33,13 +54,115 @@ func (r *mongoRepository) Insert(ctx context.Context, m *Message) error {
 	return nil
 }
 
-// ListByRoom returns messages newest-first with a simple opaque cursor based on ObjectID.
+// ListByRoom returns top-level messages newest-first with a simple opaque cursor based on ObjectID.
+// Thread replies are only returned by ListThread.
 func (r *mongoRepository) ListByRoom(ctx context.Context, roomID string, limit int64, cursor string) ([]Message, string, error) {
+	return r.list(ctx, bson.M{"roomId": roomID, "parentId": bson.M{"$exists": false}}, limit, cursor)
+}
+
+// ListThread returns replies to a message newest-first, using the same cursor scheme as ListByRoom.
+func (r *mongoRepository) ListThread(ctx context.Context, parentID string, limit int64, cursor string) ([]Message, string, error) {
+	return r.list(ctx, bson.M{"parentId": parentID}, limit, cursor)
+}
+
+// ListAfter returns every message in a room (thread replies and tombstones included) posted
+// after afterID, oldest-first. It is the forward counterpart of ListByRoom, used to replay what a
+// reconnecting client missed.
+func (r *mongoRepository) ListAfter(ctx context.Context, roomID string, afterID primitive.ObjectID, limit int64) ([]Message, error) {
+	filter := bson.M{"roomId": roomID, "_id": bson.M{"$gt": afterID}}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+	cur, err := r.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var items []Message
+	if err := cur.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Search runs a $text query over the given rooms, newest-first with the ListByRoom cursor scheme.
+// Tombstoned messages are excluded.
+func (r *mongoRepository) Search(ctx context.Context, q SearchQuery, roomIDs []string) ([]Message, string, error) {
+	filter := bson.M{
+		"$text":     bson.M{"$search": q.Text},
+		"roomId":    bson.M{"$in": roomIDs},
+		"deletedAt": bson.M{"$exists": false},
+	}
+	if q.UserID != "" {
+		filter["userId"] = q.UserID
+	}
+	if q.From != nil || q.To != nil {
+		created := bson.M{}
+		if q.From != nil {
+			created["$gte"] = *q.From
+		}
+		if q.To != nil {
+			created["$lte"] = *q.To
+		}
+		filter["createdAt"] = created
+	}
+	return r.list(ctx, filter, q.Limit, q.Cursor)
+}
+
+// ListMentions returns messages in the given rooms that mention userID, newest-first.
+func (r *mongoRepository) ListMentions(ctx context.Context, userID string, roomIDs []string, limit int64, cursor string) ([]Message, string, error) {
+	filter := bson.M{
+		"mentions":  userID,
+		"roomId":    bson.M{"$in": roomIDs},
+		"deletedAt": bson.M{"$exists": false},
+	}
+	return r.list(ctx, filter, limit, cursor)
+}
+
+// CountUnread counts, in one aggregation, the top-level messages posted by anyone but userID in
+// each room of readPositions after the read position given for it. Rooms without unread messages
+// are left out. It satisfies chatroom.UnreadCounter.
+func (r *mongoRepository) CountUnread(ctx context.Context, userID string, readPositions map[string]string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	if len(readPositions) == 0 {
+		return counts, nil
+	}
+	rooms := make(bson.A, 0, len(readPositions))
+	for roomID, afterID := range readPositions {
+		room := bson.M{"roomId": roomID}
+		if oid, err := primitive.ObjectIDFromHex(afterID); err == nil {
+			room["_id"] = bson.M{"$gt": oid}
+		}
+		rooms = append(rooms, room)
+	}
+	cur, err := r.col.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"$or":       rooms,
+			"userId":    bson.M{"$ne": userID},
+			"parentId":  bson.M{"$exists": false},
+			"deletedAt": bson.M{"$exists": false},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$roomId", "n": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var row struct {
+			RoomID string `bson:"_id"`
+			N      int64  `bson:"n"`
+		}
+		if err := cur.Decode(&row); err != nil {
+			return nil, err
+		}
+		counts[row.RoomID] = row.N
+	}
+	return counts, cur.Err()
+}
+
+func (r *mongoRepository) list(ctx context.Context, filter bson.M, limit int64, cursor string) ([]Message, string, error) {
 	if limit <= 0 || limit > 100 {
 		limit = 20
 	}
 
-	filter := bson.M{"roomId": roomID}
 	if cursor != "" {
 		if oid, err := primitive.ObjectIDFromHex(cursor); err == nil {
 			filter["_id"] = bson.M{"$lt": oid}
@@ -73,3 +196,139 @@ func (r *mongoRepository) ListByRoom(ctx context.Context, roomID string, limit i
 	}
 	return items, next, nil
 }
+
+// IncrementReplies bumps the reply counter on a thread parent.
+func (r *mongoRepository) IncrementReplies(ctx context.Context, parentID primitive.ObjectID, at time.Time) error {
+	update := bson.M{"$inc": bson.M{"replyCount": 1}, "$max": bson.M{"lastReplyAt": at}}
+	_, err := r.col.UpdateByID(ctx, parentID, update)
+	return err
+}
+
+// MarkPublished records that the message.created event for id was confirmed by the broker.
+func (r *mongoRepository) MarkPublished(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := r.col.UpdateByID(ctx, id, bson.M{"$set": bson.M{"publishedAt": at}})
+	return err
+}
+
+// FindByID returns mongo.ErrNoDocuments for an ID that is not an ObjectID, since none can match.
+func (r *mongoRepository) FindByID(ctx context.Context, id string) (*Message, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	var m Message
+	if err := r.col.FindOne(ctx, bson.M{"_id": oid}).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *mongoRepository) FindByIdempotencyKey(ctx context.Context, key string) (*Message, error) {
+	var m Message
+	if err := r.col.FindOne(ctx, bson.M{"idempotencyKey": key}).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// UpdateText replaces the text and mentions of m and archives the current version as a revision.
+// The update only applies if the stored text still matches m.Text, so concurrent edits
+// cannot drop a revision.
+func (r *mongoRepository) UpdateText(ctx context.Context, m *Message, text string, mentions []string, editedAt time.Time) (bool, error) {
+	writtenAt := m.CreatedAt
+	if m.EditedAt != nil {
+		writtenAt = *m.EditedAt
+	}
+	filter := bson.M{"_id": m.ID, "text": m.Text, "deletedAt": bson.M{"$exists": false}}
+	update := bson.M{
+		"$set":  bson.M{"text": text, "editedAt": editedAt},
+		"$push": bson.M{"revisions": Revision{Text: m.Text, WrittenAt: writtenAt}},
+	}
+	// Like on insert, a message that mentions nobody has no mentions field.
+	if len(mentions) > 0 {
+		update["$set"].(bson.M)["mentions"] = mentions
+	} else {
+		update["$unset"] = bson.M{"mentions": ""}
+	}
+	res, err := r.col.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// SoftDelete turns a message into a tombstone, erasing its text and revisions.
+func (r *mongoRepository) SoftDelete(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) (bool, error) {
+	filter := bson.M{"_id": id, "deletedAt": bson.M{"$exists": false}}
+	update := bson.M{
+		"$set":   bson.M{"text": "", "deletedAt": deletedAt, "deletedBy": deletedBy},
+		"$unset": bson.M{"revisions": "", "reactions": "", "pinnedAt": "", "pinnedBy": ""},
+	}
+	res, err := r.col.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// SetPinned pins a message when pinnedAt is set and unpins it otherwise, reporting whether the
+// message changed. Tombstones cannot be pinned.
+func (r *mongoRepository) SetPinned(ctx context.Context, id primitive.ObjectID, pinnedBy string, pinnedAt *time.Time) (bool, error) {
+	filter := bson.M{"_id": id, "deletedAt": bson.M{"$exists": false}, "pinnedAt": bson.M{"$exists": pinnedAt == nil}}
+	update := bson.M{"$unset": bson.M{"pinnedAt": "", "pinnedBy": ""}}
+	if pinnedAt != nil {
+		update = bson.M{"$set": bson.M{"pinnedAt": *pinnedAt, "pinnedBy": pinnedBy}}
+	}
+	res, err := r.col.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// ListPinned returns the messages pinned in a room, most recently pinned first.
+func (r *mongoRepository) ListPinned(ctx context.Context, roomID string) ([]Message, error) {
+	filter := bson.M{"roomId": roomID, "pinnedAt": bson.M{"$exists": true}}
+	opts := options.Find().SetSort(bson.D{{Key: "pinnedAt", Value: -1}})
+	cur, err := r.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var items []Message
+	if err := cur.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// AddReaction records a reaction once per user and emoji and returns the message, reporting
+// whether the reaction was new.
+func (r *mongoRepository) AddReaction(ctx context.Context, id primitive.ObjectID, re Reaction) (*Message, bool, error) {
+	return r.updateReactions(ctx, id, bson.M{"$ne": re}, bson.M{"$addToSet": bson.M{"reactions": re}})
+}
+
+// RemoveReaction removes a reaction and returns the message, reporting whether it was there.
+func (r *mongoRepository) RemoveReaction(ctx context.Context, id primitive.ObjectID, re Reaction) (*Message, bool, error) {
+	return r.updateReactions(ctx, id, re, bson.M{"$pull": bson.M{"reactions": re}})
+}
+
+// updateReactions applies update if the message's reactions match cond and returns the message
+// as it is afterwards, with whether it changed.
+func (r *mongoRepository) updateReactions(ctx context.Context, id primitive.ObjectID, cond interface{}, update bson.M) (*Message, bool, error) {
+	filter := bson.M{"_id": id, "deletedAt": bson.M{"$exists": false}, "reactions": cond}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var m Message
+	err := r.col.FindOneAndUpdate(ctx, filter, update, opts).Decode(&m)
+	if err == nil {
+		return &m, true, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, false, err
+	}
+	// Nothing to change, or no such message; tell the two apart.
+	delete(filter, "reactions")
+	if err := r.col.FindOne(ctx, filter).Decode(&m); err != nil {
+		return nil, false, err
+	}
+	return &m, false, nil
+}
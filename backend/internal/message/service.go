@@ -1,69 +1,594 @@
 package message
 
 import (
+	"chatapp/internal/chatroom"
 	"context"
 	"errors"
+	"log"
+	"slices"
 	"strings"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// EditWindow is how long after posting an author may still edit a message.
+const EditWindow = 15 * time.Minute
+
+var ErrNotMember = errors.New("not a member of this chatroom")
+var ErrForbidden = errors.New("forbidden")
+var ErrNotFound = errors.New("message not found")
+var ErrEmptyMessage = errors.New("empty message")
+var ErrEditWindowExpired = errors.New("message can no longer be edited")
+var ErrConflict = errors.New("message was modified concurrently")
+var ErrParentNotFound = errors.New("parent message not found")
+var ErrInvalidEmoji = errors.New("invalid emoji")
+var ErrEmptyQuery = errors.New("search query is required")
+var ErrInvalidCursor = errors.New("invalid message id")
+
+// ErrDuplicate is returned with the originally stored message when a create repeats an idempotency key.
+var ErrDuplicate = errors.New("duplicate message")
+
 type ChatRoomReader interface {
-	// Minimal method used for membership/room existence checks.
-	FindByID(ctx context.Context, id string) (bool, error)
+	// Minimal methods used for membership and moderation checks.
+	IsMember(ctx context.Context, roomID string, userID string) (bool, error)
+	Authorize(ctx context.Context, roomID string, userID string, perm chatroom.Permission) error
+	MemberRoomIDs(ctx context.Context, userID string) ([]string, error)
+	MarkRead(ctx context.Context, roomID string, userID string, msgID string) (bool, error)
+	Touch(ctx context.Context, roomID string, at time.Time) error
+}
+
+// Notifier publishes message lifecycle events so connected clients can update live.
+type Notifier interface {
+	MessageUpdated(ctx context.Context, m *Message) error
+	MessageDeleted(ctx context.Context, m *Message) error
+	ReactionChanged(ctx context.Context, m *Message, userID string, emoji string, added bool) error
+	MessagePinned(ctx context.Context, m *Message, userID string, pinned bool) error
+	ReadPositionChanged(ctx context.Context, roomID string, userID string, msgID string) error
+	// UsersMentioned notifies users who an edit newly mentions.
+	UsersMentioned(ctx context.Context, m *Message, userIDs []string) error
+}
+
+// MentionResolver maps the @mentions in a message's text to the IDs of the room members they name.
+type MentionResolver interface {
+	Resolve(ctx context.Context, roomID string, authorID string, text string) []string
 }
 
 type Service interface {
-	CreateWithName(ctx context.Context, userID string, userName string, roomID string, text string) (*Message, error)
-	CreateBotMessage(ctx context.Context, roomID string, text string) (*Message, error)
-	List(ctx context.Context, roomID string, limit int64, cursor string) ([]Message, string, error)
+	CreateWithName(ctx context.Context, userID string, userName string, roomID string, text string, mentions []string, key string) (*Message, error)
+	CreateReply(ctx context.Context, userID string, userName string, roomID string, parentID string, text string, mentions []string, key string) (*Message, error)
+	CreateBotMessage(ctx context.Context, roomID string, text string, key string) (*Message, error)
+	MarkPublished(ctx context.Context, m *Message) error
+	List(ctx context.Context, userID string, roomID string, limit int64, cursor string) ([]Message, string, error)
+	Since(ctx context.Context, userID string, roomID string, afterID string, limit int64) ([]Message, error)
+	Edit(ctx context.Context, userID string, roomID string, msgID string, text string) (*Message, error)
+	Revisions(ctx context.Context, userID string, roomID string, msgID string) ([]Revision, error)
+	Thread(ctx context.Context, userID string, roomID string, msgID string, limit int64, cursor string) (*ThreadResponse, error)
+	Delete(ctx context.Context, userID string, roomID string, msgID string) error
+	AddReaction(ctx context.Context, userID string, roomID string, msgID string, emoji string) (*Message, error)
+	RemoveReaction(ctx context.Context, userID string, roomID string, msgID string, emoji string) (*Message, error)
+	Pin(ctx context.Context, userID string, roomID string, msgID string) (*Message, error)
+	Unpin(ctx context.Context, userID string, roomID string, msgID string) (*Message, error)
+	Pins(ctx context.Context, userID string, roomID string) ([]Message, error)
+	Search(ctx context.Context, userID string, q SearchQuery) (*SearchResponse, error)
+	Mentions(ctx context.Context, userID string, limit int64, cursor string) ([]Message, string, error)
+	MarkRead(ctx context.Context, userID string, roomID string, msgID string) error
 }
 
 type service struct {
-	repo Repository
+	repo     Repository
+	rooms    ChatRoomReader
+	notifier Notifier
+	mentions MentionResolver
 }
 
-func NewService(r Repository) Service {
-	return &service{repo: r}
+func NewService(r Repository, rooms ChatRoomReader, n Notifier, mr MentionResolver) Service {
+	return &service{repo: r, rooms: rooms, notifier: n, mentions: mr}
 }
 
-func (s *service) CreateWithName(ctx context.Context, userID string, userName string, roomID string, text string) (*Message, error) {
+// CreateWithName persists a top-level message. A non-empty key makes the call idempotent: repeating
+// it returns the message stored first along with ErrDuplicate.
+func (s *service) CreateWithName(ctx context.Context, userID string, userName string, roomID string, text string, mentions []string, key string) (*Message, error) {
 	t := strings.TrimSpace(text)
 	if t == "" {
-		return nil, errors.New("empty message")
+		return nil, ErrEmptyMessage
 	}
 	m := &Message{
-		RoomID:    roomID,
-		UserID:    userID,
-		UserName:  userName,
-		Text:      t,
-		Type:      "user",
-		CreatedAt: time.Now().UTC(),
+		RoomID:         roomID,
+		UserID:         userID,
+		UserName:       userName,
+		Text:           t,
+		Type:           "user",
+		CreatedAt:      time.Now().UTC(),
+		Mentions:       mentions,
+		IdempotencyKey: key,
 	}
-	if err := s.repo.Insert(ctx, m); err != nil {
-		return nil, err
+	return s.insert(ctx, m)
+}
+
+// CreateReply persists a reply in the thread of parentID. Replies to a reply are attached
+// to the root of that thread so threads stay one level deep. key works as in CreateWithName.
+func (s *service) CreateReply(ctx context.Context, userID string, userName string, roomID string, parentID string, text string, mentions []string, key string) (*Message, error) {
+	t := strings.TrimSpace(text)
+	if t == "" {
+		return nil, ErrEmptyMessage
+	}
+	parent, err := s.repo.FindByID(ctx, parentID)
+	if err == nil && parent.ParentID != "" {
+		parent, err = s.repo.FindByID(ctx, parent.ParentID)
+	}
+	if err != nil || parent.RoomID != roomID || parent.DeletedAt != nil {
+		return nil, ErrParentNotFound
+	}
+	m := &Message{
+		RoomID:         roomID,
+		UserID:         userID,
+		UserName:       userName,
+		Text:           t,
+		Type:           "user",
+		CreatedAt:      time.Now().UTC(),
+		ParentID:       parent.ID.Hex(),
+		Mentions:       mentions,
+		IdempotencyKey: key,
+	}
+	m, err = s.insert(ctx, m)
+	if err != nil {
+		return m, err
+	}
+	if err := s.repo.IncrementReplies(ctx, parent.ID, m.CreatedAt); err != nil {
+		log.Printf("message: failed to update reply count for %s: %v", parent.ID.Hex(), err)
 	}
 	return m, nil
 }
 
-func (s *service) CreateBotMessage(ctx context.Context, roomID string, text string) (*Message, error) {
+func (s *service) CreateBotMessage(ctx context.Context, roomID string, text string, key string) (*Message, error) {
 	t := strings.TrimSpace(text)
 	if t == "" {
-		return nil, errors.New("empty message")
+		return nil, ErrEmptyMessage
 	}
 	m := &Message{
-		RoomID:    roomID,
-		UserID:    "",
-		UserName:  "",
-		Text:      t,
-		Type:      "bot",
-		CreatedAt: time.Now().UTC(),
+		RoomID:         roomID,
+		UserID:         "",
+		UserName:       "",
+		Text:           t,
+		Type:           "bot",
+		CreatedAt:      time.Now().UTC(),
+		IdempotencyKey: key,
+	}
+	return s.insert(ctx, m)
+}
+
+// insert stores m unless its idempotency key was stored before, in which case the earlier
+// message is returned with ErrDuplicate.
+func (s *service) insert(ctx context.Context, m *Message) (*Message, error) {
+	err := s.repo.Insert(ctx, m)
+	if errors.Is(err, ErrDuplicate) {
+		existing, ferr := s.repo.FindByIdempotencyKey(ctx, m.IdempotencyKey)
+		if ferr != nil {
+			return nil, ferr
+		}
+		return existing, ErrDuplicate
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.rooms.Touch(ctx, m.RoomID, m.CreatedAt); err != nil {
+		log.Printf("message: failed to record activity in room %s: %v", m.RoomID, err)
+	}
+	return m, nil
+}
+
+// MarkPublished records that the message.created event for m has been confirmed by the broker, so
+// a repeated submission of m's idempotency key does not announce it again.
+func (s *service) MarkPublished(ctx context.Context, m *Message) error {
+	at := time.Now().UTC()
+	if err := s.repo.MarkPublished(ctx, m.ID, at); err != nil {
+		return err
+	}
+	m.PublishedAt = &at
+	return nil
+}
+
+func (s *service) List(ctx context.Context, userID string, roomID string, limit int64, cursor string) ([]Message, string, error) {
+	if err := s.requireMember(ctx, roomID, userID); err != nil {
+		return nil, "", err
+	}
+	items, next, err := s.repo.ListByRoom(ctx, roomID, limit, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	summarizeAll(items)
+	return items, next, nil
+}
+
+// Since returns up to limit messages of a room posted after afterID, oldest-first, including
+// thread replies and tombstones so a reconnecting client sees the same stream it would have live.
+func (s *service) Since(ctx context.Context, userID string, roomID string, afterID string, limit int64) ([]Message, error) {
+	oid, err := primitive.ObjectIDFromHex(afterID)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if err := s.requireMember(ctx, roomID, userID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 100
+	}
+	items, err := s.repo.ListAfter(ctx, roomID, oid, limit)
+	if err != nil {
+		return nil, err
+	}
+	summarizeAll(items)
+	return items, nil
+}
+
+// Edit replaces the text of the caller's own message within EditWindow and notifies clients.
+// Mentions are resolved again from the new text; only users it newly mentions are notified.
+func (s *service) Edit(ctx context.Context, userID string, roomID string, msgID string, text string) (*Message, error) {
+	t := strings.TrimSpace(text)
+	if t == "" {
+		return nil, ErrEmptyMessage
+	}
+	m, err := s.findInRoom(ctx, userID, roomID, msgID)
+	if err != nil {
+		return nil, err
+	}
+	if m.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	if m.Type != "user" || m.UserID != userID {
+		return nil, ErrForbidden
+	}
+	now := time.Now().UTC()
+	if now.Sub(m.CreatedAt) > EditWindow {
+		return nil, ErrEditWindowExpired
+	}
+	if t == m.Text {
+		return m, nil
+	}
+	mentions := m.Mentions
+	if s.mentions != nil {
+		mentions = s.mentions.Resolve(ctx, roomID, userID, t)
+	}
+	ok, err := s.repo.UpdateText(ctx, m, t, mentions, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrConflict
+	}
+	writtenAt := m.CreatedAt
+	if m.EditedAt != nil {
+		writtenAt = *m.EditedAt
+	}
+	m.Revisions = append(m.Revisions, Revision{Text: m.Text, WrittenAt: writtenAt})
+	added := newMentions(m.Mentions, mentions)
+	m.Text = t
+	m.EditedAt = &now
+	m.Mentions = mentions
+	if s.notifier != nil {
+		if err := s.notifier.MessageUpdated(ctx, m); err != nil {
+			log.Printf("message: failed to publish update for %s: %v", m.ID.Hex(), err)
+		}
+		if len(added) > 0 {
+			if err := s.notifier.UsersMentioned(ctx, m, added); err != nil {
+				log.Printf("message: failed to publish mentions for %s: %v", m.ID.Hex(), err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// newMentions returns the IDs in next that are not in prev.
+func newMentions(prev []string, next []string) []string {
+	var added []string
+	for _, id := range next {
+		if !slices.Contains(prev, id) {
+			added = append(added, id)
+		}
+	}
+	return added
+}
+
+// Revisions returns the prior versions of a message, oldest first. Only the author and members
+// with the room's delete-messages permission may see them.
+func (s *service) Revisions(ctx context.Context, userID string, roomID string, msgID string) ([]Revision, error) {
+	m, err := s.findInRoom(ctx, userID, roomID, msgID)
+	if err != nil {
+		return nil, err
+	}
+	if m.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	if m.Type != "user" || m.UserID != userID {
+		if err := s.authorizeModeration(ctx, roomID, userID, chatroom.PermDeleteMessages); err != nil {
+			return nil, err
+		}
+	}
+	if m.Revisions == nil {
+		return []Revision{}, nil
+	}
+	return m.Revisions, nil
+}
+
+// Delete tombstones a message. Authors may delete their own messages; anyone else needs
+// the room's delete-messages permission.
+func (s *service) Delete(ctx context.Context, userID string, roomID string, msgID string) error {
+	m, err := s.findInRoom(ctx, userID, roomID, msgID)
+	if err != nil {
+		return err
+	}
+	if m.DeletedAt != nil {
+		return nil
+	}
+	if m.Type != "user" || m.UserID != userID {
+		if err := s.authorizeModeration(ctx, roomID, userID, chatroom.PermDeleteMessages); err != nil {
+			return err
+		}
+	}
+	now := time.Now().UTC()
+	ok, err := s.repo.SoftDelete(ctx, m.ID, userID, now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// Already tombstoned by a concurrent request.
+		return nil
+	}
+	m.Text = ""
+	m.Revisions = nil
+	m.DeletedAt = &now
+	m.DeletedBy = userID
+	if s.notifier != nil {
+		if err := s.notifier.MessageDeleted(ctx, m); err != nil {
+			log.Printf("message: failed to publish delete for %s: %v", m.ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// authorizeModeration checks that the user holds a moderation permission in the room.
+// Lacking the permission, or asking in a direct message where nobody moderates, is ErrForbidden;
+// failures of the check itself are passed through.
+func (s *service) authorizeModeration(ctx context.Context, roomID string, userID string, perm chatroom.Permission) error {
+	err := s.rooms.Authorize(ctx, roomID, userID, perm)
+	if errors.Is(err, chatroom.ErrForbidden) || errors.Is(err, chatroom.ErrDirectMessage) {
+		return ErrForbidden
+	}
+	return err
+}
+
+// Thread returns a message together with a page of its replies.
+func (s *service) Thread(ctx context.Context, userID string, roomID string, msgID string, limit int64, cursor string) (*ThreadResponse, error) {
+	parent, err := s.findInRoom(ctx, userID, roomID, msgID)
+	if err != nil {
+		return nil, err
+	}
+	items, next, err := s.repo.ListThread(ctx, parent.ID.Hex(), limit, cursor)
+	if err != nil {
+		return nil, err
+	}
+	if items == nil {
+		items = []Message{}
+	}
+	parent.summarizeReactions()
+	summarizeAll(items)
+	return &ThreadResponse{Parent: *parent, Items: items, NextCursor: next}, nil
+}
+
+func (s *service) AddReaction(ctx context.Context, userID string, roomID string, msgID string, emoji string) (*Message, error) {
+	return s.react(ctx, userID, roomID, msgID, emoji, true)
+}
+
+func (s *service) RemoveReaction(ctx context.Context, userID string, roomID string, msgID string, emoji string) (*Message, error) {
+	return s.react(ctx, userID, roomID, msgID, emoji, false)
+}
+
+func (s *service) react(ctx context.Context, userID string, roomID string, msgID string, emoji string, add bool) (*Message, error) {
+	emoji = strings.TrimSpace(emoji)
+	if !validEmoji(emoji) {
+		return nil, ErrInvalidEmoji
 	}
-	if err := s.repo.Insert(ctx, m); err != nil {
+	m, err := s.findInRoom(ctx, userID, roomID, msgID)
+	if err != nil {
 		return nil, err
 	}
+	if m.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	r := Reaction{Emoji: emoji, UserID: userID}
+	var updated *Message
+	var changed bool
+	if add {
+		updated, changed, err = s.repo.AddReaction(ctx, m.ID, r)
+	} else {
+		updated, changed, err = s.repo.RemoveReaction(ctx, m.ID, r)
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Deleted since it was looked up.
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	updated.summarizeReactions()
+	// Repeating a reaction, or removing one that is not there, changes nothing worth announcing.
+	if changed && s.notifier != nil {
+		if err := s.notifier.ReactionChanged(ctx, updated, userID, emoji, add); err != nil {
+			log.Printf("message: failed to publish reaction for %s: %v", updated.ID.Hex(), err)
+		}
+	}
+	return updated, nil
+}
+
+// Pin pins a message to its room. It requires the room's pin permission.
+func (s *service) Pin(ctx context.Context, userID string, roomID string, msgID string) (*Message, error) {
+	return s.setPinned(ctx, userID, roomID, msgID, true)
+}
+
+// Unpin takes a message off its room's pins. It requires the room's pin permission.
+func (s *service) Unpin(ctx context.Context, userID string, roomID string, msgID string) (*Message, error) {
+	return s.setPinned(ctx, userID, roomID, msgID, false)
+}
+
+func (s *service) setPinned(ctx context.Context, userID string, roomID string, msgID string, pin bool) (*Message, error) {
+	m, err := s.findInRoom(ctx, userID, roomID, msgID)
+	if err != nil {
+		return nil, err
+	}
+	if m.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	if err := s.authorizeModeration(ctx, roomID, userID, chatroom.PermPin); err != nil {
+		return nil, err
+	}
+	var at *time.Time
+	if pin {
+		now := time.Now().UTC()
+		at = &now
+	}
+	changed, err := s.repo.SetPinned(ctx, m.ID, userID, at)
+	if err != nil {
+		return nil, err
+	}
+	m.summarizeReactions()
+	// Pinning a pinned message, or unpinning one that is not pinned, changes nothing.
+	if !changed {
+		return m, nil
+	}
+	m.PinnedAt = at
+	m.PinnedBy = ""
+	if pin {
+		m.PinnedBy = userID
+	}
+	if s.notifier != nil {
+		if err := s.notifier.MessagePinned(ctx, m, userID, pin); err != nil {
+			log.Printf("message: failed to publish pin for %s: %v", m.ID.Hex(), err)
+		}
+	}
 	return m, nil
 }
 
-func (s *service) List(ctx context.Context, roomID string, limit int64, cursor string) ([]Message, string, error) {
-	return s.repo.ListByRoom(ctx, roomID, limit, cursor)
+// Pins returns the messages pinned in a room, most recently pinned first.
+func (s *service) Pins(ctx context.Context, userID string, roomID string) ([]Message, error) {
+	if err := s.requireMember(ctx, roomID, userID); err != nil {
+		return nil, err
+	}
+	items, err := s.repo.ListPinned(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if items == nil {
+		items = []Message{}
+	}
+	summarizeAll(items)
+	return items, nil
+}
+
+// Search finds messages matching q.Text in rooms the user belongs to, optionally narrowed to q.RoomID.
+func (s *service) Search(ctx context.Context, userID string, q SearchQuery) (*SearchResponse, error) {
+	q.Text = strings.TrimSpace(q.Text)
+	if q.Text == "" {
+		return nil, ErrEmptyQuery
+	}
+	var roomIDs []string
+	if q.RoomID != "" {
+		if err := s.requireMember(ctx, q.RoomID, userID); err != nil {
+			return nil, err
+		}
+		roomIDs = []string{q.RoomID}
+	} else {
+		ids, err := s.rooms.MemberRoomIDs(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		roomIDs = ids
+	}
+	resp := &SearchResponse{Items: []SearchResult{}}
+	if len(roomIDs) == 0 {
+		return resp, nil
+	}
+	items, next, err := s.repo.Search(ctx, q, roomIDs)
+	if err != nil {
+		return nil, err
+	}
+	terms := searchTerms(q.Text)
+	for i := range items {
+		items[i].summarizeReactions()
+		resp.Items = append(resp.Items, SearchResult{Message: items[i], Snippet: snippet(items[i].Text, terms)})
+	}
+	resp.NextCursor = next
+	return resp, nil
+}
+
+// Mentions returns the caller's mention inbox: messages that mention them in rooms they still belong to.
+func (s *service) Mentions(ctx context.Context, userID string, limit int64, cursor string) ([]Message, string, error) {
+	roomIDs, err := s.rooms.MemberRoomIDs(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(roomIDs) == 0 {
+		return []Message{}, "", nil
+	}
+	items, next, err := s.repo.ListMentions(ctx, userID, roomIDs, limit, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	summarizeAll(items)
+	return items, next, nil
+}
+
+func summarizeAll(items []Message) {
+	for i := range items {
+		items[i].summarizeReactions()
+	}
+}
+
+// MarkRead moves the caller's read position in a room forward to msgID and, if it moved,
+// notifies the room so clients can show who has seen what.
+func (s *service) MarkRead(ctx context.Context, userID string, roomID string, msgID string) error {
+	m, err := s.findInRoom(ctx, userID, roomID, msgID)
+	if err != nil {
+		return err
+	}
+	moved, err := s.rooms.MarkRead(ctx, roomID, userID, m.ID.Hex())
+	if err != nil {
+		return err
+	}
+	if moved && s.notifier != nil {
+		if err := s.notifier.ReadPositionChanged(ctx, roomID, userID, m.ID.Hex()); err != nil {
+			log.Printf("message: failed to publish read position for %s: %v", userID, err)
+		}
+	}
+	return nil
+}
+
+func (s *service) requireMember(ctx context.Context, roomID string, userID string) error {
+	ok, err := s.rooms.IsMember(ctx, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotMember
+	}
+	return nil
+}
+
+// findInRoom loads a message after checking the caller belongs to the room it was posted in.
+func (s *service) findInRoom(ctx context.Context, userID string, roomID string, msgID string) (*Message, error) {
+	if err := s.requireMember(ctx, roomID, userID); err != nil {
+		return nil, err
+	}
+	m, err := s.repo.FindByID(ctx, msgID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.RoomID != roomID {
+		return nil, ErrNotFound
+	}
+	return m, nil
 }
@@ -0,0 +1,814 @@
+package message
+
+import (
+	"chatapp/internal/chatroom"
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mockRepository implements Repository for unit tests
+type mockRepository struct {
+	messages map[string]*Message
+	// reactErr, if set, is what AddReaction and RemoveReaction fail with.
+	reactErr error
+	// findErr, if set, is what FindByID fails with.
+	findErr error
+}
+
+func newMockRepository(msgs ...*Message) *mockRepository {
+	m := &mockRepository{messages: make(map[string]*Message)}
+	for _, msg := range msgs {
+		m.messages[msg.ID.Hex()] = msg
+	}
+	return m
+}
+
+func (m *mockRepository) Insert(ctx context.Context, msg *Message) error {
+	if msg.IdempotencyKey != "" {
+		if _, err := m.FindByIdempotencyKey(ctx, msg.IdempotencyKey); err == nil {
+			return ErrDuplicate
+		}
+	}
+	msg.ID = primitive.NewObjectID()
+	m.messages[msg.ID.Hex()] = msg
+	return nil
+}
+
+func (m *mockRepository) FindByIdempotencyKey(ctx context.Context, key string) (*Message, error) {
+	for _, msg := range m.messages {
+		if msg.IdempotencyKey == key {
+			return msg, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *mockRepository) ListByRoom(ctx context.Context, roomID string, limit int64, cursor string) ([]Message, string, error) {
+	var out []Message
+	for _, msg := range m.messages {
+		if msg.RoomID == roomID {
+			out = append(out, *msg)
+		}
+	}
+	return out, "", nil
+}
+
+func (m *mockRepository) ListThread(ctx context.Context, parentID string, limit int64, cursor string) ([]Message, string, error) {
+	var out []Message
+	for _, msg := range m.messages {
+		if msg.ParentID == parentID {
+			out = append(out, *msg)
+		}
+	}
+	return out, "", nil
+}
+
+func (m *mockRepository) IncrementReplies(ctx context.Context, parentID primitive.ObjectID, at time.Time) error {
+	if stored, ok := m.messages[parentID.Hex()]; ok {
+		stored.ReplyCount++
+		stored.LastReplyAt = &at
+	}
+	return nil
+}
+
+func (m *mockRepository) MarkPublished(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	if stored, ok := m.messages[id.Hex()]; ok {
+		stored.PublishedAt = &at
+	}
+	return nil
+}
+
+func (m *mockRepository) Search(ctx context.Context, q SearchQuery, roomIDs []string) ([]Message, string, error) {
+	allowed := make(map[string]bool)
+	for _, id := range roomIDs {
+		allowed[id] = true
+	}
+	var out []Message
+	for _, msg := range m.messages {
+		if allowed[msg.RoomID] && msg.DeletedAt == nil && strings.Contains(strings.ToLower(msg.Text), strings.ToLower(q.Text)) {
+			out = append(out, *msg)
+		}
+	}
+	return out, "", nil
+}
+
+func (m *mockRepository) ListMentions(ctx context.Context, userID string, roomIDs []string, limit int64, cursor string) ([]Message, string, error) {
+	allowed := make(map[string]bool)
+	for _, id := range roomIDs {
+		allowed[id] = true
+	}
+	var out []Message
+	for _, msg := range m.messages {
+		if !allowed[msg.RoomID] || msg.DeletedAt != nil {
+			continue
+		}
+		for _, uid := range msg.Mentions {
+			if uid == userID {
+				out = append(out, *msg)
+				break
+			}
+		}
+	}
+	return out, "", nil
+}
+
+func (m *mockRepository) CountUnread(ctx context.Context, userID string, readPositions map[string]string) (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+
+func (m *mockRepository) ListAfter(ctx context.Context, roomID string, afterID primitive.ObjectID, limit int64) ([]Message, error) {
+	var out []Message
+	for _, msg := range m.messages {
+		if msg.RoomID == roomID && msg.ID.Hex() > afterID.Hex() {
+			out = append(out, *msg)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID.Hex() < out[j].ID.Hex() })
+	if int64(len(out)) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (m *mockRepository) FindByID(ctx context.Context, id string) (*Message, error) {
+	if m.findErr != nil {
+		return nil, m.findErr
+	}
+	msg, ok := m.messages[id]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	cp := *msg
+	return &cp, nil
+}
+
+func (m *mockRepository) SoftDelete(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) (bool, error) {
+	stored, ok := m.messages[id.Hex()]
+	if !ok || stored.DeletedAt != nil {
+		return false, nil
+	}
+	stored.Text = ""
+	stored.Revisions = nil
+	stored.DeletedAt = &deletedAt
+	stored.DeletedBy = deletedBy
+	return true, nil
+}
+
+func (m *mockRepository) SetPinned(ctx context.Context, id primitive.ObjectID, pinnedBy string, pinnedAt *time.Time) (bool, error) {
+	stored, ok := m.messages[id.Hex()]
+	if !ok || stored.DeletedAt != nil || (stored.PinnedAt == nil) == (pinnedAt == nil) {
+		return false, nil
+	}
+	stored.PinnedAt = pinnedAt
+	stored.PinnedBy = ""
+	if pinnedAt != nil {
+		stored.PinnedBy = pinnedBy
+	}
+	return true, nil
+}
+
+func (m *mockRepository) ListPinned(ctx context.Context, roomID string) ([]Message, error) {
+	var out []Message
+	for _, msg := range m.messages {
+		if msg.RoomID == roomID && msg.PinnedAt != nil {
+			out = append(out, *msg)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PinnedAt.After(*out[j].PinnedAt) })
+	return out, nil
+}
+
+func (m *mockRepository) AddReaction(ctx context.Context, id primitive.ObjectID, r Reaction) (*Message, bool, error) {
+	if m.reactErr != nil {
+		return nil, false, m.reactErr
+	}
+	stored, ok := m.messages[id.Hex()]
+	if !ok || stored.DeletedAt != nil {
+		return nil, false, mongo.ErrNoDocuments
+	}
+	for _, existing := range stored.Reactions {
+		if existing == r {
+			cp := *stored
+			return &cp, false, nil
+		}
+	}
+	stored.Reactions = append(stored.Reactions, r)
+	cp := *stored
+	return &cp, true, nil
+}
+
+func (m *mockRepository) RemoveReaction(ctx context.Context, id primitive.ObjectID, r Reaction) (*Message, bool, error) {
+	if m.reactErr != nil {
+		return nil, false, m.reactErr
+	}
+	stored, ok := m.messages[id.Hex()]
+	if !ok || stored.DeletedAt != nil {
+		return nil, false, mongo.ErrNoDocuments
+	}
+	kept := stored.Reactions[:0]
+	for _, existing := range stored.Reactions {
+		if existing != r {
+			kept = append(kept, existing)
+		}
+	}
+	changed := len(kept) != len(stored.Reactions)
+	stored.Reactions = kept
+	cp := *stored
+	return &cp, changed, nil
+}
+
+func (m *mockRepository) UpdateText(ctx context.Context, msg *Message, text string, mentions []string, editedAt time.Time) (bool, error) {
+	stored, ok := m.messages[msg.ID.Hex()]
+	if !ok || stored.Text != msg.Text {
+		return false, nil
+	}
+	stored.Revisions = append(stored.Revisions, Revision{Text: stored.Text})
+	stored.Text = text
+	stored.Mentions = mentions
+	stored.EditedAt = &editedAt
+	return true, nil
+}
+
+// mockRooms implements ChatRoomReader for unit tests
+type mockRooms struct {
+	members    map[string]bool
+	moderators map[string]bool
+	lastRead   map[string]string
+	touched    map[string]time.Time
+	// authErr, if set, is what Authorize fails with, as when the room lookup itself fails.
+	authErr error
+}
+
+func (m *mockRooms) IsMember(ctx context.Context, roomID string, userID string) (bool, error) {
+	return m.members[roomID+"/"+userID], nil
+}
+
+func (m *mockRooms) MemberRoomIDs(ctx context.Context, userID string) ([]string, error) {
+	var out []string
+	for key, ok := range m.members {
+		if room, uid, _ := strings.Cut(key, "/"); ok && uid == userID {
+			out = append(out, room)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockRooms) MarkRead(ctx context.Context, roomID string, userID string, msgID string) (bool, error) {
+	if m.lastRead == nil {
+		m.lastRead = make(map[string]string)
+	}
+	key := roomID + "/" + userID
+	if m.lastRead[key] >= msgID {
+		return false, nil
+	}
+	m.lastRead[key] = msgID
+	return true, nil
+}
+
+func (m *mockRooms) Touch(ctx context.Context, roomID string, at time.Time) error {
+	if m.touched == nil {
+		m.touched = make(map[string]time.Time)
+	}
+	m.touched[roomID] = at
+	return nil
+}
+
+func (m *mockRooms) Authorize(ctx context.Context, roomID string, userID string, perm chatroom.Permission) error {
+	if m.authErr != nil {
+		return m.authErr
+	}
+	if (perm == chatroom.PermDeleteMessages || perm == chatroom.PermPin) && m.moderators[roomID+"/"+userID] {
+		return nil
+	}
+	return chatroom.ErrForbidden
+}
+
+// mockNotifier records published events
+type mockNotifier struct {
+	updated   []*Message
+	deleted   []*Message
+	reactions int
+	pins      []bool
+	reads     []string
+	mentioned [][]string
+}
+
+func (m *mockNotifier) UsersMentioned(ctx context.Context, msg *Message, userIDs []string) error {
+	m.mentioned = append(m.mentioned, userIDs)
+	return nil
+}
+
+func (m *mockNotifier) ReadPositionChanged(ctx context.Context, roomID string, userID string, msgID string) error {
+	m.reads = append(m.reads, msgID)
+	return nil
+}
+
+func (m *mockNotifier) ReactionChanged(ctx context.Context, msg *Message, userID string, emoji string, added bool) error {
+	m.reactions++
+	return nil
+}
+
+func (m *mockNotifier) MessagePinned(ctx context.Context, msg *Message, userID string, pinned bool) error {
+	m.pins = append(m.pins, pinned)
+	return nil
+}
+
+func (m *mockNotifier) MessageDeleted(ctx context.Context, msg *Message) error {
+	m.deleted = append(m.deleted, msg)
+	return nil
+}
+
+func (m *mockNotifier) MessageUpdated(ctx context.Context, msg *Message) error {
+	m.updated = append(m.updated, msg)
+	return nil
+}
+
+func newUserMessage(userID string, createdAt time.Time) *Message {
+	return &Message{ID: primitive.NewObjectID(), RoomID: "room1", UserID: userID, Text: "helo", Type: "user", CreatedAt: createdAt}
+}
+
+func TestService_List_RequiresMembership(t *testing.T) {
+	s := NewService(newMockRepository(), &mockRooms{}, nil, nil)
+	if _, _, err := s.List(context.Background(), "alice", "room1", 20, ""); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("expected ErrNotMember, got %v", err)
+	}
+}
+
+func TestService_Edit_Success(t *testing.T) {
+	msg := newUserMessage("alice", time.Now().UTC())
+	notifier := &mockNotifier{}
+	s := NewService(newMockRepository(msg), &mockRooms{members: map[string]bool{"room1/alice": true}}, notifier, nil)
+
+	m, err := s.Edit(context.Background(), "alice", "room1", msg.ID.Hex(), "hello")
+	if err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if m.Text != "hello" || m.EditedAt == nil {
+		t.Fatalf("unexpected message after edit: %+v", m)
+	}
+	if len(m.Revisions) != 1 || m.Revisions[0].Text != "helo" {
+		t.Fatalf("expected previous text to be kept as a revision, got %+v", m.Revisions)
+	}
+	if len(notifier.updated) != 1 {
+		t.Fatalf("expected one update event, got %d", len(notifier.updated))
+	}
+}
+
+// mockMentions resolves every @name in text to the user ID name.
+type mockMentions struct{}
+
+func (mockMentions) Resolve(ctx context.Context, roomID string, authorID string, text string) []string {
+	var ids []string
+	for _, word := range strings.Fields(text) {
+		if name, ok := strings.CutPrefix(word, "@"); ok {
+			ids = append(ids, name)
+		}
+	}
+	return ids
+}
+
+func TestService_Edit_ResolvesMentionsAgain(t *testing.T) {
+	msg := newUserMessage("alice", time.Now().UTC())
+	msg.Text = "hi @bob"
+	msg.Mentions = []string{"bob"}
+	notifier := &mockNotifier{}
+	s := NewService(newMockRepository(msg), &mockRooms{members: map[string]bool{"room1/alice": true}}, notifier, mockMentions{})
+
+	m, err := s.Edit(context.Background(), "alice", "room1", msg.ID.Hex(), "hi @bob and @carol")
+	if err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if len(m.Mentions) != 2 || m.Mentions[1] != "carol" {
+		t.Fatalf("expected bob and carol to be mentioned, got %v", m.Mentions)
+	}
+	if len(notifier.mentioned) != 1 || len(notifier.mentioned[0]) != 1 || notifier.mentioned[0][0] != "carol" {
+		t.Fatalf("expected only carol to be notified, got %v", notifier.mentioned)
+	}
+
+	m, err = s.Edit(context.Background(), "alice", "room1", msg.ID.Hex(), "hi all")
+	if err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if len(m.Mentions) != 0 || len(notifier.mentioned) != 1 {
+		t.Fatalf("expected the mentions to be dropped without a notification, got %v", m.Mentions)
+	}
+}
+
+func TestService_Revisions_AuthorAndModeratorsOnly(t *testing.T) {
+	msg := newUserMessage("alice", time.Now().UTC())
+	rooms := &mockRooms{
+		members:    map[string]bool{"room1/alice": true, "room1/bob": true, "room1/mod": true},
+		moderators: map[string]bool{"room1/mod": true},
+	}
+	s := NewService(newMockRepository(msg), rooms, nil, nil)
+	ctx := context.Background()
+	if _, err := s.Edit(ctx, "alice", "room1", msg.ID.Hex(), "hello"); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+
+	for _, uid := range []string{"alice", "mod"} {
+		revs, err := s.Revisions(ctx, uid, "room1", msg.ID.Hex())
+		if err != nil || len(revs) != 1 {
+			t.Fatalf("expected %s to see one revision, got %v, %v", uid, revs, err)
+		}
+	}
+	if _, err := s.Revisions(ctx, "bob", "room1", msg.ID.Hex()); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for another member, got %v", err)
+	}
+}
+
+func TestService_Edit_Rejections(t *testing.T) {
+	fresh := newUserMessage("alice", time.Now().UTC())
+	stale := newUserMessage("alice", time.Now().UTC().Add(-EditWindow-time.Minute))
+	rooms := &mockRooms{members: map[string]bool{"room1/alice": true, "room1/bob": true}}
+	s := NewService(newMockRepository(fresh, stale), rooms, nil, nil)
+	ctx := context.Background()
+
+	if _, err := s.Edit(ctx, "bob", "room1", fresh.ID.Hex(), "hijack"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for non-author, got %v", err)
+	}
+	if _, err := s.Edit(ctx, "alice", "room1", stale.ID.Hex(), "late"); !errors.Is(err, ErrEditWindowExpired) {
+		t.Fatalf("expected ErrEditWindowExpired, got %v", err)
+	}
+	if _, err := s.Edit(ctx, "alice", "room2", fresh.ID.Hex(), "moved"); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("expected ErrNotMember for other room, got %v", err)
+	}
+	if _, err := s.Edit(ctx, "alice", "room1", fresh.ID.Hex(), "   "); !errors.Is(err, ErrEmptyMessage) {
+		t.Fatalf("expected ErrEmptyMessage, got %v", err)
+	}
+}
+
+func TestService_Delete_AuthorAndModerator(t *testing.T) {
+	own := newUserMessage("alice", time.Now().UTC())
+	other := newUserMessage("alice", time.Now().UTC())
+	repo := newMockRepository(own, other)
+	rooms := &mockRooms{
+		members:    map[string]bool{"room1/alice": true, "room1/bob": true, "room1/mod": true},
+		moderators: map[string]bool{"room1/mod": true},
+	}
+	notifier := &mockNotifier{}
+	s := NewService(repo, rooms, notifier, nil)
+	ctx := context.Background()
+
+	if err := s.Delete(ctx, "alice", "room1", own.ID.Hex()); err != nil {
+		t.Fatalf("author delete: %v", err)
+	}
+	if err := s.Delete(ctx, "bob", "room1", other.ID.Hex()); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for non-author member, got %v", err)
+	}
+	if err := s.Delete(ctx, "mod", "room1", other.ID.Hex()); err != nil {
+		t.Fatalf("moderator delete: %v", err)
+	}
+	if stored := repo.messages[other.ID.Hex()]; stored.DeletedAt == nil || stored.DeletedBy != "mod" || stored.Text != "" {
+		t.Fatalf("expected tombstone, got %+v", stored)
+	}
+	if len(notifier.deleted) != 2 {
+		t.Fatalf("expected two delete events, got %d", len(notifier.deleted))
+	}
+	if _, err := s.Edit(ctx, "alice", "room1", own.ID.Hex(), "again"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deleted message to be uneditable, got %v", err)
+	}
+}
+
+func TestService_Moderation_PassesThroughLookupFailures(t *testing.T) {
+	msg := newUserMessage("alice", time.Now().UTC())
+	lookup := errors.New("connection reset")
+	rooms := &mockRooms{members: map[string]bool{"room1/alice": true, "room1/mod": true}, authErr: lookup}
+	s := NewService(newMockRepository(msg), rooms, nil, nil)
+	ctx := context.Background()
+
+	if err := s.Delete(ctx, "mod", "room1", msg.ID.Hex()); !errors.Is(err, lookup) {
+		t.Fatalf("expected the lookup failure from Delete, got %v", err)
+	}
+	rooms.authErr = chatroom.ErrDirectMessage
+	if err := s.Delete(ctx, "mod", "room1", msg.ID.Hex()); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden in a direct message, got %v", err)
+	}
+	rooms.authErr = lookup
+	if _, err := s.Revisions(ctx, "mod", "room1", msg.ID.Hex()); !errors.Is(err, lookup) {
+		t.Fatalf("expected the lookup failure from Revisions, got %v", err)
+	}
+}
+
+func TestService_FindInRoom_PassesThroughLookupFailures(t *testing.T) {
+	msg := newUserMessage("alice", time.Now().UTC())
+	repo := newMockRepository(msg)
+	s := NewService(repo, &mockRooms{members: map[string]bool{"room1/alice": true, "room2/alice": true}}, nil, nil)
+	ctx := context.Background()
+
+	if _, err := s.Revisions(ctx, "alice", "room2", msg.ID.Hex()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a message of another room, got %v", err)
+	}
+	if err := s.Delete(ctx, "alice", "room1", primitive.NewObjectID().Hex()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown message, got %v", err)
+	}
+	repo.findErr = errors.New("connection reset")
+	if _, err := s.Edit(ctx, "alice", "room1", msg.ID.Hex(), "hello"); !errors.Is(err, repo.findErr) {
+		t.Fatalf("expected the lookup failure from Edit, got %v", err)
+	}
+	if _, err := s.Thread(ctx, "alice", "room1", msg.ID.Hex(), 20, ""); !errors.Is(err, repo.findErr) {
+		t.Fatalf("expected the lookup failure from Thread, got %v", err)
+	}
+}
+
+func TestService_Thread(t *testing.T) {
+	root := newUserMessage("alice", time.Now().UTC())
+	repo := newMockRepository(root)
+	s := NewService(repo, &mockRooms{members: map[string]bool{"room1/bob": true}}, nil, nil)
+	ctx := context.Background()
+
+	reply, err := s.CreateReply(ctx, "bob", "Bob", "room1", root.ID.Hex(), "first", nil, "")
+	if err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	nested, err := s.CreateReply(ctx, "bob", "Bob", "room1", reply.ID.Hex(), "second", nil, "")
+	if err != nil {
+		t.Fatalf("nested reply: %v", err)
+	}
+	if nested.ParentID != root.ID.Hex() {
+		t.Fatalf("expected nested reply to attach to the thread root, got parent %s", nested.ParentID)
+	}
+	if _, err := s.CreateReply(ctx, "bob", "Bob", "room2", root.ID.Hex(), "elsewhere", nil, ""); !errors.Is(err, ErrParentNotFound) {
+		t.Fatalf("expected ErrParentNotFound for cross-room reply, got %v", err)
+	}
+
+	thread, err := s.Thread(ctx, "bob", "room1", root.ID.Hex(), 20, "")
+	if err != nil {
+		t.Fatalf("thread: %v", err)
+	}
+	if len(thread.Items) != 2 || thread.Parent.ReplyCount != 2 || thread.Parent.LastReplyAt == nil {
+		t.Fatalf("unexpected thread: %+v", thread)
+	}
+}
+
+func TestService_Create_DeduplicatesByIdempotencyKey(t *testing.T) {
+	root := newUserMessage("alice", time.Now().UTC())
+	repo := newMockRepository(root)
+	s := NewService(repo, &mockRooms{}, nil, nil)
+	ctx := context.Background()
+
+	first, err := s.CreateWithName(ctx, "bob", "Bob", "room1", "hi", nil, "bob:c1")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	again, err := s.CreateWithName(ctx, "bob", "Bob", "room1", "hi", nil, "bob:c1")
+	if !errors.Is(err, ErrDuplicate) || again == nil || again.ID != first.ID {
+		t.Fatalf("expected the first message with ErrDuplicate, got %v %v", again, err)
+	}
+
+	if _, err := s.CreateReply(ctx, "bob", "Bob", "room1", root.ID.Hex(), "re", nil, "bob:c2"); err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	if _, err := s.CreateReply(ctx, "bob", "Bob", "room1", root.ID.Hex(), "re", nil, "bob:c2"); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("expected ErrDuplicate for a repeated reply, got %v", err)
+	}
+	if root.ReplyCount != 1 {
+		t.Fatalf("expected a repeated reply not to count twice, got %d", root.ReplyCount)
+	}
+
+	if _, err := s.CreateWithName(ctx, "bob", "Bob", "room1", "hi", nil, ""); err != nil {
+		t.Fatalf("create without key: %v", err)
+	}
+	if _, err := s.CreateWithName(ctx, "bob", "Bob", "room1", "hi", nil, ""); err != nil {
+		t.Fatalf("expected messages without a key never to be deduplicated, got %v", err)
+	}
+	if len(repo.messages) != 5 {
+		t.Fatalf("expected 5 stored messages, got %d", len(repo.messages))
+	}
+}
+
+func TestService_Create_RecordsRoomActivity(t *testing.T) {
+	rooms := &mockRooms{}
+	s := NewService(newMockRepository(), rooms, nil, nil)
+	ctx := context.Background()
+
+	m, err := s.CreateWithName(ctx, "bob", "Bob", "room1", "hi", nil, "bob:c1")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if !rooms.touched["room1"].Equal(m.CreatedAt) {
+		t.Fatalf("expected room1 to be touched at %v, got %v", m.CreatedAt, rooms.touched["room1"])
+	}
+	delete(rooms.touched, "room1")
+	if _, err := s.CreateWithName(ctx, "bob", "Bob", "room1", "hi", nil, "bob:c1"); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("expected ErrDuplicate, got %v", err)
+	}
+	if _, ok := rooms.touched["room1"]; ok {
+		t.Fatal("expected a duplicate not to touch the room again")
+	}
+}
+
+func TestService_MarkPublished_SeenByDuplicates(t *testing.T) {
+	s := NewService(newMockRepository(), &mockRooms{}, nil, nil)
+	ctx := context.Background()
+
+	m, err := s.CreateWithName(ctx, "bob", "Bob", "room1", "hi", nil, "bob:c1")
+	if err != nil || m.PublishedAt != nil {
+		t.Fatalf("expected a new, unpublished message, got %v %v", m, err)
+	}
+	if again, err := s.CreateWithName(ctx, "bob", "Bob", "room1", "hi", nil, "bob:c1"); !errors.Is(err, ErrDuplicate) || again.PublishedAt != nil {
+		t.Fatalf("expected an unpublished duplicate, got %v %v", again, err)
+	}
+	if err := s.MarkPublished(ctx, m); err != nil || m.PublishedAt == nil {
+		t.Fatalf("mark published: %v", err)
+	}
+	if again, err := s.CreateWithName(ctx, "bob", "Bob", "room1", "hi", nil, "bob:c1"); !errors.Is(err, ErrDuplicate) || again.PublishedAt == nil {
+		t.Fatalf("expected the duplicate to be marked published, got %v %v", again, err)
+	}
+}
+
+func TestService_Reactions(t *testing.T) {
+	msg := newUserMessage("alice", time.Now().UTC())
+	notifier := &mockNotifier{}
+	rooms := &mockRooms{members: map[string]bool{"room1/alice": true, "room1/bob": true}}
+	s := NewService(newMockRepository(msg), rooms, notifier, nil)
+	ctx := context.Background()
+	id := msg.ID.Hex()
+
+	_, _ = s.AddReaction(ctx, "alice", "room1", id, "👍")
+	_, _ = s.AddReaction(ctx, "alice", "room1", id, "👍")
+	m, err := s.AddReaction(ctx, "bob", "room1", id, "👍")
+	if err != nil {
+		t.Fatalf("add reaction: %v", err)
+	}
+	if len(m.ReactionCounts) != 1 || m.ReactionCounts[0].Count != 2 {
+		t.Fatalf("expected one emoji with two reactions, got %+v", m.ReactionCounts)
+	}
+
+	m, err = s.RemoveReaction(ctx, "alice", "room1", id, "👍")
+	if err != nil {
+		t.Fatalf("remove reaction: %v", err)
+	}
+	if len(m.ReactionCounts) != 1 || m.ReactionCounts[0].Count != 1 || m.ReactionCounts[0].UserIDs[0] != "bob" {
+		t.Fatalf("expected only bob's reaction to remain, got %+v", m.ReactionCounts)
+	}
+	if _, err := s.RemoveReaction(ctx, "alice", "room1", id, "👍"); err != nil {
+		t.Fatalf("remove missing reaction: %v", err)
+	}
+	// The repeated add and the removal of a reaction that was gone announce nothing.
+	if notifier.reactions != 3 {
+		t.Fatalf("expected three reaction events, got %d", notifier.reactions)
+	}
+
+	for _, emoji := range []string{"two words", "lol", "<b>"} {
+		if _, err := s.AddReaction(ctx, "alice", "room1", id, emoji); !errors.Is(err, ErrInvalidEmoji) {
+			t.Fatalf("expected ErrInvalidEmoji for %q, got %v", emoji, err)
+		}
+	}
+	items, _, _ := s.List(ctx, "alice", "room1", 20, "")
+	if len(items) != 1 || len(items[0].ReactionCounts) != 1 {
+		t.Fatalf("expected history to include reaction counts, got %+v", items)
+	}
+}
+
+func TestService_Reactions_PassThroughWriteFailures(t *testing.T) {
+	msg := newUserMessage("alice", time.Now().UTC())
+	repo := newMockRepository(msg)
+	rooms := &mockRooms{members: map[string]bool{"room1/alice": true}}
+	s := NewService(repo, rooms, nil, nil)
+	ctx := context.Background()
+
+	repo.reactErr = errors.New("connection reset")
+	if _, err := s.AddReaction(ctx, "alice", "room1", msg.ID.Hex(), "👍"); !errors.Is(err, repo.reactErr) {
+		t.Fatalf("expected the write failure, got %v", err)
+	}
+	repo.reactErr = mongo.ErrNoDocuments
+	if _, err := s.RemoveReaction(ctx, "alice", "room1", msg.ID.Hex(), "👍"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a message deleted meanwhile, got %v", err)
+	}
+}
+
+func TestService_Pin_RequiresPinPermission(t *testing.T) {
+	msg := newUserMessage("alice", time.Now().UTC())
+	rooms := &mockRooms{
+		members:    map[string]bool{"room1/alice": true, "room1/mod": true},
+		moderators: map[string]bool{"room1/mod": true},
+	}
+	notifier := &mockNotifier{}
+	s := NewService(newMockRepository(msg), rooms, notifier, nil)
+	ctx := context.Background()
+
+	if _, err := s.Pin(ctx, "alice", "room1", msg.ID.Hex()); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for a member without pin, got %v", err)
+	}
+	m, err := s.Pin(ctx, "mod", "room1", msg.ID.Hex())
+	if err != nil {
+		t.Fatalf("pin: %v", err)
+	}
+	if m.PinnedAt == nil || m.PinnedBy != "mod" {
+		t.Fatalf("expected message pinned by mod, got %+v", m)
+	}
+	if _, err := s.Pin(ctx, "mod", "room1", msg.ID.Hex()); err != nil {
+		t.Fatalf("repeated pin: %v", err)
+	}
+	pins, err := s.Pins(ctx, "alice", "room1")
+	if err != nil || len(pins) != 1 || pins[0].ID != msg.ID {
+		t.Fatalf("expected the pinned message to be listed, got %+v, %v", pins, err)
+	}
+	if _, err := s.Unpin(ctx, "mod", "room1", msg.ID.Hex()); err != nil {
+		t.Fatalf("unpin: %v", err)
+	}
+	if pins, _ := s.Pins(ctx, "alice", "room1"); len(pins) != 0 {
+		t.Fatalf("expected no pins after unpin, got %+v", pins)
+	}
+	if len(notifier.pins) != 2 || !notifier.pins[0] || notifier.pins[1] {
+		t.Fatalf("expected one pin and one unpin event, got %v", notifier.pins)
+	}
+	if _, err := s.Pins(ctx, "bob", "room1"); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("expected ErrNotMember listing pins as a non-member, got %v", err)
+	}
+}
+
+func TestService_Search_OnlyAccessibleRooms(t *testing.T) {
+	mine := &Message{ID: primitive.NewObjectID(), RoomID: "room1", Text: "AAPL quote is 150", Type: "bot"}
+	theirs := &Message{ID: primitive.NewObjectID(), RoomID: "secret", Text: "AAPL insider tip", Type: "user"}
+	rooms := &mockRooms{members: map[string]bool{"room1/alice": true}}
+	s := NewService(newMockRepository(mine, theirs), rooms, nil, nil)
+	ctx := context.Background()
+
+	resp, err := s.Search(ctx, "alice", SearchQuery{Text: "aapl"})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(resp.Items) != 1 || resp.Items[0].Message.ID != mine.ID {
+		t.Fatalf("expected only the accessible message, got %+v", resp.Items)
+	}
+	if !strings.Contains(resp.Items[0].Snippet, "<mark>AAPL</mark>") {
+		t.Fatalf("expected highlighted snippet, got %q", resp.Items[0].Snippet)
+	}
+	if _, err := s.Search(ctx, "alice", SearchQuery{Text: "aapl", RoomID: "secret"}); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("expected ErrNotMember for inaccessible room, got %v", err)
+	}
+	if _, err := s.Search(ctx, "alice", SearchQuery{Text: "  "}); !errors.Is(err, ErrEmptyQuery) {
+		t.Fatalf("expected ErrEmptyQuery, got %v", err)
+	}
+}
+
+func TestService_Mentions_SkipsRoomsLeft(t *testing.T) {
+	here := &Message{ID: primitive.NewObjectID(), RoomID: "room1", Text: "@bob ping", Mentions: []string{"bob"}}
+	left := &Message{ID: primitive.NewObjectID(), RoomID: "room2", Text: "@bob old", Mentions: []string{"bob"}}
+	other := &Message{ID: primitive.NewObjectID(), RoomID: "room1", Text: "@carol hi", Mentions: []string{"carol"}}
+	rooms := &mockRooms{members: map[string]bool{"room1/bob": true}}
+	s := NewService(newMockRepository(here, left, other), rooms, nil, nil)
+
+	items, _, err := s.Mentions(context.Background(), "bob", 20, "")
+	if err != nil {
+		t.Fatalf("mentions: %v", err)
+	}
+	if len(items) != 1 || items[0].ID != here.ID {
+		t.Fatalf("expected only the mention in a current room, got %+v", items)
+	}
+}
+
+func TestService_MarkRead_OnlyMovesForward(t *testing.T) {
+	older := newUserMessage("alice", time.Now().UTC())
+	newer := newUserMessage("alice", time.Now().UTC())
+	rooms := &mockRooms{members: map[string]bool{"room1/bob": true}}
+	n := &mockNotifier{}
+	s := NewService(newMockRepository(older, newer), rooms, n, nil)
+	ctx := context.Background()
+
+	if err := s.MarkRead(ctx, "bob", "room1", newer.ID.Hex()); err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+	if err := s.MarkRead(ctx, "bob", "room1", older.ID.Hex()); err != nil {
+		t.Fatalf("mark read older: %v", err)
+	}
+	if len(n.reads) != 1 || n.reads[0] != newer.ID.Hex() {
+		t.Fatalf("expected a single read event for the newer message, got %v", n.reads)
+	}
+	if err := s.MarkRead(ctx, "carol", "room1", newer.ID.Hex()); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("expected ErrNotMember, got %v", err)
+	}
+}
+
+func TestService_Since_ReturnsNewerMessagesOldestFirst(t *testing.T) {
+	seen := newUserMessage("alice", time.Now().UTC())
+	first := newUserMessage("alice", time.Now().UTC())
+	second := newUserMessage("bob", time.Now().UTC())
+	second.ParentID = first.ID.Hex()
+	rooms := &mockRooms{members: map[string]bool{"room1/bob": true}}
+	s := NewService(newMockRepository(second, seen, first), rooms, nil, nil)
+	ctx := context.Background()
+
+	items, err := s.Since(ctx, "bob", "room1", seen.ID.Hex(), 50)
+	if err != nil {
+		t.Fatalf("since: %v", err)
+	}
+	if len(items) != 2 || items[0].ID != first.ID || items[1].ID != second.ID {
+		t.Fatalf("expected the two newer messages in order, got %+v", items)
+	}
+	if _, err := s.Since(ctx, "bob", "room1", "not-an-id", 50); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+	if _, err := s.Since(ctx, "carol", "room1", seen.ID.Hex(), 50); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("expected ErrNotMember, got %v", err)
+	}
+}
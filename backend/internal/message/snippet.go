@@ -0,0 +1,99 @@
+package message
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	snippetBefore = 60
+	snippetLength = 160
+)
+
+// searchTerms extracts the words of a $text query worth highlighting, skipping negations.
+func searchTerms(q string) []string {
+	var terms []string
+	for _, f := range strings.Fields(q) {
+		if strings.HasPrefix(f, "-") {
+			continue
+		}
+		f = strings.Trim(f, `"`)
+		if f != "" {
+			terms = append(terms, f)
+		}
+	}
+	return terms
+}
+
+// snippet returns an HTML-escaped excerpt of text around the first matched term, with every
+// case-insensitive match wrapped in <mark>. Mongo matches stemmed words, so a result may
+// contain no literal match; the excerpt then starts at the beginning of the text.
+func snippet(text string, terms []string) string {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, t := range terms {
+		needle := []rune(strings.ToLower(t))
+		if len(needle) == 0 {
+			continue
+		}
+		for i := 0; i+len(needle) <= len(lower); i++ {
+			if !runesEqual(lower[i:i+len(needle)], needle) {
+				continue
+			}
+			for j := i; j < i+len(needle); j++ {
+				marked[j] = true
+			}
+			if first == -1 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start := 0
+	if first > snippetBefore {
+		start = first - snippetBefore
+	}
+	end := start + snippetLength
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	inMark := false
+	for i := start; i < end; i++ {
+		if marked[i] && !inMark {
+			b.WriteString("<mark>")
+			inMark = true
+		} else if !marked[i] && inMark {
+			b.WriteString("</mark>")
+			inMark = false
+		}
+		b.WriteString(html.EscapeString(string(runes[i])))
+	}
+	if inMark {
+		b.WriteString("</mark>")
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func runesEqual(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,32 @@
+package message
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSearchTerms(t *testing.T) {
+	got := searchTerms(`aapl "quote" -spam`)
+	if len(got) != 2 || got[0] != "aapl" || got[1] != "quote" {
+		t.Fatalf("unexpected terms: %v", got)
+	}
+}
+
+func TestSnippet_HighlightsCaseInsensitive(t *testing.T) {
+	got := snippet("AAPL quote is 150 <usd>", []string{"aapl", "usd"})
+	want := "<mark>AAPL</mark> quote is 150 &lt;<mark>usd</mark>&gt;"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestSnippet_TruncatesAroundFirstMatch(t *testing.T) {
+	text := strings.Repeat("a ", 100) + "needle" + strings.Repeat(" b", 100)
+	got := snippet(text, []string{"needle"})
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") {
+		t.Fatalf("expected ellipses on both sides, got %q", got)
+	}
+	if !strings.Contains(got, "<mark>needle</mark>") {
+		t.Fatalf("expected highlighted match, got %q", got)
+	}
+}
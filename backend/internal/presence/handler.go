@@ -0,0 +1,39 @@
+package presence
+
+import (
+	"chatapp/internal/auth"
+	"chatapp/internal/constants"
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(s Service) *Handler {
+	return &Handler{service: s}
+}
+
+func (h *Handler) RegisterRoutes(r *gin.Engine, jwtSecret string) {
+	group := r.Group(constants.APIv1 + "/chatroom")
+	group.Use(auth.AuthMiddleware(jwtSecret))
+	group.GET(":id/presence", h.room)
+}
+
+func (h *Handler) room(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	items, err := h.service.Room(ctx, c.GetString("uid"), c.Param("id"))
+	if err != nil {
+		if err == ErrNotMember {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load presence"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
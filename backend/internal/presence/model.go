@@ -0,0 +1,45 @@
+package presence
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+// Session is one live WebSocket connection of a user in a room. Sessions are owned by the
+// backend instance holding the connection, which refreshes SeenAt while it is alive; sessions
+// of crashed instances are swept by the instances still running.
+type Session struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	InstanceID  string             `bson:"instanceId"`
+	RoomID      string             `bson:"roomId"`
+	UserID      string             `bson:"userId"`
+	Status      string             `bson:"status"`
+	ConnectedAt time.Time          `bson:"connectedAt"`
+	SeenAt      time.Time          `bson:"seenAt"`
+}
+
+// UserPresence is the aggregated status of a user in a room across all their sessions.
+type UserPresence struct {
+	UserID string    `json:"userId"`
+	Status string    `json:"status"`
+	Since  time.Time `json:"since"`
+}
+
+// aggregate folds a user's sessions into one status: online wins over away, and no sessions is offline.
+func aggregate(sessions []Session) string {
+	status := StatusOffline
+	for _, s := range sessions {
+		if s.Status == StatusOnline {
+			return StatusOnline
+		}
+		status = StatusAway
+	}
+	return status
+}
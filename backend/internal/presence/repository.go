@@ -0,0 +1,105 @@
+package presence
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Repository interface {
+	Insert(ctx context.Context, s *Session) error
+	Delete(ctx context.Context, id primitive.ObjectID) (*Session, error)
+	SetStatus(ctx context.Context, id primitive.ObjectID, status string) (*Session, error)
+	Touch(ctx context.Context, instanceID string, at time.Time) error
+	ListStale(ctx context.Context, before time.Time) ([]Session, error)
+	DeleteStale(ctx context.Context, id primitive.ObjectID, before time.Time) (*Session, error)
+	ListByRoom(ctx context.Context, roomID string) ([]Session, error)
+	ListByUser(ctx context.Context, roomID string, userID string) ([]Session, error)
+}
+
+type mongoRepository struct {
+	col *mongo.Collection
+}
+
+func NewRepository(db *mongo.Database) Repository {
+	return &mongoRepository{col: db.Collection("presence_sessions")}
+}
+
+func (r *mongoRepository) Insert(ctx context.Context, s *Session) error {
+	res, err := r.col.InsertOne(ctx, s)
+	if err != nil {
+		return err
+	}
+	if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
+		s.ID = oid
+	}
+	return nil
+}
+
+// Delete removes a session and returns it so the caller knows which room and user it belonged to.
+func (r *mongoRepository) Delete(ctx context.Context, id primitive.ObjectID) (*Session, error) {
+	var s Session
+	if err := r.col.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// SetStatus updates a session's status and returns the session as it was before the update.
+func (r *mongoRepository) SetStatus(ctx context.Context, id primitive.ObjectID, status string) (*Session, error) {
+	var s Session
+	update := bson.M{"$set": bson.M{"status": status, "seenAt": time.Now().UTC()}}
+	if err := r.col.FindOneAndUpdate(ctx, bson.M{"_id": id}, update).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Touch keeps every session of an instance alive.
+func (r *mongoRepository) Touch(ctx context.Context, instanceID string, at time.Time) error {
+	_, err := r.col.UpdateMany(ctx, bson.M{"instanceId": instanceID}, bson.M{"$set": bson.M{"seenAt": at}})
+	return err
+}
+
+// ListStale returns the sessions last refreshed before the given time.
+func (r *mongoRepository) ListStale(ctx context.Context, before time.Time) ([]Session, error) {
+	return r.find(ctx, bson.M{"seenAt": bson.M{"$lt": before}})
+}
+
+// DeleteStale removes a session only if it has still not been refreshed since before, so a
+// session that came back to life, or one another instance swept first, is left alone.
+func (r *mongoRepository) DeleteStale(ctx context.Context, id primitive.ObjectID, before time.Time) (*Session, error) {
+	var s Session
+	if err := r.col.FindOneAndDelete(ctx, bson.M{"_id": id, "seenAt": bson.M{"$lt": before}}).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *mongoRepository) ListByRoom(ctx context.Context, roomID string) ([]Session, error) {
+	return r.find(ctx, bson.M{"roomId": roomID})
+}
+
+func (r *mongoRepository) ListByUser(ctx context.Context, roomID string, userID string) ([]Session, error) {
+	return r.find(ctx, bson.M{"roomId": roomID, "userId": userID})
+}
+
+func (r *mongoRepository) find(ctx context.Context, filter bson.M) ([]Session, error) {
+	cursor, err := r.col.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var result []Session
+	for cursor.Next(ctx) {
+		var s Session
+		if err := cursor.Decode(&s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, cursor.Err()
+}
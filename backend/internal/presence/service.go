@@ -0,0 +1,202 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// HeartbeatInterval is how often an instance refreshes its sessions and sweeps stale ones.
+const HeartbeatInterval = 30 * time.Second
+
+// StaleAfter is how long a session may go unrefreshed before it is swept, which takes three
+// missed heartbeats. The presence_sessions TTL index only catches what no sweep removed.
+const StaleAfter = 3 * HeartbeatInterval
+
+var ErrNotMember = errors.New("not a member of this chatroom")
+var ErrInvalidStatus = errors.New("invalid presence status")
+var ErrSessionNotFound = errors.New("presence session not found")
+
+// ChatRoomReader is the subset of the chatroom service used for access checks.
+type ChatRoomReader interface {
+	IsMember(ctx context.Context, roomID string, userID string) (bool, error)
+}
+
+// Notifier publishes a user's aggregated status in a room whenever it changes.
+type Notifier interface {
+	PresenceChanged(ctx context.Context, roomID string, userID string, status string) error
+}
+
+type Service interface {
+	Connect(ctx context.Context, roomID string, userID string) (string, error)
+	Disconnect(ctx context.Context, sessionID string) error
+	SetStatus(ctx context.Context, sessionID string, status string) error
+	Room(ctx context.Context, userID string, roomID string) ([]UserPresence, error)
+	Run(ctx context.Context)
+}
+
+type service struct {
+	repo       Repository
+	rooms      ChatRoomReader
+	notifier   Notifier
+	instanceID string
+}
+
+// NewService returns a presence service for this backend instance; sessions it opens are
+// tagged with a fresh instance ID so they can be kept alive by Run.
+func NewService(r Repository, rooms ChatRoomReader, n Notifier) Service {
+	return &service{repo: r, rooms: rooms, notifier: n, instanceID: primitive.NewObjectID().Hex()}
+}
+
+// Connect opens a session for a new connection and returns its ID.
+func (s *service) Connect(ctx context.Context, roomID string, userID string) (string, error) {
+	before, err := s.repo.ListByUser(ctx, roomID, userID)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	sess := Session{InstanceID: s.instanceID, RoomID: roomID, UserID: userID, Status: StatusOnline, ConnectedAt: now, SeenAt: now}
+	if err := s.repo.Insert(ctx, &sess); err != nil {
+		return "", err
+	}
+	s.publishIfChanged(ctx, roomID, userID, before, append(before, sess))
+	return sess.ID.Hex(), nil
+}
+
+// Disconnect closes a session; the user goes offline in the room once their last session is gone.
+func (s *service) Disconnect(ctx context.Context, sessionID string) error {
+	oid, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+	sess, err := s.repo.Delete(ctx, oid)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+	after, err := s.repo.ListByUser(ctx, sess.RoomID, sess.UserID)
+	if err != nil {
+		return err
+	}
+	s.publishIfChanged(ctx, sess.RoomID, sess.UserID, append(after, *sess), after)
+	return nil
+}
+
+// SetStatus switches a session between online and away.
+func (s *service) SetStatus(ctx context.Context, sessionID string, status string) error {
+	if status != StatusOnline && status != StatusAway {
+		return ErrInvalidStatus
+	}
+	oid, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+	prior, err := s.repo.SetStatus(ctx, oid, status)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+	after, err := s.repo.ListByUser(ctx, prior.RoomID, prior.UserID)
+	if err != nil {
+		return err
+	}
+	before := []Session{*prior}
+	for _, sess := range after {
+		if sess.ID != prior.ID {
+			before = append(before, sess)
+		}
+	}
+	s.publishIfChanged(ctx, prior.RoomID, prior.UserID, before, after)
+	return nil
+}
+
+// Room lists the users with at least one live session in the room.
+func (s *service) Room(ctx context.Context, userID string, roomID string) ([]UserPresence, error) {
+	ok, err := s.rooms.IsMember(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotMember
+	}
+	sessions, err := s.repo.ListByRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	byUser := make(map[string][]Session)
+	for _, sess := range sessions {
+		byUser[sess.UserID] = append(byUser[sess.UserID], sess)
+	}
+	resp := make([]UserPresence, 0, len(byUser))
+	for uid, list := range byUser {
+		p := UserPresence{UserID: uid, Status: aggregate(list), Since: list[0].ConnectedAt}
+		for _, sess := range list[1:] {
+			if sess.ConnectedAt.Before(p.Since) {
+				p.Since = sess.ConnectedAt
+			}
+		}
+		resp = append(resp, p)
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].UserID < resp[j].UserID })
+	return resp, nil
+}
+
+// Run refreshes this instance's sessions and sweeps the stale sessions of other instances every
+// HeartbeatInterval until ctx is done.
+func (s *service) Run(ctx context.Context) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now().UTC()
+			if err := s.repo.Touch(ctx, s.instanceID, now); err != nil {
+				log.Printf("presence: heartbeat failed: %v", err)
+			}
+			if err := s.sweep(ctx, now.Add(-StaleAfter)); err != nil {
+				log.Printf("presence: sweep failed: %v", err)
+			}
+		}
+	}
+}
+
+// sweep removes the sessions not refreshed since before, left behind by instances that stopped,
+// and announces the users who go offline as a result.
+func (s *service) sweep(ctx context.Context, before time.Time) error {
+	stale, err := s.repo.ListStale(ctx, before)
+	if err != nil {
+		return err
+	}
+	for _, sess := range stale {
+		removed, err := s.repo.DeleteStale(ctx, sess.ID, before)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Refreshed since it was listed, or swept by another instance.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		after, err := s.repo.ListByUser(ctx, removed.RoomID, removed.UserID)
+		if err != nil {
+			return err
+		}
+		s.publishIfChanged(ctx, removed.RoomID, removed.UserID, append(after, *removed), after)
+	}
+	return nil
+}
+
+// publishIfChanged notifies when the user's aggregated status differs between the two session sets.
+func (s *service) publishIfChanged(ctx context.Context, roomID string, userID string, before []Session, after []Session) {
+	from, to := aggregate(before), aggregate(after)
+	if from == to || s.notifier == nil {
+		return
+	}
+	if err := s.notifier.PresenceChanged(ctx, roomID, userID, to); err != nil {
+		log.Printf("presence: failed to publish %s for uid=%s room=%s: %v", to, userID, roomID, err)
+	}
+}
@@ -0,0 +1,197 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mockRepository implements Repository for unit tests
+type mockRepository struct {
+	sessions map[primitive.ObjectID]Session
+}
+
+func newMockRepository() *mockRepository {
+	return &mockRepository{sessions: make(map[primitive.ObjectID]Session)}
+}
+
+func (m *mockRepository) Insert(ctx context.Context, s *Session) error {
+	s.ID = primitive.NewObjectID()
+	m.sessions[s.ID] = *s
+	return nil
+}
+
+func (m *mockRepository) Delete(ctx context.Context, id primitive.ObjectID) (*Session, error) {
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	delete(m.sessions, id)
+	return &s, nil
+}
+
+func (m *mockRepository) SetStatus(ctx context.Context, id primitive.ObjectID, status string) (*Session, error) {
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	updated := s
+	updated.Status = status
+	m.sessions[id] = updated
+	return &s, nil
+}
+
+func (m *mockRepository) Touch(ctx context.Context, instanceID string, at time.Time) error {
+	for id, s := range m.sessions {
+		if s.InstanceID == instanceID {
+			s.SeenAt = at
+			m.sessions[id] = s
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) ListStale(ctx context.Context, before time.Time) ([]Session, error) {
+	var out []Session
+	for _, s := range m.sessions {
+		if s.SeenAt.Before(before) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockRepository) DeleteStale(ctx context.Context, id primitive.ObjectID, before time.Time) (*Session, error) {
+	s, ok := m.sessions[id]
+	if !ok || !s.SeenAt.Before(before) {
+		return nil, mongo.ErrNoDocuments
+	}
+	delete(m.sessions, id)
+	return &s, nil
+}
+
+func (m *mockRepository) ListByRoom(ctx context.Context, roomID string) ([]Session, error) {
+	var out []Session
+	for _, s := range m.sessions {
+		if s.RoomID == roomID {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockRepository) ListByUser(ctx context.Context, roomID string, userID string) ([]Session, error) {
+	var out []Session
+	for _, s := range m.sessions {
+		if s.RoomID == roomID && s.UserID == userID {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+type mockRooms struct {
+	members map[string]bool
+}
+
+func (m *mockRooms) IsMember(ctx context.Context, roomID string, userID string) (bool, error) {
+	return m.members[roomID+"/"+userID], nil
+}
+
+// mockNotifier records published statuses
+type mockNotifier struct {
+	statuses []string
+}
+
+func (m *mockNotifier) PresenceChanged(ctx context.Context, roomID string, userID string, status string) error {
+	m.statuses = append(m.statuses, userID+":"+status)
+	return nil
+}
+
+func TestService_PublishesOnlyAggregateChanges(t *testing.T) {
+	n := &mockNotifier{}
+	s := NewService(newMockRepository(), &mockRooms{}, n)
+	ctx := context.Background()
+
+	first, _ := s.Connect(ctx, "room1", "alice")
+	second, _ := s.Connect(ctx, "room1", "alice")
+	if err := s.SetStatus(ctx, first, StatusAway); err != nil {
+		t.Fatalf("set status: %v", err)
+	}
+	if err := s.SetStatus(ctx, second, StatusAway); err != nil {
+		t.Fatalf("set status: %v", err)
+	}
+	_ = s.Disconnect(ctx, first)
+	_ = s.Disconnect(ctx, second)
+
+	want := []string{"alice:online", "alice:away", "alice:offline"}
+	if len(n.statuses) != len(want) {
+		t.Fatalf("expected %v, got %v", want, n.statuses)
+	}
+	for i := range want {
+		if n.statuses[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, n.statuses)
+		}
+	}
+}
+
+func TestService_Sweep_AnnouncesUsersOfStoppedInstances(t *testing.T) {
+	repo := newMockRepository()
+	n := &mockNotifier{}
+	s := NewService(repo, &mockRooms{}, n)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	// alice and bob were connected through an instance that stopped; bob is still connected here.
+	old := now.Add(-2 * StaleAfter)
+	for _, uid := range []string{"alice", "bob"} {
+		_ = repo.Insert(ctx, &Session{InstanceID: "gone", RoomID: "room1", UserID: uid, Status: StatusOnline, ConnectedAt: old, SeenAt: old})
+	}
+	if _, err := s.Connect(ctx, "room1", "bob"); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	n.statuses = nil
+
+	if err := s.(*service).sweep(ctx, now.Add(-StaleAfter)); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	if len(n.statuses) != 1 || n.statuses[0] != "alice:offline" {
+		t.Fatalf("expected only alice to go offline, got %v", n.statuses)
+	}
+	if len(repo.sessions) != 1 {
+		t.Fatalf("expected only bob's live session to remain, got %+v", repo.sessions)
+	}
+	if err := s.(*service).sweep(ctx, now.Add(-StaleAfter)); err != nil || len(n.statuses) != 1 {
+		t.Fatalf("expected a second sweep to change nothing, got %v, %v", n.statuses, err)
+	}
+}
+
+func TestService_SetStatus_RejectsUnknownStatus(t *testing.T) {
+	s := NewService(newMockRepository(), &mockRooms{}, nil)
+	id, _ := s.Connect(context.Background(), "room1", "alice")
+	if err := s.SetStatus(context.Background(), id, StatusOffline); !errors.Is(err, ErrInvalidStatus) {
+		t.Fatalf("expected ErrInvalidStatus, got %v", err)
+	}
+}
+
+func TestService_Room_RequiresMembership(t *testing.T) {
+	rooms := &mockRooms{members: map[string]bool{"room1/bob": true}}
+	s := NewService(newMockRepository(), rooms, nil)
+	ctx := context.Background()
+	_, _ = s.Connect(ctx, "room1", "alice")
+
+	if _, err := s.Room(ctx, "carol", "room1"); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("expected ErrNotMember, got %v", err)
+	}
+	items, err := s.Room(ctx, "bob", "room1")
+	if err != nil {
+		t.Fatalf("room: %v", err)
+	}
+	if len(items) != 1 || items[0].UserID != "alice" || items[0].Status != StatusOnline {
+		t.Fatalf("expected alice online, got %+v", items)
+	}
+}
@@ -6,12 +6,14 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Repository interface {
 	Create(ctx context.Context, u *User) error
 	FindByEmail(ctx context.Context, email string) (*User, error)
 	FindByID(ctx context.Context, id string) (*User, error)
+	FindByNames(ctx context.Context, names []string) ([]User, error)
 }
 
 type mongoRepository struct {
@@ -52,3 +54,17 @@ func (r *mongoRepository) FindByID(ctx context.Context, id string) (*User, error
 	}
 	return &u, nil
 }
+
+// FindByNames returns the users whose name matches one of names, ignoring case.
+func (r *mongoRepository) FindByNames(ctx context.Context, names []string) ([]User, error) {
+	opts := options.Find().SetCollation(&options.Collation{Locale: "en", Strength: 2})
+	cur, err := r.col.Find(ctx, bson.M{"name": bson.M{"$in": names}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	if err := cur.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
@@ -39,6 +39,10 @@ func (m *mockRepository) FindByID(ctx context.Context, id string) (*User, error)
 	return nil, mongo.ErrNoDocuments
 }
 
+func (m *mockRepository) FindByNames(ctx context.Context, names []string) ([]User, error) {
+	return nil, nil
+}
+
 func TestService_Register_Success(t *testing.T) {
 	repo := &mockRepository{
 		createFn: func(ctx context.Context, u *User) error {
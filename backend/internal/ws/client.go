@@ -0,0 +1,309 @@
+package ws
+
+import (
+	"chatapp/internal/events"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// sendBuffer is how many frames may wait for a connection's writer before it counts as slow.
+	sendBuffer = 256
+	// maxPendingAcks bounds the submits a connection may have in flight.
+	maxPendingAcks = 64
+)
+
+// ackTimeout is how long a submit waits for its result before the client is told to resend it.
+// It outlasts the retries ingress makes before giving up on a submit.
+var ackTimeout = 45 * time.Second
+
+// pendingAck is a submit waiting for its result; timer answers it once ackTimeout has passed.
+type pendingAck struct {
+	roomID string
+	timer  *time.Timer
+}
+
+// client is one subscriber and the rooms it is subscribed to: a WebSocket connection, an SSE
+// stream or a pending long-poll (conn is nil for the latter two). All writes go through its
+// writer goroutine; everyone else only enqueues encoded frames.
+type client struct {
+	conn   *websocket.Conn
+	userID string
+	// ephemeral subscribers (long-polls) come and go between requests and open no presence session.
+	ephemeral bool
+	// defaultRoom is the room from the roomId query parameter, if any.
+	defaultRoom string
+
+	queue     chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	// closeCode and closeText are the close frame the writer sends once done is closed.
+	closeCode int
+	closeText string
+
+	mu sync.Mutex
+	// rooms maps each subscribed room to its presence session ID (empty if presence is off).
+	rooms      map[string]string
+	lastTyping map[string]time.Time
+	// pending holds live frames for rooms whose missed messages are still being replayed.
+	pending map[string][][]byte
+	// acks holds the submits waiting for their persistence result, by request ID.
+	acks map[string]pendingAck
+}
+
+func newClient(conn *websocket.Conn, userID string, defaultRoom string) *client {
+	return &client{
+		conn:        conn,
+		userID:      userID,
+		defaultRoom: defaultRoom,
+		queue:       make(chan []byte, sendBuffer),
+		done:        make(chan struct{}),
+		rooms:       make(map[string]string),
+		lastTyping:  make(map[string]time.Time),
+		pending:     make(map[string][][]byte),
+		acks:        make(map[string]pendingAck),
+	}
+}
+
+// send encodes an envelope and queues it for the writer.
+func (c *client) send(typ string, id string, payload any) error {
+	b, err := encode(typ, id, payload)
+	if err != nil {
+		return err
+	}
+	c.enqueue(b)
+	return nil
+}
+
+// expectAck records a submit to roomID awaiting persistence; it fails once maxPendingAcks are
+// outstanding. A retry of a pending id is accepted and answered once. A submit still pending after
+// ackTimeout is answered with a retryable error, so a lost result never holds its slot for good.
+func (c *client) expectAck(id string, roomID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.acks[id]; ok {
+		return true
+	}
+	if len(c.acks) >= maxPendingAcks {
+		return false
+	}
+	c.acks[id] = pendingAck{roomID: roomID, timer: time.AfterFunc(ackTimeout, func() {
+		if c.takeAck(id) {
+			_ = c.send("error", id, errorPayload{RoomID: roomID, Error: errAckTimeout.Error(), Retry: true})
+		}
+	})}
+	return true
+}
+
+// takeAck removes a pending submit and reports whether it was pending.
+func (c *client) takeAck(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.acks[id]
+	if ok {
+		p.timer.Stop()
+		delete(c.acks, id)
+	}
+	return ok
+}
+
+// dropAcks removes the pending submits to roomID and returns their IDs.
+func (c *client) dropAcks(roomID string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ids []string
+	for id, p := range c.acks {
+		if p.roomID == roomID {
+			p.timer.Stop()
+			delete(c.acks, id)
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// deliver queues a live frame for roomID, holding it back while that room is being replayed.
+func (c *client) deliver(roomID string, b []byte) {
+	c.mu.Lock()
+	if buf, ok := c.pending[roomID]; ok {
+		if len(buf) >= sendBuffer {
+			c.mu.Unlock()
+			log.Printf("ws: disconnecting slow consumer uid=%s during replay", c.userID)
+			c.close(websocket.ClosePolicyViolation, "slow consumer")
+			return
+		}
+		c.pending[roomID] = append(buf, b)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	c.enqueue(b)
+}
+
+// finishResume releases the live frames held back during a replay. message.created frames for
+// messages up to lastID were already replayed and are dropped; an empty lastID drops nothing.
+func (c *client) finishResume(roomID string, lastID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	buf, ok := c.pending[roomID]
+	if !ok {
+		return
+	}
+	delete(c.pending, roomID)
+	for _, b := range buf {
+		var env Envelope
+		var evt struct {
+			ID string `json:"id"`
+		}
+		if lastID != "" && json.Unmarshal(b, &env) == nil && env.Type == events.RKMessageCreated &&
+			json.Unmarshal(env.Payload, &evt) == nil && evt.ID <= lastID {
+			continue
+		}
+		c.enqueue(b)
+	}
+}
+
+// enqueue queues an encoded frame without blocking. A client whose queue is full is too slow to
+// keep up and is disconnected rather than allowed to hold up the rest of the room.
+func (c *client) enqueue(b []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.queue <- b:
+		return true
+	default:
+		log.Printf("ws: disconnecting slow consumer uid=%s", c.userID)
+		c.close(websocket.ClosePolicyViolation, "slow consumer")
+		return false
+	}
+}
+
+// close stops the writer, which sends a close frame with the given code and closes the connection.
+// Only the first call has an effect.
+func (c *client) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.done)
+	})
+}
+
+// writeLoop is the connection's only writer: gorilla/websocket allows a single concurrent writer.
+// It also pings the peer every PingInterval so the reader's deadline keeps moving while the
+// connection is healthy.
+func (c *client) writeLoop(l Limits) {
+	ticker := time.NewTicker(l.PingInterval)
+	defer func() {
+		ticker.Stop()
+		_ = c.conn.Close()
+	}()
+	for {
+		select {
+		case b := <-c.queue:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(l.WriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(l.WriteWait)); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.done:
+			if c.closeCode != websocket.CloseAbnormalClosure {
+				msg := websocket.FormatCloseMessage(c.closeCode, c.closeText)
+				_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(l.WriteWait))
+			}
+			return
+		}
+	}
+}
+
+// subscribe records roomID and reports whether it was newly added. With resume set, live frames
+// for the room are held back until finishResume.
+func (c *client) subscribe(roomID string, resume bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.rooms[roomID]; ok {
+		return false
+	}
+	c.rooms[roomID] = ""
+	if resume {
+		c.pending[roomID] = [][]byte{}
+	}
+	return true
+}
+
+// unsubscribe forgets roomID and returns its presence session.
+func (c *client) unsubscribe(roomID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sessionID, ok := c.rooms[roomID]
+	delete(c.rooms, roomID)
+	delete(c.lastTyping, roomID)
+	delete(c.pending, roomID)
+	return sessionID, ok
+}
+
+func (c *client) setSession(roomID string, sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.rooms[roomID]; ok {
+		c.rooms[roomID] = sessionID
+	}
+}
+
+func (c *client) isSubscribed(roomID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.rooms[roomID]
+	return ok
+}
+
+func (c *client) subscriptionCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.rooms)
+}
+
+func (c *client) subscriptions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]string, 0, len(c.rooms))
+	for id := range c.rooms {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// sessions returns the presence session for roomID, or for every subscription if roomID is empty.
+func (c *client) sessions(roomID string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ids []string
+	for id, sessionID := range c.rooms {
+		if sessionID != "" && (roomID == "" || id == roomID) {
+			ids = append(ids, sessionID)
+		}
+	}
+	return ids
+}
+
+// allowTyping rate-limits typing events to one per typingInterval per room.
+func (c *client) allowTyping(roomID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.lastTyping[roomID]) < typingInterval {
+		return false
+	}
+	c.lastTyping[roomID] = time.Now()
+	return true
+}
@@ -4,47 +4,171 @@ import (
 	"chatapp/internal/auth"
 	"chatapp/internal/config"
 	"chatapp/internal/constants"
+	"chatapp/internal/events"
+	"chatapp/internal/message"
 	"context"
+	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
+// typingInterval is the minimum gap between typing events forwarded for one connection and room.
+const typingInterval = 2 * time.Second
+
+const (
+	// replayPage is how many missed messages are sent per replay frame.
+	replayPage = 100
+	// maxReplay caps a resume; clients told the replay was truncated should reload history.
+	maxReplay = 1000
+)
+
+// maxSubscriptions bounds how many rooms a single connection may watch.
+const maxSubscriptions = 100
+
+// Limits are the keepalive and size thresholds applied to every connection.
+type Limits struct {
+	// PingInterval is how often the server pings; it must be shorter than PongWait.
+	PingInterval time.Duration
+	// PongWait is how long a connection may stay silent before it is considered dead.
+	PongWait time.Duration
+	// WriteWait bounds a single frame write, including pings and the final close frame.
+	WriteWait time.Duration
+	// MaxMessageBytes is the largest client frame accepted; bigger frames close the connection.
+	MaxMessageBytes int64
+}
+
+// DefaultLimits match the defaults of config.Load.
+var DefaultLimits = Limits{PingInterval: 30 * time.Second, PongWait: 60 * time.Second, WriteWait: 10 * time.Second, MaxMessageBytes: 16 * 1024}
+
+var errNotMember = errors.New("not a member of this chatroom")
+var errNotSubscribed = errors.New("not subscribed to this room")
+var errTooManySubscriptions = errors.New("too many subscriptions")
+var errUnsupportedVersion = errors.New("unsupported protocol version")
+var errUnknownType = errors.New("unknown frame type")
+var errMissingID = errors.New("id is required")
+var errTooManyPending = errors.New("too many unacknowledged messages")
+var errSubmitFailed = errors.New("message could not be queued")
+var errAckTimeout = errors.New("no result for this message yet")
+var errUnsubscribed = errors.New("unsubscribed before the message was acknowledged")
+
+// MessagePublisher hands client submissions and typing signals to the broker; *Publisher
+// implements it.
+type MessagePublisher interface {
+	SubmitMessage(ctx context.Context, s events.SubmitMessage) error
+	Typing(ctx context.Context, roomID string, userID string) error
+}
+
+// MembershipChecker reports whether a user may access a room.
+type MembershipChecker interface {
+	IsMember(ctx context.Context, roomID string, userID string) (bool, error)
+}
+
+// ReadMarker records a user's read position for "read" frames.
+type ReadMarker interface {
+	MarkRead(ctx context.Context, userID string, roomID string, msgID string) error
+}
+
+// History returns the messages of a room posted after a given message, oldest-first.
+type History interface {
+	Since(ctx context.Context, userID string, roomID string, afterID string, limit int64) ([]message.Message, error)
+}
+
+// PresenceTracker records connection sessions and explicit away/online status.
+type PresenceTracker interface {
+	Connect(ctx context.Context, roomID string, userID string) (string, error)
+	Disconnect(ctx context.Context, sessionID string) error
+	SetStatus(ctx context.Context, sessionID string, status string) error
+}
+
+// Hub tracks connected clients by the rooms they subscribe to. One connection can watch many rooms.
 type Hub struct {
 	mu       sync.RWMutex
-	byRoom   map[string]map[*websocket.Conn]struct{}
+	byRoom   map[string]map[*client]struct{}
 	upgrader websocket.Upgrader
-	pub      *Publisher
+	pub      MessagePublisher
+	rooms    MembershipChecker
+	reads    ReadMarker
+	presence PresenceTracker
+	history  History
+	limits   Limits
+	// waiters holds REST submits waiting for their result, keyed by resultKey.
+	waiters map[string]chan events.SubmitResult
 }
 
 func BuildHub() *Hub {
 	return &Hub{
-		byRoom: make(map[string]map[*websocket.Conn]struct{}),
+		byRoom:  make(map[string]map[*client]struct{}),
+		limits:  DefaultLimits,
+		waiters: make(map[string]chan events.SubmitResult),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
 	}
 }
 
-func (h *Hub) WithPublisher(p *Publisher) *Hub {
+func (h *Hub) WithPublisher(p MessagePublisher) *Hub {
 	h.pub = p
 	return h
 }
 
+func (h *Hub) WithMembership(m MembershipChecker) *Hub {
+	h.rooms = m
+	return h
+}
+
+func (h *Hub) WithReadMarker(m ReadMarker) *Hub {
+	h.reads = m
+	return h
+}
+
+func (h *Hub) WithPresence(p PresenceTracker) *Hub {
+	h.presence = p
+	return h
+}
+
+func (h *Hub) WithHistory(m History) *Hub {
+	h.history = m
+	return h
+}
+
+func (h *Hub) WithLimits(l Limits) *Hub {
+	h.limits = l
+	return h
+}
+
 func (h *Hub) RegisterRoutes(r *gin.Engine, cfg config.AppConfig) {
 	group := r.Group(constants.APIv1 + "/ws")
 	group.Use(auth.WebSocketAuthMiddleware(cfg.JWTSecret))
 	group.GET("", h.handleWS)
+	h.registerFallbackRoutes(r, cfg)
 }
 
+// handleWS upgrades an authenticated connection. The optional roomId query parameter subscribes
+// the connection to that room up front and makes it the default target of frames without a roomId;
+// lastMessageId then replays what the client missed in that room before live delivery resumes.
 func (h *Hub) handleWS(c *gin.Context) {
 	roomID := c.Query("roomId")
-	if roomID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "roomId is required"})
-		return
+	uid := c.GetString("uid")
+	if roomID != "" {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+		err := h.checkMember(ctx, roomID, uid)
+		cancel()
+		if errors.Is(err, errNotMember) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check membership"})
+			return
+		}
 	}
 	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -52,63 +176,395 @@ func (h *Hub) handleWS(c *gin.Context) {
 		return
 	}
 
-	h.addConn(roomID, conn)
-	uid := c.GetString("uid")
-	log.Printf("ws connected uid=%s room=%s", uid, roomID)
+	cl := newClient(conn, uid, roomID)
+	log.Printf("ws connected uid=%s", uid)
+	go cl.writeLoop(h.limits)
+	if roomID != "" {
+		lastID := c.Query("lastMessageId")
+		if h.subscribe(cl, roomID, lastID) {
+			if err := h.replay(cl, roomID, lastID); err != nil {
+				_ = cl.send("error", "", errorPayload{RoomID: roomID, Error: err.Error()})
+			}
+		}
+	}
 
-	go h.readLoop(roomID, conn, uid)
+	go h.readLoop(cl)
 }
 
-func (h *Hub) addConn(roomID string, conn *websocket.Conn) {
+func (h *Hub) checkMember(ctx context.Context, roomID string, userID string) error {
+	if h.rooms == nil {
+		return nil
+	}
+	ok, err := h.rooms.IsMember(ctx, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNotMember
+	}
+	return nil
+}
+
+// subscribe adds cl to a room's fan-out and opens a presence session for it. It reports whether
+// the client was newly subscribed and, when lastID is set, that a replay must follow.
+// Callers must have checked membership.
+func (h *Hub) subscribe(cl *client, roomID string, lastID string) bool {
+	resume := lastID != "" && h.history != nil
+	if !cl.subscribe(roomID, resume) {
+		return false
+	}
 	h.mu.Lock()
-	defer h.mu.Unlock()
 	set, ok := h.byRoom[roomID]
 	if !ok {
-		set = make(map[*websocket.Conn]struct{})
+		set = make(map[*client]struct{})
 		h.byRoom[roomID] = set
 	}
-	set[conn] = struct{}{}
+	set[cl] = struct{}{}
+	h.mu.Unlock()
+
+	if h.presence != nil && !cl.ephemeral {
+		ctx, cancel := context.WithTimeout(context.Background(), constants.TIMEOUT_SECONDS)
+		sessionID, err := h.presence.Connect(ctx, roomID, cl.userID)
+		cancel()
+		if err != nil {
+			log.Printf("ws: presence connect failed uid=%s room=%s: %v", cl.userID, roomID, err)
+			return resume
+		}
+		cl.setSession(roomID, sessionID)
+	}
+	return resume
 }
 
-func (h *Hub) removeConn(roomID string, conn *websocket.Conn) {
+// replay sends the messages posted after afterID, then releases the live frames held back since
+// the subscription. Live message.created frames already covered by the replay are dropped, so
+// the client sees every message exactly once.
+func (h *Hub) replay(cl *client, roomID string, afterID string) error {
+	last := afterID
+	defer func() { cl.finishResume(roomID, last) }()
+
+	truncated := false
+	for sent := 0; ; {
+		ctx, cancel := context.WithTimeout(context.Background(), constants.TIMEOUT_SECONDS)
+		items, err := h.history.Since(ctx, cl.userID, roomID, last, replayPage)
+		cancel()
+		if err != nil {
+			// Nothing reliable was replayed past this point, so release every held-back frame.
+			last = ""
+			return err
+		}
+		if len(items) == 0 {
+			break
+		}
+		if err := cl.send("replay", "", replayPayload{RoomID: roomID, Items: items}); err != nil {
+			return err
+		}
+		last = items[len(items)-1].ID.Hex()
+		sent += len(items)
+		if len(items) < replayPage {
+			break
+		}
+		if sent >= maxReplay {
+			truncated = true
+			break
+		}
+	}
+	return cl.send("resumed", "", resumedPayload{RoomID: roomID, LastMessageID: last, Truncated: truncated})
+}
+
+// unsubscribe removes cl from a room's fan-out and closes its presence session there.
+func (h *Hub) unsubscribe(cl *client, roomID string) {
+	sessionID, ok := cl.unsubscribe(roomID)
+	if !ok {
+		return
+	}
+	// Results for this room no longer reach the client; answer its pending submits now.
+	for _, id := range cl.dropAcks(roomID) {
+		_ = cl.send("error", id, errorPayload{RoomID: roomID, Error: errUnsubscribed.Error()})
+	}
 	h.mu.Lock()
-	defer h.mu.Unlock()
 	if set, ok := h.byRoom[roomID]; ok {
-		delete(set, conn)
+		delete(set, cl)
 		if len(set) == 0 {
 			delete(h.byRoom, roomID)
 		}
 	}
+	h.mu.Unlock()
+
+	if h.presence != nil && sessionID != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), constants.TIMEOUT_SECONDS)
+		_ = h.presence.Disconnect(ctx, sessionID)
+		cancel()
+	}
 }
 
-// Broadcast sends JSON payload to all connections in a room.
-func (h *Hub) Broadcast(roomID string, payload any) {
+// RemoveMember drops every subscription userID holds to roomID on this instance, after they left
+// or were kicked. WebSocket connections stay open for their other rooms; SSE streams and
+// long-polls, which serve a single room, are closed.
+func (h *Hub) RemoveMember(roomID string, userID string) {
 	h.mu.RLock()
-	set := h.byRoom[roomID]
+	var removed []*client
+	for cl := range h.byRoom[roomID] {
+		if cl.userID == userID {
+			removed = append(removed, cl)
+		}
+	}
 	h.mu.RUnlock()
-	for conn := range set {
-		_ = conn.WriteJSON(payload)
+	for _, cl := range removed {
+		h.unsubscribe(cl, roomID)
+		if cl.conn == nil {
+			cl.close(0, "")
+		}
+	}
+}
+
+// Acknowledge hands a submit result to whoever on this instance is waiting for it: a REST submit,
+// or the sender's connection, which gets an "ack" carrying the message ID or an "error".
+func (h *Hub) Acknowledge(res events.SubmitResult) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if wait, ok := h.waiters[resultKey(res.UserID, res.ClientMsgID)]; ok {
+		select {
+		case wait <- res:
+		default:
+		}
+	}
+	for cl := range h.byRoom[res.RoomID] {
+		if cl.userID != res.UserID || !cl.takeAck(res.ClientMsgID) {
+			continue
+		}
+		if res.Error != "" {
+			_ = cl.send("error", res.ClientMsgID, errorPayload{RoomID: res.RoomID, Error: res.Error, Retry: res.Temporary})
+		} else {
+			_ = cl.send("ack", res.ClientMsgID, ackPayload{RoomID: res.RoomID, MessageID: res.MessageID})
+		}
+		return
+	}
+}
+
+// resultKey identifies a submit across transports; it is also the submit's idempotency key.
+func resultKey(userID string, clientMsgID string) string {
+	return userID + ":" + clientMsgID
+}
+
+// awaitResult registers interest in the result of a submit. The returned function unregisters it.
+func (h *Hub) awaitResult(userID string, clientMsgID string) (<-chan events.SubmitResult, func()) {
+	key := resultKey(userID, clientMsgID)
+	wait := make(chan events.SubmitResult, 1)
+	h.mu.Lock()
+	h.waiters[key] = wait
+	h.mu.Unlock()
+	return wait, func() {
+		h.mu.Lock()
+		if h.waiters[key] == wait {
+			delete(h.waiters, key)
+		}
+		h.mu.Unlock()
 	}
 }
 
-// readLoop currently drains frames and closes on error; producer/consumer wiring next.
-type clientSubmit struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+// Broadcast sends JSON payload to all connections subscribed to a room.
+func (h *Hub) Broadcast(roomID string, payload any) {
+	h.BroadcastExcept(roomID, "", payload)
 }
 
-func (h *Hub) readLoop(roomID string, conn *websocket.Conn, userID string) {
+// BroadcastToUsers sends JSON payload, an event of roomID, to every connection of the given users
+// on this instance, whether or not it is subscribed to the room.
+func (h *Hub) BroadcastToUsers(roomID string, userIDs []string, payload any) {
+	b, err := encodeBroadcast(payload)
+	if err != nil {
+		log.Printf("ws: failed to encode broadcast for room=%s: %v", roomID, err)
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	sent := make(map[*client]bool)
+	for _, set := range h.byRoom {
+		for cl := range set {
+			if !sent[cl] && slices.Contains(userIDs, cl.userID) {
+				sent[cl] = true
+				cl.deliver(roomID, b)
+			}
+		}
+	}
+}
+
+// BroadcastExcept sends JSON payload to the subscribers of a room that do not belong to userID.
+// The payload's "event" field becomes the envelope type. The frame is encoded once and queued per
+// connection; it never waits on network I/O.
+func (h *Hub) BroadcastExcept(roomID string, userID string, payload any) {
+	b, err := encodeBroadcast(payload)
+	if err != nil {
+		log.Printf("ws: failed to encode broadcast for room=%s: %v", roomID, err)
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for cl := range h.byRoom[roomID] {
+		if userID == "" || cl.userID != userID {
+			cl.deliver(roomID, b)
+		}
+	}
+}
+
+// readLoop reads client frames until the connection closes, times out or misbehaves. Any frame,
+// including the pong answering the writer's ping, pushes the read deadline out by PongWait, so
+// half-open connections are reaped once they go silent.
+func (h *Hub) readLoop(cl *client) {
+	code := websocket.CloseNormalClosure
 	defer func() {
-		h.removeConn(roomID, conn)
-		_ = conn.Close()
+		for _, roomID := range cl.subscriptions() {
+			h.unsubscribe(cl, roomID)
+		}
+		cl.close(code, "")
 	}()
+
+	conn := cl.conn
+	conn.SetReadLimit(h.limits.MaxMessageBytes)
+	extend := func() { _ = conn.SetReadDeadline(time.Now().Add(h.limits.PongWait)) }
+	extend()
+	conn.SetPongHandler(func(string) error {
+		extend()
+		return nil
+	})
+	// Let the writer answer the peer's close frame so it is the only goroutine writing frames.
+	conn.SetCloseHandler(func(c int, _ string) error {
+		code = c
+		if c == websocket.CloseNoStatusReceived {
+			code = websocket.CloseNormalClosure
+		}
+		return nil
+	})
+
 	for {
-		var in clientSubmit
-		if err := conn.ReadJSON(&in); err != nil {
-			break
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			var closeErr *websocket.CloseError
+			switch {
+			case errors.As(err, &closeErr):
+				// The close handler recorded the peer's code; the writer echoes it.
+			case errors.Is(err, websocket.ErrReadLimit):
+				// gorilla/websocket has already sent 1009 (message too big).
+				log.Printf("ws: closing connection with oversized frame uid=%s", cl.userID)
+				code = websocket.CloseAbnormalClosure
+			case errors.As(err, &netErr) && netErr.Timeout():
+				log.Printf("ws: reaping idle connection uid=%s", cl.userID)
+				code = websocket.CloseAbnormalClosure
+			default:
+				code = websocket.CloseAbnormalClosure
+			}
+			return
+		}
+		extend()
+		var env Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			_ = cl.send("error", "", errorPayload{Error: "invalid frame"})
+			continue
+		}
+		if env.V != ProtocolVersion {
+			_ = cl.send("error", env.ID, errorPayload{Error: errUnsupportedVersion.Error()})
+			continue
+		}
+		var req requestPayload
+		if len(env.Payload) > 0 {
+			if err := json.Unmarshal(env.Payload, &req); err != nil {
+				_ = cl.send("error", env.ID, errorPayload{Error: "invalid payload"})
+				continue
+			}
+		}
+		roomID := req.RoomID
+		if roomID == "" {
+			roomID = cl.defaultRoom
+		}
+		if err := h.handleRequest(cl, env.Type, env.ID, roomID, req); err != nil {
+			_ = cl.send("error", env.ID, errorPayload{RoomID: roomID, Error: err.Error()})
+		}
+	}
+}
+
+// handleRequest serves one client request. Requests that carry an ID are answered with exactly one
+// "ack" or "error" envelope echoing it; a submit is acknowledged only once it has been persisted.
+func (h *Hub) handleRequest(cl *client, typ string, id string, roomID string, req requestPayload) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	ack := func() error {
+		if id == "" {
+			return nil
+		}
+		return cl.send("ack", id, ackPayload{RoomID: roomID})
+	}
+
+	switch typ {
+	case "subscribe":
+		if cl.isSubscribed(roomID) {
+			return ack()
+		}
+		if cl.subscriptionCount() >= maxSubscriptions {
+			return errTooManySubscriptions
+		}
+		if err := h.checkMember(ctx, roomID, cl.userID); err != nil {
+			if !errors.Is(err, errNotMember) {
+				log.Printf("ws: membership check failed uid=%s room=%s: %v", cl.userID, roomID, err)
+			}
+			return errNotMember
+		}
+		resume := h.subscribe(cl, roomID, req.LastMessageID)
+		if err := ack(); err != nil || !resume {
+			return err
+		}
+		if err := h.replay(cl, roomID, req.LastMessageID); err != nil {
+			// The subscription stands; report the failed replay without the request ID.
+			return cl.send("error", "", errorPayload{RoomID: roomID, Error: err.Error()})
+		}
+		return nil
+	case "unsubscribe":
+		h.unsubscribe(cl, roomID)
+		return ack()
+	case "presence":
+		if h.presence != nil {
+			for _, sessionID := range cl.sessions(req.RoomID) {
+				if err := h.presence.SetStatus(ctx, sessionID, req.Status); err != nil {
+					return err
+				}
+			}
+		}
+		return ack()
+	case "submit", "typing", "read":
+	default:
+		return errUnknownType
+	}
+
+	if !cl.isSubscribed(roomID) {
+		return errNotSubscribed
+	}
+	switch {
+	case typ == "submit" && h.pub != nil:
+		if id == "" {
+			return errMissingID
+		}
+		if !cl.expectAck(id, roomID) {
+			return errTooManyPending
+		}
+		// Scoping the key to the user lets a client retry with the same id, even after reconnecting,
+		// without the message being stored twice.
+		s := events.SubmitMessage{RoomID: roomID, UserID: cl.userID, Text: req.Text, ParentID: req.ParentID, ClientMsgID: id, IdempotencyKey: resultKey(cl.userID, id)}
+		if err := h.pub.SubmitMessage(ctx, s); err != nil {
+			// The broker did not confirm the submit, so nothing was stored; the client may resend it.
+			log.Printf("ws: submit uid=%s room=%s not queued: %v", cl.userID, roomID, err)
+			cl.takeAck(id)
+			return cl.send("error", id, errorPayload{RoomID: roomID, Error: errSubmitFailed.Error(), Retry: true})
+		}
+		return nil
+	case typ == "typing" && h.pub != nil:
+		if cl.allowTyping(roomID) {
+			if err := h.pub.Typing(ctx, roomID, cl.userID); err != nil {
+				return err
+			}
 		}
-		if in.Type == "submit" && h.pub != nil {
-			_ = h.pub.SubmitMessage(context.Background(), roomID, userID, in.Text)
+	case typ == "read" && h.reads != nil:
+		if err := h.reads.MarkRead(ctx, cl.userID, roomID, req.MessageID); err != nil {
+			return err
 		}
 	}
+	return ack()
 }
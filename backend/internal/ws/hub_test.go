@@ -0,0 +1,442 @@
+package ws
+
+import (
+	"chatapp/internal/events"
+	"chatapp/internal/message"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestHub_BroadcastExcept_SkipsSender(t *testing.T) {
+	h := BuildHub()
+	alice := newClient(nil, "alice", "")
+	bob := newClient(nil, "bob", "")
+	h.subscribe(alice, "room1", "")
+	h.subscribe(bob, "room1", "")
+
+	h.BroadcastExcept("room1", "alice", map[string]string{"event": "user.typing"})
+
+	if len(alice.queue) != 0 {
+		t.Fatalf("expected the sender to be skipped")
+	}
+	if len(bob.queue) != 1 {
+		t.Fatalf("expected one queued frame for bob, got %d", len(bob.queue))
+	}
+}
+
+// loopbackPublisher counts typing signals and, when hub is set, delivers them straight back to it
+// the way TypingConsumer would.
+type loopbackPublisher struct {
+	mu     sync.Mutex
+	typing int
+	hub    *Hub
+}
+
+func (p *loopbackPublisher) SubmitMessage(ctx context.Context, s events.SubmitMessage) error {
+	return nil
+}
+
+func (p *loopbackPublisher) Typing(ctx context.Context, roomID string, userID string) error {
+	p.mu.Lock()
+	p.typing++
+	p.mu.Unlock()
+	if p.hub != nil {
+		p.hub.BroadcastExcept(roomID, userID, events.UserTyping{Event: events.RKUserTyping, RoomID: roomID, UserID: userID})
+	}
+	return nil
+}
+
+func (p *loopbackPublisher) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.typing
+}
+
+// sendFrame writes a frame for roomID and waits for its ack.
+func sendFrame(t *testing.T, conn *websocket.Conn, typ string, id string, roomID string) {
+	t.Helper()
+	if err := conn.WriteJSON(Envelope{V: ProtocolVersion, Type: typ, ID: id, Payload: json.RawMessage(`{"roomId":"` + roomID + `"}`)}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if env := readEnvelope(t, conn); env.Type != "ack" || env.ID != id {
+		t.Fatalf("expected ack for %s, got %s %q", id, env.Type, env.ID)
+	}
+}
+
+func TestHub_Typing_RateLimitedPerConnectionAndRoom(t *testing.T) {
+	pub := &loopbackPublisher{}
+	h := BuildHub().WithPublisher(pub)
+	first := dialTestHub(t, h, "alice")
+	second := dialTestHub(t, h, "alice")
+	for _, conn := range []*websocket.Conn{first, second} {
+		sendFrame(t, conn, "subscribe", "s1", "room1")
+	}
+	sendFrame(t, first, "subscribe", "s2", "room2")
+
+	// Every frame is acknowledged, but a burst in one room is forwarded only once.
+	for i := 0; i < 3; i++ {
+		sendFrame(t, first, "typing", "t1", "room1")
+	}
+	if n := pub.count(); n != 1 {
+		t.Fatalf("expected one typing signal for the burst, got %d", n)
+	}
+	sendFrame(t, first, "typing", "t2", "room2")
+	sendFrame(t, second, "typing", "t3", "room1")
+	if n := pub.count(); n != 3 {
+		t.Fatalf("expected other rooms and connections to be limited separately, got %d signals", n)
+	}
+}
+
+func TestHub_Typing_ReachesOthersButNotTheTypist(t *testing.T) {
+	pub := &loopbackPublisher{}
+	h := BuildHub().WithPublisher(pub)
+	pub.hub = h
+	alice := dialTestHub(t, h, "alice")
+	bob := dialTestHub(t, h, "bob")
+	sendFrame(t, alice, "subscribe", "s1", "room1")
+	sendFrame(t, bob, "subscribe", "s1", "room1")
+
+	sendFrame(t, alice, "typing", "t1", "room1")
+
+	env := readEnvelope(t, bob)
+	var evt events.UserTyping
+	_ = json.Unmarshal(env.Payload, &evt)
+	if env.Type != events.RKUserTyping || evt.UserID != "alice" {
+		t.Fatalf("expected bob to see alice typing, got %s %+v", env.Type, evt)
+	}
+	// Anything queued for alice would arrive ahead of this marker.
+	h.Broadcast("room1", map[string]string{"event": events.RKMessageCreated})
+	if env := readEnvelope(t, alice); env.Type != events.RKMessageCreated {
+		t.Fatalf("expected alice not to receive her own typing event, got %s", env.Type)
+	}
+}
+
+func TestHub_RemoveMember_DropsOnlyThatUsersSubscriptions(t *testing.T) {
+	h := BuildHub()
+	conn := dialTestHub(t, h, "bob")
+	for _, room := range []string{"room1", "room2"} {
+		if err := conn.WriteJSON(Envelope{V: ProtocolVersion, Type: "subscribe", ID: "s-" + room, Payload: json.RawMessage(`{"roomId":"` + room + `"}`)}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if env := readEnvelope(t, conn); env.Type != "ack" {
+			t.Fatalf("expected subscribe ack, got %s", env.Type)
+		}
+	}
+	var bobWS *client
+	h.mu.RLock()
+	for cl := range h.byRoom["room1"] {
+		bobWS = cl
+	}
+	h.mu.RUnlock()
+	bobStream := newClient(nil, "bob", "room1")
+	alice := newClient(nil, "alice", "room1")
+	h.subscribe(bobStream, "room1", "")
+	h.subscribe(alice, "room1", "")
+
+	h.RemoveMember("room1", "bob")
+
+	if bobWS.isSubscribed("room1") || !bobWS.isSubscribed("room2") {
+		t.Fatalf("expected the connection to leave room1 only, got %v", bobWS.subscriptions())
+	}
+	select {
+	case <-bobWS.done:
+		t.Fatal("expected the websocket to stay open")
+	default:
+	}
+	select {
+	case <-bobStream.done:
+	default:
+		t.Fatal("expected the single-room stream to be closed")
+	}
+	h.Broadcast("room1", map[string]string{"event": "message.created"})
+	if len(alice.queue) != 1 {
+		t.Fatalf("expected alice to keep receiving room1, got %d frames", len(alice.queue))
+	}
+}
+
+func TestHub_BroadcastToUsers_ReachesOnlyThoseUsers(t *testing.T) {
+	h := BuildHub()
+	alice := newClient(nil, "alice", "")
+	bob := newClient(nil, "bob", "")
+	h.subscribe(alice, "room1", "")
+	h.subscribe(bob, "room1", "")
+	h.subscribe(bob, "room2", "")
+
+	h.BroadcastToUsers("room1", []string{"bob"}, map[string]string{"event": "user.mentioned"})
+
+	if len(alice.queue) != 0 {
+		t.Fatalf("expected alice not to be notified")
+	}
+	if len(bob.queue) != 1 {
+		t.Fatalf("expected one frame for bob across his subscriptions, got %d", len(bob.queue))
+	}
+}
+
+func TestHub_Broadcast_DisconnectsSlowConsumerWithoutBlocking(t *testing.T) {
+	h := BuildHub()
+	slow := newClient(nil, "slow", "")
+	fast := newClient(nil, "fast", "")
+	h.subscribe(slow, "room1", "")
+	h.subscribe(fast, "room1", "")
+
+	finished := make(chan struct{})
+	go func() {
+		for i := 0; i < sendBuffer+1; i++ {
+			h.Broadcast("room1", i)
+			<-fast.queue
+		}
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("broadcast blocked on a full send queue")
+	}
+
+	select {
+	case <-slow.done:
+	default:
+		t.Fatal("expected the slow consumer to be closed")
+	}
+	select {
+	case <-fast.done:
+		t.Fatal("expected the fast consumer to stay connected")
+	default:
+	}
+}
+
+// stubHistory serves a fixed, ordered message list.
+type stubHistory struct {
+	messages []message.Message
+}
+
+func (s *stubHistory) Since(ctx context.Context, userID, roomID, afterID string, limit int64) ([]message.Message, error) {
+	var out []message.Message
+	for _, m := range s.messages {
+		if m.ID.Hex() > afterID && int64(len(out)) < limit {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+func TestHub_Resume_ReplaysThenDeliversLiveWithoutDuplicates(t *testing.T) {
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+	history := &stubHistory{}
+	for _, id := range ids[:2] {
+		history.messages = append(history.messages, message.Message{ID: id, RoomID: "room1"})
+	}
+	h := BuildHub().WithHistory(history)
+	cl := newClient(nil, "alice", "")
+
+	if !h.subscribe(cl, "room1", ids[0].Hex()) {
+		t.Fatal("expected a replay to be required")
+	}
+	// Live events racing the replay: one already persisted (and replayed), one genuinely new.
+	h.Broadcast("room1", map[string]string{"event": events.RKMessageCreated, "id": ids[1].Hex()})
+	h.Broadcast("room1", map[string]string{"event": events.RKMessageCreated, "id": ids[2].Hex()})
+	if len(cl.queue) != 0 {
+		t.Fatal("expected live frames to be held back during the replay")
+	}
+	if err := h.replay(cl, "room1", ids[0].Hex()); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+
+	var got []string
+	for len(cl.queue) > 0 {
+		var env Envelope
+		_ = json.Unmarshal(<-cl.queue, &env)
+		var f struct {
+			ID    string            `json:"id"`
+			Items []message.Message `json:"items"`
+		}
+		_ = json.Unmarshal(env.Payload, &f)
+		switch env.Type {
+		case "replay":
+			for _, m := range f.Items {
+				got = append(got, "replay:"+m.ID.Hex())
+			}
+		case "resumed":
+			got = append(got, "resumed")
+		default:
+			got = append(got, "live:"+f.ID)
+		}
+	}
+	want := []string{"replay:" + ids[1].Hex(), "resumed", "live:" + ids[2].Hex()}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+// readEnvelope reads the next frame from conn.
+func readEnvelope(t *testing.T, conn *websocket.Conn) Envelope {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var env Envelope
+	if err := conn.ReadJSON(&env); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return env
+}
+
+func TestHub_RejectsUnsupportedVersion(t *testing.T) {
+	conn := dialTestHub(t, BuildHub(), "alice")
+	if err := conn.WriteJSON(Envelope{V: ProtocolVersion + 1, Type: "subscribe", ID: "r1"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	env := readEnvelope(t, conn)
+	if env.Type != "error" || env.ID != "r1" {
+		t.Fatalf("expected an error echoing r1, got %s %q", env.Type, env.ID)
+	}
+}
+
+func TestHub_Submit_AckedOnceResultArrives(t *testing.T) {
+	h := BuildHub()
+	conn := dialTestHub(t, h, "alice")
+	if err := conn.WriteJSON(Envelope{V: ProtocolVersion, Type: "subscribe", ID: "s1", Payload: json.RawMessage(`{"roomId":"room1"}`)}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if env := readEnvelope(t, conn); env.Type != "ack" || env.ID != "s1" {
+		t.Fatalf("expected ack for s1, got %s %q", env.Type, env.ID)
+	}
+
+	var cl *client
+	h.mu.RLock()
+	for c := range h.byRoom["room1"] {
+		cl = c
+	}
+	h.mu.RUnlock()
+	if !cl.expectAck("m1", "room1") {
+		t.Fatal("expected m1 to be registered")
+	}
+	h.Acknowledge(events.SubmitResult{RoomID: "room1", UserID: "bob", ClientMsgID: "m1", MessageID: "abc"})
+	h.Acknowledge(events.SubmitResult{RoomID: "room1", UserID: "alice", ClientMsgID: "m1", MessageID: "abc"})
+	h.Acknowledge(events.SubmitResult{RoomID: "room1", UserID: "alice", ClientMsgID: "m1", MessageID: "abc"})
+
+	env := readEnvelope(t, conn)
+	var ack ackPayload
+	_ = json.Unmarshal(env.Payload, &ack)
+	if env.Type != "ack" || env.ID != "m1" || ack.MessageID != "abc" {
+		t.Fatalf("expected ack for m1 with message abc, got %s %q %+v", env.Type, env.ID, ack)
+	}
+	if cl.takeAck("m1") {
+		t.Fatal("expected m1 to be acknowledged exactly once")
+	}
+}
+
+func TestHub_Submit_PendingAcksExpireAndClearOnUnsubscribe(t *testing.T) {
+	defer func(d time.Duration) { ackTimeout = d }(ackTimeout)
+	ackTimeout = 50 * time.Millisecond
+	h := BuildHub()
+	conn := dialTestHub(t, h, "alice")
+	for _, room := range []string{"room1", "room2"} {
+		if err := conn.WriteJSON(Envelope{V: ProtocolVersion, Type: "subscribe", ID: "s-" + room, Payload: json.RawMessage(`{"roomId":"` + room + `"}`)}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if env := readEnvelope(t, conn); env.Type != "ack" {
+			t.Fatalf("expected subscribe ack, got %s", env.Type)
+		}
+	}
+	var cl *client
+	h.mu.RLock()
+	for c := range h.byRoom["room1"] {
+		cl = c
+	}
+	h.mu.RUnlock()
+
+	cl.expectAck("m1", "room1")
+	env := readEnvelope(t, conn)
+	var p errorPayload
+	_ = json.Unmarshal(env.Payload, &p)
+	if env.Type != "error" || env.ID != "m1" || !p.Retry {
+		t.Fatalf("expected a retryable error for the expired submit, got %s %q %+v", env.Type, env.ID, p)
+	}
+
+	ackTimeout = time.Minute
+	cl.expectAck("m2", "room2")
+	if err := conn.WriteJSON(Envelope{V: ProtocolVersion, Type: "unsubscribe", ID: "u", Payload: json.RawMessage(`{"roomId":"room2"}`)}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if env := readEnvelope(t, conn); env.Type != "error" || env.ID != "m2" {
+		t.Fatalf("expected the pending submit to be answered on unsubscribe, got %s %q", env.Type, env.ID)
+	}
+	if env := readEnvelope(t, conn); env.Type != "ack" || env.ID != "u" {
+		t.Fatalf("expected unsubscribe ack, got %s %q", env.Type, env.ID)
+	}
+	if cl.takeAck("m2") {
+		t.Fatal("expected m2 to be cleared on unsubscribe")
+	}
+}
+
+// dialTestHub serves h on a test server, authenticating every connection as uid.
+func dialTestHub(t *testing.T, h *Hub, uid string) *websocket.Conn {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/ws", func(c *gin.Context) {
+		c.Set("uid", uid)
+		h.handleWS(c)
+	})
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestHub_ClosesOversizedFrames(t *testing.T) {
+	h := BuildHub().WithLimits(Limits{PingInterval: time.Minute, PongWait: time.Minute, WriteWait: time.Second, MaxMessageBytes: 64})
+	conn := dialTestHub(t, h, "alice")
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 128))); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("expected close 1009, got %v", err)
+	}
+}
+
+func TestHub_ReapsSilentConnections(t *testing.T) {
+	h := BuildHub().WithLimits(Limits{PingInterval: 50 * time.Millisecond, PongWait: 150 * time.Millisecond, WriteWait: time.Second, MaxMessageBytes: 1024})
+	conn := dialTestHub(t, h, "alice")
+	if err := conn.WriteJSON(Envelope{V: ProtocolVersion, Type: "subscribe", Payload: json.RawMessage(`{"roomId":"room1"}`)}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	subscribers := func() int {
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		return len(h.byRoom["room1"])
+	}
+	waitFor := func(want int) bool {
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+			if subscribers() == want {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+	if !waitFor(1) {
+		t.Fatal("expected the connection to subscribe")
+	}
+	// Not reading means the client never answers pings, like a half-open connection.
+	if waitFor(0) {
+		return
+	}
+	t.Fatal("expected the silent connection to be removed from its room")
+}
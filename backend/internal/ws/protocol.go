@@ -0,0 +1,83 @@
+package ws
+
+import (
+	"chatapp/internal/message"
+	"encoding/json"
+)
+
+// ProtocolVersion is the envelope version spoken on /ws. Frames with another version are rejected.
+const ProtocolVersion = 1
+
+// Envelope wraps every frame in both directions. Type names the frame; events pushed by the
+// server use their routing key (e.g. "message.created"). ID is chosen by the client on requests
+// and echoed on the single "ack" or "error" that answers it.
+type Envelope struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// requestPayload is the payload of client requests. RoomID selects the target room; it defaults
+// to the room given in the connection's roomId query parameter.
+type requestPayload struct {
+	RoomID   string `json:"roomId,omitempty"`
+	Text     string `json:"text,omitempty"`
+	ParentID string `json:"parentId,omitempty"`
+	// MessageID is the read position carried by "read" requests.
+	MessageID string `json:"messageId,omitempty"`
+	// Status is "away" or "online" in "presence" requests; without a roomId it applies to every subscription.
+	Status string `json:"status,omitempty"`
+	// LastMessageID in "subscribe" requests replays the messages posted after it.
+	LastMessageID string `json:"lastMessageId,omitempty"`
+}
+
+// ackPayload answers a successful request. MessageID is set once a submitted message is persisted.
+type ackPayload struct {
+	RoomID    string `json:"roomId,omitempty"`
+	MessageID string `json:"messageId,omitempty"`
+}
+
+// errorPayload answers a failed request, or reports a frame that could not be parsed.
+type errorPayload struct {
+	RoomID string `json:"roomId,omitempty"`
+	Error  string `json:"error"`
+	// Retry is set when the failure was transient and resending the request with the same ID is safe.
+	Retry bool `json:"retry,omitempty"`
+}
+
+// replayPayload carries a page of messages a resuming client missed, oldest-first.
+type replayPayload struct {
+	RoomID string            `json:"roomId"`
+	Items  []message.Message `json:"items"`
+}
+
+// resumedPayload ends a replay. Truncated means more than maxReplay messages were missed and the
+// client should reload the room history instead.
+type resumedPayload struct {
+	RoomID        string `json:"roomId"`
+	LastMessageID string `json:"lastMessageId"`
+	Truncated     bool   `json:"truncated,omitempty"`
+}
+
+// encode builds an envelope frame around payload.
+func encode(typ string, id string, payload any) ([]byte, error) {
+	p, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{V: ProtocolVersion, Type: typ, ID: id, Payload: p})
+}
+
+// encodeBroadcast wraps a room event in an envelope whose type is the event's "event" field.
+func encodeBroadcast(payload any) ([]byte, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var head struct {
+		Event string `json:"event"`
+	}
+	_ = json.Unmarshal(raw, &head)
+	return encode(head.Event, "", json.RawMessage(raw))
+}
@@ -4,17 +4,34 @@ import (
 	"chatapp/internal/events"
 	"context"
 	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Publisher struct {
 	AMQP *events.AMQP
 }
 
-func (p *Publisher) SubmitMessage(ctx context.Context, roomID string, userID string, text string) error {
-	payload := events.SubmitMessage{RoomID: roomID, UserID: userID, Text: text}
-	b, err := json.Marshal(payload)
+// SubmitMessage queues a message for ingress. Submits without an idempotency key get a fresh one,
+// so at least a redelivery of this publish cannot store the message twice.
+func (p *Publisher) SubmitMessage(ctx context.Context, s events.SubmitMessage) error {
+	if s.IdempotencyKey == "" {
+		s.IdempotencyKey = primitive.NewObjectID().Hex()
+	}
+	b, err := json.Marshal(s)
 	if err != nil {
 		return err
 	}
 	return p.AMQP.PublishJSON(ctx, events.RKMessageSubmit, b)
 }
+
+// Typing announces that userID is composing a message. It is published transiently and never persisted.
+func (p *Publisher) Typing(ctx context.Context, roomID string, userID string) error {
+	payload := events.UserTyping{Event: events.RKUserTyping, RoomID: roomID, UserID: userID, At: time.Now().UTC()}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return p.AMQP.PublishTransientJSON(ctx, events.RKUserTyping, b)
+}
@@ -0,0 +1,201 @@
+package ws
+
+import (
+	"chatapp/internal/events"
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// TestHubs_EveryInstanceReceivesEveryEvent runs two hubs in one process, each with its own
+// BroadcastConsumer, against a real broker: both see every room event, while a submit result only
+// reaches the hub holding the sender's connection. It is skipped unless AMQP_TEST_URI is set.
+func TestHubs_EveryInstanceReceivesEveryEvent(t *testing.T) {
+	uri := os.Getenv("AMQP_TEST_URI")
+	if uri == "" {
+		t.Skip("AMQP_TEST_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	exchange := "chat.events.test." + time.Now().Format("150405.000")
+
+	var conns []*websocket.Conn
+	for _, uid := range []string{"alice", "bob"} {
+		amq, err := events.NewAMQP(ctx, uri, exchange)
+		if err != nil {
+			t.Fatalf("amqp: %v", err)
+		}
+		t.Cleanup(amq.Close)
+		h := BuildHub().WithPublisher(&Publisher{AMQP: amq})
+		if err := (&events.BroadcastConsumer{AMQP: amq, Hub: h}).Start(ctx); err != nil {
+			t.Fatalf("start consumer: %v", err)
+		}
+		conn := dialTestHub(t, h, uid)
+		if err := conn.WriteJSON(Envelope{V: ProtocolVersion, Type: "subscribe", ID: "s", Payload: json.RawMessage(`{"roomId":"room1"}`)}); err != nil {
+			t.Fatalf("subscribe: %v", err)
+		}
+		if env := readEnvelope(t, conn); env.Type != "ack" {
+			t.Fatalf("expected subscribe ack, got %s", env.Type)
+		}
+		conns = append(conns, conn)
+	}
+	// Stand in for ingress: capture alice's submit so its result is published only once the first
+	// hub is waiting for it.
+	raw, err := amqp.Dial(uri)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = raw.Close() })
+	ch, err := raw.Channel()
+	if err != nil {
+		t.Fatalf("channel: %v", err)
+	}
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		t.Fatalf("declare: %v", err)
+	}
+	if err := ch.QueueBind(q.Name, events.RKMessageSubmit, exchange, false, nil); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	submits, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		t.Fatalf("consume: %v", err)
+	}
+	if err := conns[0].WriteJSON(Envelope{V: ProtocolVersion, Type: "submit", ID: "m1", Payload: json.RawMessage(`{"roomId":"room1","text":"hi"}`)}); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	select {
+	case <-submits:
+	case <-ctx.Done():
+		t.Fatal("submit was not published")
+	}
+
+	amq, err := events.NewAMQP(ctx, uri, exchange)
+	if err != nil {
+		t.Fatalf("amqp: %v", err)
+	}
+	t.Cleanup(amq.Close)
+	for i := 0; i < 4; i++ {
+		b, _ := json.Marshal(events.MessageCreated{Event: events.RKMessageCreated, ID: strconv.Itoa(i), RoomID: "room1"})
+		if err := amq.PublishJSON(ctx, events.RKMessageCreated, b); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	b, _ := json.Marshal(events.SubmitResult{Event: events.RKSubmitResult, RoomID: "room1", UserID: "alice", ClientMsgID: "m1", MessageID: "0"})
+	if err := amq.PublishJSON(ctx, events.RKSubmitResult, b); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	for i, conn := range conns {
+		created := 0
+		acks := 0
+		for created < 4 || (i == 0 && acks == 0) {
+			switch env := readEnvelope(t, conn); env.Type {
+			case events.RKMessageCreated:
+				created++
+			case "ack":
+				acks++
+			}
+		}
+		if created != 4 {
+			t.Fatalf("connection %d: expected all 4 events, got %d", i, created)
+		}
+	}
+	// The submit result reaches only the instance holding the sender's connection.
+	_ = conns[1].SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := conns[1].ReadMessage(); err == nil {
+		t.Fatal("expected no further frames on the second hub")
+	}
+}
+
+// memoryBus stands in for the broker and the per-instance BroadcastConsumers: every event is
+// handed to every hub, as each instance's own broadcast queue would.
+type memoryBus struct {
+	hubs []events.Broadcaster
+}
+
+var _ events.Broadcaster = (*memoryBus)(nil)
+
+func (b *memoryBus) Broadcast(roomID string, payload any) {
+	for _, h := range b.hubs {
+		h.Broadcast(roomID, payload)
+	}
+}
+
+func (b *memoryBus) Acknowledge(res events.SubmitResult) {
+	for _, h := range b.hubs {
+		h.Acknowledge(res)
+	}
+}
+
+func (b *memoryBus) RemoveMember(roomID string, userID string) {
+	for _, h := range b.hubs {
+		h.RemoveMember(roomID, userID)
+	}
+}
+
+func (b *memoryBus) BroadcastToUsers(roomID string, userIDs []string, payload any) {
+	for _, h := range b.hubs {
+		h.BroadcastToUsers(roomID, userIDs, payload)
+	}
+}
+
+// busIngress stands in for the ingress consumer: a submit is stored at once and announced on the bus.
+type busIngress struct {
+	bus *memoryBus
+}
+
+func (p *busIngress) SubmitMessage(ctx context.Context, s events.SubmitMessage) error {
+	p.bus.Broadcast(s.RoomID, events.MessageCreated{Event: events.RKMessageCreated, ID: s.ClientMsgID, RoomID: s.RoomID, UserID: s.UserID, Text: s.Text})
+	p.bus.Acknowledge(events.SubmitResult{Event: events.RKSubmitResult, RoomID: s.RoomID, UserID: s.UserID, ClientMsgID: s.ClientMsgID, MessageID: s.ClientMsgID})
+	return nil
+}
+
+func (p *busIngress) Typing(ctx context.Context, roomID string, userID string) error {
+	return nil
+}
+
+// TestHubs_FanOutThroughInMemoryBus is the broker-free counterpart of
+// TestHubs_EveryInstanceReceivesEveryEvent.
+func TestHubs_FanOutThroughInMemoryBus(t *testing.T) {
+	bus := &memoryBus{}
+	ingress := &busIngress{bus: bus}
+	var conns []*websocket.Conn
+	for _, uid := range []string{"alice", "bob"} {
+		h := BuildHub().WithPublisher(ingress)
+		bus.hubs = append(bus.hubs, h)
+		conn := dialTestHub(t, h, uid)
+		sendFrame(t, conn, "subscribe", "s", "room1")
+		conns = append(conns, conn)
+	}
+
+	if err := conns[0].WriteJSON(Envelope{V: ProtocolVersion, Type: "submit", ID: "m1", Payload: json.RawMessage(`{"roomId":"room1","text":"hi"}`)}); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	for i, conn := range conns {
+		env := readEnvelope(t, conn)
+		if env.Type != events.RKMessageCreated {
+			t.Fatalf("connection %d: expected message.created, got %s", i, env.Type)
+		}
+	}
+	if env := readEnvelope(t, conns[0]); env.Type != "ack" || env.ID != "m1" {
+		t.Fatalf("expected the sender's hub to acknowledge m1, got %s %q", env.Type, env.ID)
+	}
+
+	bus.RemoveMember("room1", "bob")
+	bus.Broadcast("room1", events.MessageCreated{Event: events.RKMessageCreated, ID: "2", RoomID: "room1"})
+	if env := readEnvelope(t, conns[0]); env.Type != events.RKMessageCreated {
+		t.Fatalf("expected alice to keep receiving room1, got %s", env.Type)
+	}
+	// Neither the result of alice's submit nor events after bob's removal reach the second hub.
+	_ = conns[1].SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := conns[1].ReadMessage(); err == nil {
+		t.Fatal("expected no further frames on the second hub")
+	}
+}
@@ -0,0 +1,298 @@
+package ws
+
+import (
+	"chatapp/internal/auth"
+	"chatapp/internal/config"
+	"chatapp/internal/constants"
+	"chatapp/internal/events"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// pollTimeout is how long a long-poll waits for the first event before returning empty-handed.
+// It stays below common proxy idle timeouts.
+const pollTimeout = 25 * time.Second
+
+// submitWait is how long a REST submit waits for the message to be stored before answering 202.
+const submitWait = 5 * time.Second
+
+// pollLinger collects events arriving right after the first one, so bursts share a response.
+const pollLinger = 50 * time.Millisecond
+
+// pollResponse is the body of a long-poll: the same envelopes a WebSocket receives, and the
+// cursor to pass as lastMessageId on the next poll.
+type pollResponse struct {
+	Events        []json.RawMessage `json:"events"`
+	LastMessageID string            `json:"lastMessageId,omitempty"`
+}
+
+// submitRequest is the body of a REST submit. ClientMsgID plays the role of the WebSocket request
+// ID: retries with the same value are stored once, whichever transport they use.
+type submitRequest struct {
+	Text        string `json:"text"`
+	ParentID    string `json:"parentId,omitempty"`
+	ClientMsgID string `json:"clientMsgId,omitempty"`
+}
+
+// registerFallbackRoutes serves rooms to clients that cannot open a WebSocket: an SSE stream, a
+// long-poll and a REST submit. Stream and poll subscribers are registered in the hub like
+// WebSocket connections, so every broadcast reaches all transports alike.
+func (h *Hub) registerFallbackRoutes(r *gin.Engine, cfg config.AppConfig) {
+	// EventSource cannot set headers, so the stream also accepts the token as a query parameter.
+	stream := r.Group(constants.APIv1 + "/rooms")
+	stream.Use(auth.WebSocketAuthMiddleware(cfg.JWTSecret))
+	stream.GET(":id/stream", h.handleStream)
+
+	group := r.Group(constants.APIv1 + "/rooms")
+	group.Use(auth.AuthMiddleware(cfg.JWTSecret))
+	group.GET(":id/poll", h.handlePoll)
+	group.POST(":id/messages", h.handleSubmit)
+}
+
+// requireMember aborts the request unless the caller belongs to the room.
+func (h *Hub) requireMember(c *gin.Context, roomID string, uid string) bool {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	err := h.checkMember(ctx, roomID, uid)
+	if errors.Is(err, errNotMember) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check membership"})
+		return false
+	}
+	return true
+}
+
+// handleStream serves a room as Server-Sent Events. Each event's data is an envelope as sent over
+// the WebSocket; events that advance the message cursor carry it as the SSE id, so a browser
+// reconnecting with Last-Event-ID resumes without gaps or duplicates.
+func (h *Hub) handleStream(c *gin.Context) {
+	roomID := c.Param("id")
+	uid := c.GetString("uid")
+	if !h.requireMember(c, roomID, uid) {
+		return
+	}
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("lastMessageId")
+	}
+
+	cl := newClient(nil, uid, roomID)
+	resume := h.subscribe(cl, roomID, lastID)
+	defer func() {
+		h.unsubscribe(cl, roomID)
+		cl.close(0, "")
+	}()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Keep reverse proxies such as nginx from buffering the stream.
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+	log.Printf("sse connected uid=%s room=%s", uid, roomID)
+
+	if resume {
+		go func() {
+			if err := h.replay(cl, roomID, lastID); err != nil {
+				_ = cl.send("error", "", errorPayload{RoomID: roomID, Error: err.Error()})
+			}
+		}()
+	}
+
+	rc := http.NewResponseController(c.Writer)
+	ticker := time.NewTicker(h.limits.PingInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case b := <-cl.queue:
+			_ = rc.SetWriteDeadline(time.Now().Add(h.limits.WriteWait))
+			err = writeSSE(c.Writer, b)
+		case <-ticker.C:
+			// A comment line keeps idle proxies from closing the stream.
+			_ = rc.SetWriteDeadline(time.Now().Add(h.limits.WriteWait))
+			_, err = fmt.Fprint(c.Writer, ": ping\n\n")
+		case <-cl.done:
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeSSE writes one frame as an SSE event.
+func writeSSE(w gin.ResponseWriter, b []byte) error {
+	var sb strings.Builder
+	if id := frameCursor(b); id != "" {
+		sb.WriteString("id: " + id + "\n")
+	}
+	sb.WriteString("data: ")
+	sb.Write(b)
+	sb.WriteString("\n\n")
+	_, err := w.WriteString(sb.String())
+	return err
+}
+
+// handlePoll serves a room as long-polls. A poll subscribes like a WebSocket would, replays the
+// messages posted after lastMessageId and otherwise waits up to pollTimeout for live events.
+// Only messages are recovered between polls; other events are delivered while a poll is open.
+func (h *Hub) handlePoll(c *gin.Context) {
+	roomID := c.Param("id")
+	uid := c.GetString("uid")
+	if !h.requireMember(c, roomID, uid) {
+		return
+	}
+	lastID := c.Query("lastMessageId")
+
+	cl := newClient(nil, uid, roomID)
+	cl.ephemeral = true
+	resume := h.subscribe(cl, roomID, lastID)
+	defer func() {
+		h.unsubscribe(cl, roomID)
+		cl.close(0, "")
+	}()
+	if resume {
+		if err := h.replay(cl, roomID, lastID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load missed messages"})
+			return
+		}
+	}
+
+	resp := pollResponse{Events: []json.RawMessage{}, LastMessageID: lastID}
+	collect := func(b []byte) bool {
+		if id := frameCursor(b); id != "" {
+			resp.LastMessageID = id
+		}
+		// A resume that replayed nothing, and was not cut short, is no reason to answer yet.
+		var env Envelope
+		var p resumedPayload
+		if json.Unmarshal(b, &env) == nil && env.Type == "resumed" && len(resp.Events) == 0 &&
+			json.Unmarshal(env.Payload, &p) == nil && !p.Truncated {
+			return false
+		}
+		resp.Events = append(resp.Events, b)
+		return true
+	}
+
+	timeout := time.NewTimer(pollTimeout)
+	defer timeout.Stop()
+	var linger <-chan time.Time
+	for {
+		select {
+		case b := <-cl.queue:
+			if collect(b) && linger == nil {
+				linger = time.After(pollLinger)
+			}
+			continue
+		case <-linger:
+		case <-timeout.C:
+		case <-cl.done:
+			// Too far behind; the client polls again from its cursor.
+		case <-c.Request.Context().Done():
+			return
+		}
+		break
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleSubmit posts a message over REST through the same pipeline as the WebSocket submit and
+// answers 201 with the stored message. If storing takes longer than submitWait, or the text was a
+// bot command, it answers 202 with the clientMsgId, which the message.created event carries too.
+func (h *Hub) handleSubmit(c *gin.Context) {
+	roomID := c.Param("id")
+	uid := c.GetString("uid")
+	var req submitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "text is required"})
+		return
+	}
+	if h.pub == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "messaging unavailable"})
+		return
+	}
+	if !h.requireMember(c, roomID, uid) {
+		return
+	}
+	if req.ClientMsgID == "" {
+		req.ClientMsgID = primitive.NewObjectID().Hex()
+	}
+
+	wait, stop := h.awaitResult(uid, req.ClientMsgID)
+	defer stop()
+	ctx, cancel := context.WithTimeout(c.Request.Context(), constants.TIMEOUT_SECONDS)
+	defer cancel()
+	s := events.SubmitMessage{RoomID: roomID, UserID: uid, Text: req.Text, ParentID: req.ParentID, ClientMsgID: req.ClientMsgID, IdempotencyKey: resultKey(uid, req.ClientMsgID)}
+	if err := h.pub.SubmitMessage(ctx, s); err != nil {
+		log.Printf("ws: rest submit uid=%s room=%s not queued: %v", uid, roomID, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errSubmitFailed.Error()})
+		return
+	}
+
+	timer := time.NewTimer(submitWait)
+	defer timer.Stop()
+	select {
+	case res := <-wait:
+		switch {
+		case res.Temporary:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": res.Error})
+		case res.Error != "":
+			c.JSON(http.StatusBadRequest, gin.H{"error": res.Error})
+		case res.Message != nil:
+			c.JSON(http.StatusCreated, res.Message)
+		default:
+			c.JSON(http.StatusAccepted, gin.H{"clientMsgId": req.ClientMsgID})
+		}
+	case <-timer.C:
+		c.JSON(http.StatusAccepted, gin.H{"clientMsgId": req.ClientMsgID})
+	case <-c.Request.Context().Done():
+	}
+}
+
+// frameCursor returns the last message ID a frame covers: the ID of a created message, the last
+// replayed message, or the position a resume ended at. Other frames return "".
+func frameCursor(b []byte) string {
+	var env Envelope
+	if json.Unmarshal(b, &env) != nil {
+		return ""
+	}
+	switch env.Type {
+	case events.RKMessageCreated:
+		var evt struct {
+			ID string `json:"id"`
+		}
+		_ = json.Unmarshal(env.Payload, &evt)
+		return evt.ID
+	case "replay":
+		var p replayPayload
+		if json.Unmarshal(env.Payload, &p) == nil && len(p.Items) > 0 {
+			return p.Items[len(p.Items)-1].ID.Hex()
+		}
+	case "resumed":
+		var p resumedPayload
+		_ = json.Unmarshal(env.Payload, &p)
+		return p.LastMessageID
+	}
+	return ""
+}
@@ -0,0 +1,205 @@
+package ws
+
+import (
+	"bufio"
+	"chatapp/internal/events"
+	"chatapp/internal/message"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// serveFallback serves the SSE, long-poll and REST submit handlers of h, authenticating every request as uid.
+func serveFallback(t *testing.T, h *Hub, uid string) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("uid", uid) })
+	r.GET("/rooms/:id/stream", h.handleStream)
+	r.GET("/rooms/:id/poll", h.handlePoll)
+	r.POST("/rooms/:id/messages", h.handleSubmit)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// waitSubscribers waits until n subscribers are registered for roomID.
+func waitSubscribers(t *testing.T, h *Hub, roomID string, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		h.mu.RLock()
+		got := len(h.byRoom[roomID])
+		h.mu.RUnlock()
+		if got == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d subscribers in %s", n, roomID)
+}
+
+func TestHub_Stream_DeliversBroadcastsAsSSE(t *testing.T) {
+	h := BuildHub()
+	srv := serveFallback(t, h, "alice")
+
+	resp, err := http.Get(srv.URL + "/rooms/room1/stream")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+	waitSubscribers(t, h, "room1", 1)
+
+	id := primitive.NewObjectID().Hex()
+	h.Broadcast("room1", events.MessageCreated{Event: events.RKMessageCreated, ID: id, RoomID: "room1", Text: "hi"})
+
+	lines := bufio.NewScanner(resp.Body)
+	var got []string
+	for len(got) < 2 && lines.Scan() {
+		if line := lines.Text(); line != "" {
+			got = append(got, line)
+		}
+	}
+	if len(got) != 2 || got[0] != "id: "+id || !strings.HasPrefix(got[1], "data: ") {
+		t.Fatalf("unexpected event %q", got)
+	}
+	var env Envelope
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(got[1], "data: ")), &env); err != nil || env.Type != events.RKMessageCreated {
+		t.Fatalf("expected a message.created envelope, got %s (%v)", got[1], err)
+	}
+
+	resp.Body.Close()
+	waitSubscribers(t, h, "room1", 0)
+}
+
+func poll(t *testing.T, url string) pollResponse {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var out pollResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return out
+}
+
+func TestHub_Poll_ReturnsMissedMessagesImmediately(t *testing.T) {
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+	history := &stubHistory{messages: []message.Message{{ID: ids[0], RoomID: "room1"}, {ID: ids[1], RoomID: "room1"}}}
+	h := BuildHub().WithHistory(history)
+	srv := serveFallback(t, h, "alice")
+
+	out := poll(t, srv.URL+"/rooms/room1/poll?lastMessageId="+ids[0].Hex())
+	if out.LastMessageID != ids[1].Hex() {
+		t.Fatalf("expected cursor %s, got %s", ids[1].Hex(), out.LastMessageID)
+	}
+	var env Envelope
+	if len(out.Events) == 0 || json.Unmarshal(out.Events[0], &env) != nil || env.Type != "replay" {
+		t.Fatalf("expected a replay first, got %s", out.Events)
+	}
+	waitSubscribers(t, h, "room1", 0)
+}
+
+func TestHub_Poll_WaitsForLiveMessage(t *testing.T) {
+	last := primitive.NewObjectID()
+	h := BuildHub().WithHistory(&stubHistory{})
+	srv := serveFallback(t, h, "alice")
+
+	done := make(chan pollResponse, 1)
+	go func() { done <- poll(t, srv.URL+"/rooms/room1/poll?lastMessageId="+last.Hex()) }()
+	waitSubscribers(t, h, "room1", 1)
+	select {
+	case <-done:
+		t.Fatal("expected the poll to wait for an event")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	id := primitive.NewObjectID().Hex()
+	h.Broadcast("room1", events.MessageCreated{Event: events.RKMessageCreated, ID: id, RoomID: "room1"})
+	select {
+	case out := <-done:
+		if out.LastMessageID != id || len(out.Events) != 1 {
+			t.Fatalf("expected the live message, got %+v", out)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("poll did not return the live message")
+	}
+}
+
+// stubPublisher answers every submit the way ingress would, through the hub's Acknowledge.
+type stubPublisher struct {
+	h      *Hub
+	result func(s events.SubmitMessage) events.SubmitResult
+}
+
+func (p *stubPublisher) SubmitMessage(ctx context.Context, s events.SubmitMessage) error {
+	res := p.result(s)
+	res.RoomID, res.UserID, res.ClientMsgID = s.RoomID, s.UserID, s.ClientMsgID
+	go p.h.Acknowledge(res)
+	return nil
+}
+
+func (p *stubPublisher) Typing(ctx context.Context, roomID string, userID string) error {
+	return nil
+}
+
+type stubMembership map[string]bool
+
+func (m stubMembership) IsMember(ctx context.Context, roomID string, userID string) (bool, error) {
+	return m[roomID+"/"+userID], nil
+}
+
+func TestHub_Submit_REST(t *testing.T) {
+	h := BuildHub().WithMembership(stubMembership{"room1/alice": true})
+	id := primitive.NewObjectID()
+	h.WithPublisher(&stubPublisher{h: h, result: func(s events.SubmitMessage) events.SubmitResult {
+		if s.Text == "bad" {
+			return events.SubmitResult{Error: "parent message not found"}
+		}
+		if s.IdempotencyKey != "alice:"+s.ClientMsgID {
+			t.Errorf("unexpected idempotency key %q", s.IdempotencyKey)
+		}
+		return events.SubmitResult{MessageID: id.Hex(), Message: &message.Message{ID: id, RoomID: s.RoomID, Text: s.Text}}
+	}})
+	srv := serveFallback(t, h, "alice")
+
+	post := func(room string, body string) (*http.Response, map[string]any) {
+		resp, err := http.Post(srv.URL+"/rooms/"+room+"/messages", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp, out
+	}
+
+	resp, out := post("room1", `{"text":"hi","clientMsgId":"c1"}`)
+	if resp.StatusCode != http.StatusCreated || out["id"] != id.Hex() {
+		t.Fatalf("expected 201 with the stored message, got %d %v", resp.StatusCode, out)
+	}
+	if resp, _ := post("room1", `{"text":"bad"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a rejected submit, got %d", resp.StatusCode)
+	}
+	if resp, _ := post("room1", `{"text":"  "}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for empty text, got %d", resp.StatusCode)
+	}
+	if resp, _ := post("room2", `{"text":"hi"}`); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 outside the room, got %d", resp.StatusCode)
+	}
+}
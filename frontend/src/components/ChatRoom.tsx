@@ -6,6 +6,7 @@ import { ArrowLeft, Users, RefreshCw, Wifi, WifiOff } from 'lucide-react'
 import { MessageList } from './MessageList'
 // eslint-disable-next-line @typescript-eslint/no-unused-vars
 import { MessageInput } from './MessageInput'
+import { ThreadPanel } from './ThreadPanel'
 import { useMessages } from '@/contexts/MessageContext'
 import { useChatRooms } from '@/contexts/ChatRoomContext'
 import type { Message } from '@/contexts/MessageContext'
@@ -14,14 +15,15 @@ import { useAuth } from '@/contexts/AuthContext'
 export function ChatRoom() {
   const { roomId } = useParams<{ roomId: string }>()
   const navigate = useNavigate()
-  const { loadMessages, clearMessages, isLoading, addRealTimeMessage, registerWebSocket, unregisterWebSocket } = useMessages()
-  const { chatRooms } = useChatRooms()
+  const { loadMessages, clearMessages, isLoading, addRealTimeMessage, applyMessageUpdate, applyMessageDeletion, applyReactions, resolveSubmit, registerWebSocket, unregisterWebSocket } = useMessages()
+  const { chatRooms, joinChatRoom } = useChatRooms()
   const { user } = useAuth()
   const loadedRoomRef = useRef<string | null>(null)
   const wsRef = useRef<WebSocket | null>(null)
   const reconnectTimeoutRef = useRef<number | null>(null)
   const [isConnected, setIsConnected] = useState(false)
   const [connectionAttempts, setConnectionAttempts] = useState(0)
+  const [joinError, setJoinError] = useState<string | null>(null)
 
   // Find the current room details
   const currentRoom = chatRooms.find(room => room.id === roomId)
@@ -86,16 +88,6 @@ export function ChatRoom() {
       console.log('WebSocket connected for room:', roomId)
       clearTimeout(connectionTimeout)
 
-      // Send JWT token as first message for authentication (fallback)
-      const token = localStorage.getItem('accessToken')
-      if (token) {
-        console.log('Sending JWT token for authentication')
-        ws.send(JSON.stringify({
-          type: 'auth',
-          token: token
-        }))
-      }
-
       // Register this WebSocket connection for sending messages
       if (roomId) {
         registerWebSocket(roomId, ws)
@@ -109,19 +101,38 @@ export function ChatRoom() {
       console.log('Raw WebSocket message received:', event.data)
 
       try {
-        const messageData = JSON.parse(event.data)
-        console.log('Parsed WebSocket message:', messageData)
+        const frame = JSON.parse(event.data)
+        // Server frames are versioned envelopes; room events travel in their payload
+        const messageData = frame.v ? frame.payload : frame
+        console.log('Parsed WebSocket message:', frame.type, messageData)
+
+        // Answers to our submits: settle the pending message and surface failures
+        if (frame.type === 'ack' || frame.type === 'error') {
+          if (frame.id) {
+            resolveSubmit(frame.id, frame.type === 'error' ? messageData?.error || 'unknown error' : undefined, messageData?.retry)
+          } else if (frame.type === 'error') {
+            console.error('WebSocket error frame:', messageData?.error)
+          }
+          return
+        }
+
+        const eventType = frame.type || messageData.event
+
+        // Edits replace the text of a message we already show
+        if (eventType === 'message.updated') {
+          applyMessageUpdate({ id: messageData.id, text: messageData.text, editedAt: messageData.editedAt })
+          return
+        }
 
-        // Handle authentication response
-        if (messageData.type === 'auth' && messageData.status === 'success') {
-          console.log('Authentication successful')
+        // Deleted messages become tombstones
+        if (eventType === 'message.deleted') {
+          applyMessageDeletion(messageData.id, messageData.deletedAt)
           return
         }
 
-        // Handle authentication error
-        if (messageData.type === 'auth' && messageData.status === 'error') {
-          console.error('Authentication failed:', messageData.error)
-          ws.close()
+        // Reaction changes carry the message's whole reaction summary
+        if (eventType === 'message.reaction') {
+          applyReactions(messageData.id, messageData.reactions || [])
           return
         }
 
@@ -136,7 +147,8 @@ export function ChatRoom() {
             userName: messageData.userName || 'Bot', // Default to 'Bot' if empty
             text: messageData.text,
             type: messageData.type || 'message',
-            createdAt: messageData.createdAt
+            createdAt: messageData.createdAt,
+            parentId: messageData.parentId || undefined
           }
 
           // Add the real-time message to the context
@@ -177,7 +189,7 @@ export function ChatRoom() {
     }
 
     wsRef.current = ws
-  }, [roomId, user, getWebSocketUrl, addRealTimeMessage, connectionAttempts])
+  }, [roomId, user, getWebSocketUrl, addRealTimeMessage, applyMessageUpdate, applyMessageDeletion, applyReactions, resolveSubmit, connectionAttempts])
 
   const disconnectWebSocket = useCallback(() => {
     console.log('Disconnecting WebSocket for room:', roomId)
@@ -198,12 +210,23 @@ export function ChatRoom() {
   useEffect(() => {
     // Only load messages and connect WebSocket if we haven't already for this room
     if (roomId && loadedRoomRef.current !== roomId && !isLoading) {
-      console.log('Loading messages for room:', roomId)
+      console.log('Joining room:', roomId)
       loadedRoomRef.current = roomId
-      loadMessages(roomId, 50)
-
-      // Connect to WebSocket for real-time messages
-      setTimeout(() => connectWebSocket(), 100) // Small delay to ensure messages are loaded first
+      setJoinError(null)
+
+      // History and live events are for members only, so join before loading either
+      joinChatRoom(roomId)
+        .then(() => {
+          if (loadedRoomRef.current !== roomId) return
+          loadMessages(roomId, 50)
+
+          // Connect to WebSocket for real-time messages
+          setTimeout(() => connectWebSocket(), 100) // Small delay to ensure messages are loaded first
+        })
+        .catch((error) => {
+          console.error('Failed to join room:', error)
+          setJoinError(error instanceof Error ? error.message : 'Failed to join chatroom')
+        })
     }
 
     return () => {
@@ -309,23 +332,34 @@ export function ChatRoom() {
         </div>
       </div>
 
-      {/* Chat Messages */}
-      <div className="max-w-4xl mx-auto h-[calc(100vh-200px)] flex flex-col">
-        <div className="h-full bg-white mx-8 my-4 rounded-lg shadow-sm border border-gray-200">
-          <div className="h-full overflow-hidden">
-            <MessageList />
+      <div className="max-w-6xl mx-auto h-[calc(100vh-200px)] flex">
+        {/* Chat Messages */}
+        <div className="flex-1 min-w-0 flex flex-col">
+          <div className="h-full bg-white mx-8 my-4 rounded-lg shadow-sm border border-gray-200">
+            <div className="h-full overflow-hidden">
+              {joinError ? (
+                <div className="h-full flex items-center justify-center text-gray-600">
+                  {joinError}
+                </div>
+              ) : (
+                <MessageList />
+              )}
+            </div>
+          </div>
+
+          {/* Message Input */}
+          <div className="mx-8 mb-4">
+            {roomId && (
+              <MessageInput
+                roomId={roomId}
+                disabled={!isConnected && !user}
+              />
+            )}
           </div>
         </div>
 
-        {/* Message Input */}
-        <div className="mx-8 mb-4">
-          {roomId && (
-            <MessageInput
-              roomId={roomId}
-              disabled={!isConnected && !user}
-            />
-          )}
-        </div>
+        {/* Thread replies */}
+        {roomId && <ThreadPanel roomId={roomId} disabled={!isConnected && !user} />}
       </div>
     </div>
   )
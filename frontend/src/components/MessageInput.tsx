@@ -8,14 +8,16 @@ import { useAuth } from '@/contexts/AuthContext'
 
 interface MessageInputProps {
   roomId: string
+  // parentId posts into that message's thread
+  parentId?: string
   disabled?: boolean
 }
 
-export function MessageInput({ roomId, disabled = false }: MessageInputProps) {
+export function MessageInput({ roomId, parentId, disabled = false }: MessageInputProps) {
   const [message, setMessage] = useState('')
   const [isSubmitting, setIsSubmitting] = useState(false)
   const textareaRef = useRef<HTMLTextAreaElement>(null)
-  const { sendMessage, sendError, clearSendError } = useMessages()
+  const { sendMessage, sendError, clearSendError, pendingSubmits } = useMessages()
   const { user } = useAuth()
 
   const maxLength = 500
@@ -33,7 +35,7 @@ export function MessageInput({ roomId, disabled = false }: MessageInputProps) {
     setIsSubmitting(true)
 
     try {
-      await sendMessage(roomId, messageToSend, user.id, user.name)
+      await sendMessage(roomId, messageToSend, user.id, user.name, parentId)
       setMessage('')
 
       // Reset textarea height
@@ -90,7 +92,7 @@ export function MessageInput({ roomId, disabled = false }: MessageInputProps) {
             value={message}
             onChange={handleTextareaChange}
             onKeyDown={handleKeyDown}
-            placeholder="Type your message..."
+            placeholder={parentId ? 'Reply in thread...' : 'Type your message...'}
             className="min-h-[40px] max-h-[120px] resize-none pr-12"
             disabled={isDisabled}
             rows={1}
@@ -118,6 +120,12 @@ export function MessageInput({ roomId, disabled = false }: MessageInputProps) {
 
       {/* Error states */}
       <div className="flex flex-col space-y-1 mt-2">
+        {pendingSubmits > 0 && !sendError && (
+          <div className="flex items-center space-x-1 text-xs text-gray-500">
+            <Loader2 className="h-3 w-3 animate-spin" />
+            <span>Sending...</span>
+          </div>
+        )}
         {sendError && (
           <div className="flex items-center space-x-1 text-xs text-red-600">
             <AlertCircle className="h-3 w-3" />
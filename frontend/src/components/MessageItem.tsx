@@ -2,15 +2,17 @@ import type { Message } from '@/contexts/MessageContext'
 import { useAuth } from '@/contexts/AuthContext'
 // eslint-disable-next-line @typescript-eslint/no-unused-vars
 import { Avatar, AvatarFallback } from '@/components/ui/avatar'
-import { Bot } from 'lucide-react'
+import { Bot, MessageSquare } from 'lucide-react'
 import { cn } from '@/lib/utils'
 
 interface MessageItemProps {
   message: Message
   showAvatar?: boolean
+  // onOpenThread is omitted inside a thread, where replies cannot be replied to
+  onOpenThread?: (message: Message) => void
 }
 
-export function MessageItem({ message, showAvatar = true }: MessageItemProps) {
+export function MessageItem({ message, showAvatar = true, onOpenThread }: MessageItemProps) {
   const { user } = useAuth()
   const isOwnMessage = message.userId === user?.id
   const isBotMessage = message.type === 'bot' || (!message.userId && !message.userName)
@@ -78,17 +80,51 @@ export function MessageItem({ message, showAvatar = true }: MessageItemProps) {
           </span>
           <span className="text-xs text-gray-500">
             {formatTime(message.createdAt)}
+            {message.editedAt && ' (edited)'}
           </span>
         </div>
 
-        <div className={cn(
-          "text-sm break-words",
-          isOwnMessage ? "text-blue-800" :
-          isBotMessage ? "text-green-800 font-medium" :
-          "text-gray-700"
-        )}>
-          {message.text}
-        </div>
+        {message.deletedAt ? (
+          <div className="text-sm italic text-gray-400">This message was deleted</div>
+        ) : (
+          <div className={cn(
+            "text-sm break-words",
+            isOwnMessage ? "text-blue-800" :
+            isBotMessage ? "text-green-800 font-medium" :
+            "text-gray-700"
+          )}>
+            {message.text}
+          </div>
+        )}
+
+        {!message.deletedAt && message.reactions && message.reactions.length > 0 && (
+          <div className="mt-1 flex flex-wrap gap-1">
+            {message.reactions.map(r => (
+              <span
+                key={r.emoji}
+                className={cn(
+                  "rounded-full border px-2 py-0.5 text-xs",
+                  user && r.userIds.includes(user.id) ? "border-blue-300 bg-blue-100" : "border-gray-200 bg-white"
+                )}
+              >
+                {r.emoji} {r.count}
+              </span>
+            ))}
+          </div>
+        )}
+
+        {onOpenThread && (!message.deletedAt || !!message.replyCount) && (
+          <button
+            type="button"
+            onClick={() => onOpenThread(message)}
+            className="mt-1 flex items-center gap-1 text-xs text-blue-600 hover:underline"
+          >
+            <MessageSquare className="w-3 h-3" />
+            {message.replyCount
+              ? `${message.replyCount} ${message.replyCount === 1 ? 'reply' : 'replies'}`
+              : 'Reply'}
+          </button>
+        )}
       </div>
     </div>
   )
@@ -16,7 +16,8 @@ export function MessageList() {
     isLoadingMore,
     error,
     hasMoreMessages,
-    loadMoreMessages
+    loadMoreMessages,
+    openThread
   } = useMessages()
   const messagesEndRef = useRef<HTMLDivElement>(null)
   const [showScrollButton, setShowScrollButton] = useState(false)
@@ -138,6 +139,7 @@ export function MessageList() {
               key={message.id}
               message={message}
               showAvatar={showAvatar}
+              onOpenThread={roomId ? (m) => openThread(roomId, m.id) : undefined}
             />
           )
         })}
@@ -0,0 +1,52 @@
+import { X } from 'lucide-react'
+import { Button } from '@/components/ui/button'
+import { ScrollArea } from '@/components/ui/scroll-area'
+import { useMessages } from '@/contexts/MessageContext'
+import { MessageItem } from './MessageItem'
+import { MessageInput } from './MessageInput'
+
+interface ThreadPanelProps {
+  roomId: string
+  disabled?: boolean
+}
+
+export function ThreadPanel({ roomId, disabled = false }: ThreadPanelProps) {
+  const { thread, threadError, closeThread } = useMessages()
+
+  if (!thread && !threadError) {
+    return null
+  }
+
+  return (
+    <div className="w-96 flex-shrink-0 flex flex-col bg-white border border-gray-200 rounded-lg shadow-sm my-4 mr-8">
+      <div className="flex items-center justify-between px-4 py-3 border-b border-gray-200">
+        <h2 className="text-sm font-semibold text-gray-900">Thread</h2>
+        <Button variant="outline" size="sm" onClick={closeThread}>
+          <X className="h-4 w-4" />
+        </Button>
+      </div>
+
+      {threadError ? (
+        <div className="flex-1 flex items-center justify-center text-sm text-red-500">
+          {threadError}
+        </div>
+      ) : thread && (
+        <>
+          <ScrollArea className="flex-1">
+            <div className="border-b border-gray-200">
+              <MessageItem message={thread.parent} />
+            </div>
+            {thread.replies.map((reply, index) => (
+              <MessageItem
+                key={reply.id}
+                message={reply}
+                showAvatar={index === 0 || thread.replies[index - 1].userId !== reply.userId}
+              />
+            ))}
+          </ScrollArea>
+          <MessageInput roomId={roomId} parentId={thread.parent.id} disabled={disabled} />
+        </>
+      )}
+    </div>
+  )
+}
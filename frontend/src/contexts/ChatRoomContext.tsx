@@ -13,6 +13,7 @@ interface ChatRoomContextType {
   error: string | null
   createChatRoom: (title: string) => Promise<void>
   deleteChatRoom: (id: string) => Promise<void>
+  joinChatRoom: (id: string) => Promise<void>
   refreshChatRooms: () => Promise<void>
 }
 
@@ -168,6 +169,28 @@ export function ChatRoomProvider({ children }: ChatRoomProviderProps) {
     }
   }
 
+  // Joining is idempotent: public rooms add the user, rooms they already belong to are a no-op,
+  // and private or invite-only rooms they were never added to are refused.
+  const joinChatRoom = async (id: string) => {
+    if (!user) return
+
+    const response = await fetch(`${API_BASE_URL}/${id}/members`, {
+      method: 'POST',
+      headers: getAuthHeaders(),
+    })
+
+    if (!response.ok) {
+      let errorMessage = 'Failed to join chatroom'
+      try {
+        const errorData = await response.json()
+        errorMessage = errorData.error || errorMessage
+      } catch (e) {
+        console.error('Failed to parse error response:', e)
+      }
+      throw new Error(errorMessage)
+    }
+  }
+
   // Load chatrooms when user logs in
   useEffect(() => {
     if (user) {
@@ -183,6 +206,7 @@ export function ChatRoomProvider({ children }: ChatRoomProviderProps) {
     error,
     createChatRoom,
     deleteChatRoom,
+    joinChatRoom,
     refreshChatRooms
   }
 
@@ -1,6 +1,12 @@
 import { createContext, useContext, useState, type ReactNode, useCallback, useRef } from 'react'
 import { useAuth } from './AuthContext'
 
+export interface ReactionCount {
+  emoji: string
+  count: number
+  userIds: string[]
+}
+
 export interface Message {
   id: string
   roomId: string
@@ -9,6 +15,18 @@ export interface Message {
   text: string
   type: string
   createdAt: string
+  editedAt?: string
+  // parentId is set on thread replies; replyCount is kept on their parent
+  parentId?: string
+  replyCount?: number
+  // deletedAt marks a tombstone: the message stays in place with its text erased
+  deletedAt?: string
+  reactions?: ReactionCount[]
+}
+
+export interface Thread {
+  parent: Message
+  replies: Message[]
 }
 
 interface MessageContextType {
@@ -17,13 +35,23 @@ interface MessageContextType {
   isLoadingMore: boolean
   error: string | null
   sendError: string | null
+  // pendingSubmits counts messages sent over the WebSocket that the server has not acknowledged yet
+  pendingSubmits: number
   hasMoreMessages: boolean
   loadMessages: (roomId: string, limit?: number) => Promise<void>
   loadMoreMessages: (roomId: string) => Promise<void>
-  sendMessage: (roomId: string, text: string, userId: string, userName: string) => Promise<void>
+  thread: Thread | null
+  threadError: string | null
+  openThread: (roomId: string, msgId: string) => Promise<void>
+  closeThread: () => void
+  sendMessage: (roomId: string, text: string, userId: string, userName: string, parentId?: string) => Promise<void>
   registerWebSocket: (roomId: string, ws: WebSocket) => void
   unregisterWebSocket: (roomId: string) => void
   addRealTimeMessage: (message: Message) => void
+  applyMessageUpdate: (update: Pick<Message, 'id' | 'text' | 'editedAt'>) => void
+  applyMessageDeletion: (id: string, deletedAt: string) => void
+  applyReactions: (id: string, reactions: ReactionCount[]) => void
+  resolveSubmit: (id: string, error?: string, retry?: boolean) => void
   clearMessages: () => void
   clearSendError: () => void
 }
@@ -61,10 +89,17 @@ export function MessageProvider({ children }: MessageProviderProps) {
   const [hasMoreMessages, setHasMoreMessages] = useState(false)
   const [nextCursor, setNextCursor] = useState<string | null>(null)
   const [currentRoomId, setCurrentRoomId] = useState<string | null>(null)
+  const [thread, setThread] = useState<Thread | null>(null)
+  const [threadError, setThreadError] = useState<string | null>(null)
+  const [pendingSubmits, setPendingSubmits] = useState(0)
   const { user } = useAuth()
 
   // Store WebSocket connections for sending messages
   const wsConnectionsRef = useRef<Map<string, WebSocket>>(new Map())
+  // IDs of the thread replies received live, so redelivered ones are not counted twice
+  const seenRepliesRef = useRef<Set<string>>(new Set())
+  // IDs of WebSocket submits still waiting for their ack or error frame
+  const pendingSubmitsRef = useRef<Set<string>>(new Set())
 
   const getAuthHeaders = () => {
     const token = localStorage.getItem('accessToken')
@@ -175,8 +210,74 @@ export function MessageProvider({ children }: MessageProviderProps) {
     }
   }, [user, nextCursor, isLoadingMore, currentRoomId])
 
+  const sortByCreatedAt = (items: Message[]) =>
+    [...items].sort((a, b) => new Date(a.createdAt).getTime() - new Date(b.createdAt).getTime())
+
+  const openThread = useCallback(async (roomId: string, msgId: string) => {
+    if (!user) return
+
+    setThreadError(null)
+
+    try {
+      const response = await fetch(`${API_BASE_URL}/${roomId}/messages/${msgId}/thread?limit=100`, {
+        method: 'GET',
+        headers: getAuthHeaders(),
+      })
+
+      if (!response.ok) {
+        let errorMessage = 'Failed to load thread'
+        try {
+          const errorData = await response.json()
+          errorMessage = errorData.error || errorMessage
+        } catch (e) {
+          console.error('Failed to parse error response:', e)
+        }
+        throw new Error(errorMessage)
+      }
+
+      const data = await response.json()
+      setThread({ parent: data.parent, replies: sortByCreatedAt(data.items || []) })
+    } catch (error) {
+      console.error('Failed to load thread:', error)
+      setThreadError('Failed to load thread')
+    }
+  }, [user])
+
+  const closeThread = useCallback(() => {
+    setThread(null)
+    setThreadError(null)
+  }, [])
+
+  // Thread replies never join the room's message list: they go into the open thread, if it is
+  // theirs, and bump the reply count of their parent.
+  const addRealTimeReply = useCallback((reply: Message) => {
+    // Live events can arrive twice; count each reply once
+    if (seenRepliesRef.current.has(reply.id)) return
+    seenRepliesRef.current.add(reply.id)
+
+    setThread(prevThread => {
+      if (!prevThread || prevThread.parent.id !== reply.parentId) {
+        return prevThread
+      }
+      if (prevThread.replies.some(m => m.id === reply.id)) {
+        return prevThread
+      }
+      return {
+        parent: { ...prevThread.parent, replyCount: (prevThread.parent.replyCount || 0) + 1 },
+        replies: sortByCreatedAt([...prevThread.replies, reply])
+      }
+    })
+    setMessages(prevMessages => prevMessages.map(m =>
+      m.id === reply.parentId ? { ...m, replyCount: (m.replyCount || 0) + 1 } : m
+    ))
+  }, [])
+
   const addRealTimeMessage = useCallback((message: Message) => {
     console.log('Adding real-time message:', message)
+    if (message.parentId) {
+      addRealTimeReply(message)
+      return
+    }
     setMessages(prevMessages => {
       // Check if message already exists to prevent duplicates
       const messageExists = prevMessages.some(m => m.id === message.id)
@@ -192,9 +293,48 @@ export function MessageProvider({ children }: MessageProviderProps) {
 
       return newMessages
     })
+  }, [addRealTimeReply])
+
+  // Edits replace the text of the copy already shown, in the room or in the open thread.
+  const applyMessageUpdate = useCallback((update: Pick<Message, 'id' | 'text' | 'editedAt'>) => {
+    const apply = (m: Message) => m.id === update.id ? { ...m, text: update.text, editedAt: update.editedAt } : m
+    setMessages(prevMessages => prevMessages.map(apply))
+    setThread(prevThread => prevThread && {
+      parent: apply(prevThread.parent),
+      replies: prevThread.replies.map(apply)
+    })
+  }, [])
+
+  // Deletions turn the copy already shown into a tombstone, as history returns it.
+  const applyMessageDeletion = useCallback((id: string, deletedAt: string) => {
+    const apply = (m: Message) => m.id === id ? { ...m, text: '', deletedAt, reactions: undefined } : m
+    setMessages(prevMessages => prevMessages.map(apply))
+    setThread(prevThread => prevThread && {
+      parent: apply(prevThread.parent),
+      replies: prevThread.replies.map(apply)
+    })
+  }, [])
+
+  // Reaction events carry the message's full summary, which replaces the one shown.
+  const applyReactions = useCallback((id: string, reactions: ReactionCount[]) => {
+    const apply = (m: Message) => m.id === id ? { ...m, reactions } : m
+    setMessages(prevMessages => prevMessages.map(apply))
+    setThread(prevThread => prevThread && {
+      parent: apply(prevThread.parent),
+      replies: prevThread.replies.map(apply)
+    })
+  }, [])
+
+  // Settles a WebSocket submit once the server answers it; frames for other requests are ignored.
+  const resolveSubmit = useCallback((id: string, error?: string, retry?: boolean) => {
+    if (!pendingSubmitsRef.current.delete(id)) return
+    setPendingSubmits(pendingSubmitsRef.current.size)
+    if (error) {
+      setSendError(retry ? 'Message not sent. Please try again.' : `Message not sent: ${error}`)
+    }
   }, [])
 
-  const sendMessage = useCallback(async (roomId: string, text: string, userId: string, userName: string) => {
+  const sendMessage = useCallback(async (roomId: string, text: string, userId: string, userName: string, parentId?: string) => {
     if (!user) {
       throw new Error('User not authenticated')
     }
@@ -208,14 +348,15 @@ export function MessageProvider({ children }: MessageProviderProps) {
         console.log('Sending message via WebSocket:', { roomId, text, userId, userName })
 
         const messageData = {
+          v: 1,
           type: 'submit',
-          roomId,
-          userId,
-          userName,
-          text
+          id: crypto.randomUUID(),
+          payload: { roomId, text, ...(parentId ? { parentId } : {}) }
         }
 
         ws.send(JSON.stringify(messageData))
+        pendingSubmitsRef.current.add(messageData.id)
+        setPendingSubmits(pendingSubmitsRef.current.size)
         console.log('Message sent via WebSocket')
         return
       } catch (error) {
@@ -228,14 +369,13 @@ export function MessageProvider({ children }: MessageProviderProps) {
     try {
       console.log('Sending message via HTTP fallback:', { roomId, text })
 
-      const response = await fetch(`${API_BASE_URL}/submit`, {
+      const response = await fetch(`${API_BASE_URL}/${roomId}/messages`, {
         method: 'POST',
         headers: getAuthHeaders(),
         body: JSON.stringify({
-          roomId,
-          userId,
-          userName,
-          text
+          text,
+          ...(parentId ? { parentId } : {}),
+          clientMsgId: crypto.randomUUID()
         }),
       })
 
@@ -263,6 +403,11 @@ export function MessageProvider({ children }: MessageProviderProps) {
     setHasMoreMessages(false)
     setNextCursor(null)
     setCurrentRoomId(null)
+    setThread(null)
+    setThreadError(null)
+    seenRepliesRef.current.clear()
+    pendingSubmitsRef.current.clear()
+    setPendingSubmits(0)
   }, [])
 
   // Function to register WebSocket connection for sending (used internally)
@@ -283,13 +428,22 @@ export function MessageProvider({ children }: MessageProviderProps) {
     isLoadingMore,
     error,
     sendError,
+    pendingSubmits,
     hasMoreMessages,
     loadMessages,
     loadMoreMessages,
+    thread,
+    threadError,
+    openThread,
+    closeThread,
     sendMessage,
     registerWebSocket: registerWebSocketConnection,
     unregisterWebSocket: unregisterWebSocketConnection,
     addRealTimeMessage,
+    applyMessageUpdate,
+    applyMessageDeletion,
+    applyReactions,
+    resolveSubmit,
     clearMessages,
     clearSendError
   }
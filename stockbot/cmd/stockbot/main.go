@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"stockbot/config"
 	botamqp "stockbot/internal/amqp"
@@ -11,6 +16,8 @@ import (
 	"stockbot/internal/echo"
 	"stockbot/internal/help"
 	"stockbot/internal/stock"
+
+	"github.com/rabbitmq/amqp091-go"
 )
 
 func main() {
@@ -22,14 +29,8 @@ func main() {
 	}
 	defer client.Close()
 
-	if err := client.Declare(cfg.ExchangeName, cfg.ExchangeType, cfg.QueueName, cfg.RequestedRoutingKey); err != nil {
-		log.Fatalf("amqp declare: %v", err)
-	}
-
-	msgs, err := client.Consume(cfg.QueueName)
-	if err != nil {
-		log.Fatalf("amqp consume: %v", err)
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	log.Printf("stockbot listening on %s (%s)", cfg.QueueName, cfg.RequestedRoutingKey)
 	stockHandler := stock.NewHandler(cfg.StockCSVURLTemplate, nil)
@@ -41,18 +42,31 @@ func main() {
 	reg.Register("help", help.Handle)
 	reg.Register("stock", stockHandler.Handle)
 
-	for d := range msgs {
+	topology := botamqp.Topology{
+		Exchange:     cfg.ExchangeName,
+		ExchangeType: cfg.ExchangeType,
+		Queue:        cfg.QueueName,
+		LegacyQueue:  cfg.LegacyQueueName,
+		RoutingKey:   cfg.RequestedRoutingKey,
+	}
+	// Serve reconnects and resumes consuming after a broker restart; it only returns on shutdown.
+	// A failed response publish is retried; an unreadable request is dead-lettered right away.
+	err = client.Serve(ctx, topology, func(d amqp091.Delivery) error {
 		var req contracts.BotRequest
 		if err := json.Unmarshal(d.Body, &req); err != nil {
-			log.Printf("skip invalid payload: %v", err)
-			continue
+			log.Printf("dead-lettering invalid payload: %v", err)
+			return botamqp.Permanent(err)
 		}
 		if resp, ok := reg.Dispatch(req); ok {
+			if req.RequestID != "" {
+				resp.IdempotencyKey = "bot:" + req.RequestID
+			}
 			if err := client.PublishJSON(cfg.ExchangeName, cfg.ResponseRoutingKey, resp); err != nil {
-				log.Printf("publish response error: %v", err)
-			} else {
-				log.Printf("handled %s for room %s", req.Command, resp.RoomID)
+				return fmt.Errorf("publish response: %w", err)
 			}
+			log.Printf("handled %s for room %s", req.Command, resp.RoomID)
 		}
-	}
+		return nil
+	})
+	log.Printf("stockbot stopped: %v", err)
 }
@@ -13,6 +13,7 @@ type AppConfig struct {
 	RequestedRoutingKey string
 	ResponseRoutingKey  string
 	QueueName           string
+	LegacyQueueName     string
 	StockCSVURLTemplate string
 }
 
@@ -31,7 +32,8 @@ func Load() AppConfig {
 		ExchangeType:        getEnv("RABBITMQ_EXCHANGE_TYPE", "topic"),
 		RequestedRoutingKey: getEnv("RABBITMQ_KEY_REQUESTED", "bot.requested"),
 		ResponseRoutingKey:  getEnv("RABBITMQ_KEY_RESPONSE", "bot.response.submit"),
-		QueueName:           getEnv("RABBITMQ_QUEUE", "bot.stockbot"),
+		QueueName:           getEnv("RABBITMQ_QUEUE", "bot.stockbot.v2"),
+		LegacyQueueName:     getEnv("RABBITMQ_LEGACY_QUEUE", "bot.stockbot"),
 		StockCSVURLTemplate: getEnv("STOCK_CSV_URL_TEMPLATE", "https://stooq.com/q/l/?s={{symbol}}&f=sd2t2ohlcv&h&e=csv"),
 	}
 }
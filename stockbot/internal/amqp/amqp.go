@@ -3,45 +3,133 @@ package amqp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	"github.com/rabbitmq/amqp091-go"
 )
 
-// Client wraps a RabbitMQ connection and channel.
+// maxBackoff caps the delay between connection attempts.
+const maxBackoff = 3 * time.Second
+
+// confirmTimeout bounds the wait for the broker to confirm a publish.
+const confirmTimeout = 5 * time.Second
+
+// publishAttempts is how often PublishJSON tries before giving up.
+const publishAttempts = 3
+
+// maxRetries is how often a failed delivery is retried before it is dead-lettered.
+const maxRetries = 5
+
+// retryDelay is how long a failed delivery waits in the retry queue, in milliseconds.
+const retryDelay = 5000
+
+// prefetch bounds the unacknowledged deliveries held at once.
+const prefetch = 16
+
+const (
+	// retryHeader counts the retries a delivery has been through.
+	retryHeader = "x-retry-count"
+	// errorHeader carries the error that sent a delivery to the dead-letter queue.
+	errorHeader = "x-last-error"
+)
+
+// errConnectionLost reports that the delivery stream ended without a close reason.
+var errConnectionLost = errors.New("connection lost")
+
+// ErrNacked is returned when the broker refused to take responsibility for a message.
+var ErrNacked = errors.New("publish not confirmed by broker")
+
+// Client wraps a RabbitMQ connection and channel. Serve replaces both after a connection loss.
 type Client struct {
+	uri string
+
+	mu      sync.RWMutex
 	conn    *amqp091.Connection
 	channel *amqp091.Channel
 }
 
+// permanentError marks a failure that retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that Serve dead-letters the delivery that caused it without retrying it.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// Topology is the exchange and queue binding the bot consumes from. Failed deliveries wait in
+// Queue+".retry" before returning to Queue, and end up in Queue+".dead" through the Queue+".dlx"
+// exchange once retries run out, the same layout the chat backend uses for its queues.
+type Topology struct {
+	Exchange     string
+	ExchangeType string
+	Queue        string
+	RoutingKey   string
+	// LegacyQueue is the queue used before dead-lettering was added. Its remaining requests are
+	// moved to Queue and it is removed.
+	LegacyQueue string
+}
+
 // New connects to RabbitMQ and returns a client.
 func New(uri string) (*Client, error) {
+	c := &Client{uri: uri}
 	deadline := time.Now().Add(60 * time.Second)
 	var lastErr error
 	for attempt := 0; time.Now().Before(deadline); attempt++ {
-		conn, err := amqp091.Dial(uri)
-		if err == nil {
-			ch, chErr := conn.Channel()
-			if chErr == nil {
-				return &Client{conn: conn, channel: ch}, nil
-			}
-			lastErr = fmt.Errorf("open channel: %w", chErr)
-			_ = conn.Close()
-		} else {
-			lastErr = fmt.Errorf("dial rabbitmq: %w", err)
-		}
-		sleep := time.Duration(200*(1<<attempt)) * time.Millisecond
-		if sleep > 3*time.Second {
-			sleep = 3 * time.Second
+		if lastErr = c.dial(); lastErr == nil {
+			return c, nil
 		}
-		time.Sleep(sleep)
+		time.Sleep(backoff(attempt))
 	}
 	return nil, lastErr
 }
 
+// backoff is the delay before connection attempt n+1: exponential from 200ms, capped at maxBackoff.
+func backoff(attempt int) time.Duration {
+	if attempt > 4 {
+		return maxBackoff
+	}
+	sleep := time.Duration(200*(1<<attempt)) * time.Millisecond
+	if sleep > maxBackoff {
+		sleep = maxBackoff
+	}
+	return sleep
+}
+
+// dial opens a new connection and channel and installs them, closing any previous ones.
+func (c *Client) dial() error {
+	conn, err := amqp091.Dial(c.uri)
+	if err != nil {
+		return fmt.Errorf("dial rabbitmq: %w", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("open channel: %w", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("enable publisher confirms: %w", err)
+	}
+	c.Close()
+	c.mu.Lock()
+	c.conn, c.channel = conn, ch
+	c.mu.Unlock()
+	return nil
+}
+
 // Close closes the channel and connection.
 func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.channel != nil {
 		_ = c.channel.Close()
 	}
@@ -50,9 +138,71 @@ func (c *Client) Close() {
 	}
 }
 
-// Declare sets up exchange and queue binding.
+// Serve declares t and passes every delivery from its queue to handle until ctx is done. A
+// delivery is acknowledged once handle returns nil; on an error it is retried up to maxRetries
+// times, or dead-lettered at once if the error is Permanent. When the connection or channel is
+// lost it reconnects with backoff, declares t again and resumes consuming, so a broker restart
+// does not require restarting the bot.
+func (c *Client) Serve(ctx context.Context, t Topology, handle func(amqp091.Delivery) error) error {
+	for {
+		err := c.consume(ctx, t, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("amqp: consumer stopped: %v; reconnecting", err)
+		for attempt := 0; ; attempt++ {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff(attempt)):
+			}
+			if err := c.dial(); err != nil {
+				log.Printf("amqp: reconnect attempt %d failed: %v", attempt+1, err)
+				continue
+			}
+			log.Printf("amqp: reconnected")
+			break
+		}
+	}
+}
+
+// consume runs one consumer session and returns why it ended.
+func (c *Client) consume(ctx context.Context, t Topology, handle func(amqp091.Delivery) error) error {
+	if err := c.Declare(t.Exchange, t.ExchangeType, t.Queue, t.RoutingKey); err != nil {
+		return err
+	}
+	msgs, err := c.Consume(t.Queue)
+	if err != nil {
+		return err
+	}
+	if t.LegacyQueue != "" && t.LegacyQueue != t.Queue {
+		go c.drainLegacyQueue(t)
+	}
+	c.mu.RLock()
+	closed := c.channel.NotifyClose(make(chan *amqp091.Error, 1))
+	c.mu.RUnlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case d, ok := <-msgs:
+			if !ok {
+				if reason, ok := <-closed; ok && reason != nil {
+					return reason
+				}
+				return errConnectionLost
+			}
+			c.settle(t, d, handle(d))
+		}
+	}
+}
+
+// Declare sets up the exchange, the queue with its retry and dead-letter queues, and the binding.
 func (c *Client) Declare(exchangeName, exchangeType, queueName, routingKey string) error {
-	if err := c.channel.ExchangeDeclare(
+	c.mu.RLock()
+	ch := c.channel
+	c.mu.RUnlock()
+	if err := ch.ExchangeDeclare(
 		exchangeName,
 		exchangeType,
 		true,  // durable
@@ -64,31 +214,103 @@ func (c *Client) Declare(exchangeName, exchangeType, queueName, routingKey strin
 		return fmt.Errorf("declare exchange: %w", err)
 	}
 
-	q, err := c.channel.QueueDeclare(
+	if err := ch.ExchangeDeclare(queueName+".dlx", "fanout", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare dead-letter exchange: %w", err)
+	}
+	if _, err := ch.QueueDeclare(queueName+".dead", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare dead-letter queue: %w", err)
+	}
+	if err := ch.QueueBind(queueName+".dead", "", queueName+".dlx", false, nil); err != nil {
+		return fmt.Errorf("bind dead-letter queue: %w", err)
+	}
+	// Retries expire from the retry queue back into the queue through the default exchange.
+	if _, err := ch.QueueDeclare(queueName+".retry", true, false, false, false, amqp091.Table{
+		"x-message-ttl":             int32(retryDelay),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": queueName,
+	}); err != nil {
+		return fmt.Errorf("declare retry queue: %w", err)
+	}
+
+	q, err := ch.QueueDeclare(
 		queueName,
 		true,  // durable
 		false, // autoDelete
 		false, // exclusive
 		false, // noWait
-		nil,
+		amqp091.Table{"x-dead-letter-exchange": queueName + ".dlx"},
 	)
 	if err != nil {
 		return fmt.Errorf("declare queue: %w", err)
 	}
 
-	if err := c.channel.QueueBind(q.Name, routingKey, exchangeName, false, nil); err != nil {
+	if err := ch.QueueBind(q.Name, routingKey, exchangeName, false, nil); err != nil {
 		return fmt.Errorf("bind queue: %w", err)
 	}
 
 	return nil
 }
 
-// Consume starts consuming deliveries from the queue.
+// drainLegacyQueue moves the messages of t.LegacyQueue, which lacks dead-lettering and cannot be
+// redeclared with it, into t.Queue. The legacy queue is unbound first so it stops collecting
+// requests, and deleted once drained unless an older release still consumes from it. It runs on
+// a channel of its own; failures are logged and the drain is tried again after the next reconnect.
+func (c *Client) drainLegacyQueue(t Topology) {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+	ch, err := conn.Channel()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	if _, err := ch.QueueDeclarePassive(t.LegacyQueue, true, false, false, false, nil); err != nil {
+		// Already gone; the failed passive declaration closed only this channel.
+		return
+	}
+	if err := ch.QueueUnbind(t.LegacyQueue, t.RoutingKey, t.Exchange, nil); err != nil {
+		log.Printf("amqp: failed to unbind legacy queue %s: %v", t.LegacyQueue, err)
+		return
+	}
+	moved := 0
+	for {
+		d, ok, err := ch.Get(t.LegacyQueue, false)
+		if err != nil {
+			log.Printf("amqp: failed to drain legacy queue %s: %v", t.LegacyQueue, err)
+			return
+		}
+		if !ok {
+			break
+		}
+		pub := amqp091.Publishing{Headers: d.Headers, ContentType: d.ContentType, DeliveryMode: amqp091.Persistent, MessageId: d.MessageId, Timestamp: d.Timestamp, Body: d.Body}
+		if err := c.publishConfirmed("", t.Queue, pub); err != nil {
+			_ = d.Nack(false, true)
+			log.Printf("amqp: failed to move requests from legacy queue %s: %v", t.LegacyQueue, err)
+			return
+		}
+		_ = d.Ack(false)
+		moved++
+	}
+	if moved > 0 {
+		log.Printf("amqp: moved %d requests from legacy queue %s to %s", moved, t.LegacyQueue, t.Queue)
+	}
+	if _, err := ch.QueueDelete(t.LegacyQueue, true, false, false); err != nil {
+		log.Printf("amqp: legacy queue %s not removed: %v", t.LegacyQueue, err)
+	}
+}
+
+// Consume starts consuming deliveries from the queue. Deliveries must be acknowledged.
 func (c *Client) Consume(queueName string) (<-chan amqp091.Delivery, error) {
-	msgs, err := c.channel.Consume(
+	c.mu.RLock()
+	ch := c.channel
+	c.mu.RUnlock()
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf("qos: %w", err)
+	}
+	msgs, err := ch.Consume(
 		queueName,
 		"",    // consumer tag
-		true,  // autoAck (simple bot)
+		false, // autoAck
 		false, // exclusive
 		false, // noLocal
 		false, // noWait
@@ -100,16 +322,109 @@ func (c *Client) Consume(queueName string) (<-chan amqp091.Delivery, error) {
 	return msgs, nil
 }
 
-// PublishJSON marshals and publishes a JSON payload.
+// settle acknowledges a handled delivery. A failed one is parked in the retry queue until it has
+// been retried maxRetries times, then forwarded to the dead-letter exchange with the error
+// recorded. If the broker does not confirm either, the delivery is requeued or, when it was due
+// for dead-lettering, rejected into the dead-letter exchange without the error.
+func (c *Client) settle(t Topology, d amqp091.Delivery, err error) {
+	if err == nil {
+		_ = d.Ack(false)
+		return
+	}
+	retries := retryCount(d)
+	var perm permanentError
+	if !errors.As(err, &perm) && retries < maxRetries {
+		log.Printf("amqp: retrying delivery (%d/%d): %v", retries+1, maxRetries, err)
+		if ferr := c.forward("", t.Queue+".retry", d, retries+1, ""); ferr != nil {
+			log.Printf("amqp: failed to park delivery for a retry: %v", ferr)
+			_ = d.Nack(false, true)
+			return
+		}
+		_ = d.Ack(false)
+		return
+	}
+	log.Printf("amqp: dead-lettering delivery after %d retries: %v", retries, err)
+	if ferr := c.forward(t.Queue+".dlx", d.RoutingKey, d, retries, err.Error()); ferr != nil {
+		_ = d.Nack(false, false)
+		return
+	}
+	_ = d.Ack(false)
+}
+
+// forward publishes a copy of d with its retry count and, if not empty, the reason it failed.
+func (c *Client) forward(exchange, routingKey string, d amqp091.Delivery, retries int, reason string) error {
+	headers := amqp091.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[retryHeader] = int32(retries)
+	if reason != "" {
+		headers[errorHeader] = reason
+	}
+	return c.publishConfirmed(exchange, routingKey, amqp091.Publishing{
+		Headers:      headers,
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp091.Persistent,
+		MessageId:    d.MessageId,
+		Timestamp:    d.Timestamp,
+		Body:         d.Body,
+	})
+}
+
+// retryCount reads the retry counter of d.
+func retryCount(d amqp091.Delivery) int {
+	switch n := d.Headers[retryHeader].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
+
+// PublishJSON marshals and publishes a persistent JSON payload and returns once the broker has
+// confirmed it. Nacks and confirmation timeouts are retried up to publishAttempts times.
 func (c *Client) PublishJSON(exchange, routingKey string, payload any) error {
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("marshal: %w", err)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	pub := amqp091.Publishing{
+		ContentType:  "application/json",
+		Body:         body,
+		DeliveryMode: amqp091.Persistent,
+	}
+	for attempt := 0; attempt < publishAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff(attempt - 1))
+		}
+		if err = c.publishConfirmed(exchange, routingKey, pub); err == nil {
+			return nil
+		}
+		log.Printf("amqp: publish %s attempt %d failed: %v", routingKey, attempt+1, err)
+	}
+	return err
+}
+
+// publishConfirmed publishes once and waits up to confirmTimeout for the broker's confirmation.
+func (c *Client) publishConfirmed(exchange, routingKey string, pub amqp091.Publishing) error {
+	ctx, cancel := context.WithTimeout(context.Background(), confirmTimeout)
 	defer cancel()
-	return c.channel.PublishWithContext(ctx, exchange, routingKey, false, false, amqp091.Publishing{
-		ContentType: "application/json",
-		Body:        body,
-	})
+	c.mu.RLock()
+	ch := c.channel
+	c.mu.RUnlock()
+	dc, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, false, false, pub)
+	if err != nil {
+		return err
+	}
+	acked, err := dc.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return ErrNacked
+	}
+	return nil
 }
@@ -7,10 +7,14 @@ type BotRequest struct {
 	RoomID         string `json:"roomId"`
 	RequestUserID  string `json:"requestUserId"`
 	RequestedAtISO string `json:"requestedAt"`
+	// RequestID identifies the invoking submission; it is empty for older publishers.
+	RequestID string `json:"requestId,omitempty"`
 }
 
 // BotResponseSubmit models the outgoing payload for bot.response.submit
 type BotResponseSubmit struct {
 	RoomID string `json:"roomId"`
 	Text   string `json:"text"`
+	// IdempotencyKey lets the backend store a redelivered response only once.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
 }